@@ -0,0 +1,171 @@
+package bbhw
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/// ---------- ADC calibration and sensor transfer functions ---------------
+
+// Converts one quantity into another, e.g. measured into true volts or volts into °C
+type ADCTransfer interface {
+	Convert(x float64) (float64, error)
+}
+
+// Adapter to use an ordinary function as ADCTransfer
+type ADCTransferFunc func(x float64) (float64, error)
+
+func (f ADCTransferFunc) Convert(x float64) (float64, error) {
+	return f(x)
+}
+
+// y = Gain*x + Offset
+type LinearCalibration struct {
+	Gain, Offset float64
+}
+
+// Calibration from two readings of known inputs, e.g. measured 0.012V at 0V and 1.790V at 1.8V
+func NewTwoPointCalibration(measured1, actual1, measured2, actual2 float64) (*LinearCalibration, error) {
+	if measured1 == measured2 {
+		return nil, fmt.Errorf("calibration points must have different measured values")
+	}
+	gain := (actual2 - actual1) / (measured2 - measured1)
+	return &LinearCalibration{Gain: gain, Offset: actual1 - gain*measured1}, nil
+}
+
+func (cal *LinearCalibration) Convert(x float64) (float64, error) {
+	if cal == nil {
+		panic("cal == nil")
+	}
+	return cal.Gain*x + cal.Offset, nil
+}
+
+type ADCCalibrationPoint struct {
+	Measured, Actual float64
+}
+
+// Piecewise linear interpolation between calibration points, extrapolating the outer segments
+type LUTCalibration struct {
+	points []ADCCalibrationPoint
+}
+
+// Takes at least two points in any order, no two with the same measured value
+func NewLUTCalibration(points []ADCCalibrationPoint) (*LUTCalibration, error) {
+	if len(points) < 2 {
+		return nil, fmt.Errorf("lookup table needs at least two points, got %d", len(points))
+	}
+	cal := &LUTCalibration{points: append([]ADCCalibrationPoint(nil), points...)}
+	sort.Slice(cal.points, func(i, j int) bool { return cal.points[i].Measured < cal.points[j].Measured })
+	for i := 1; i < len(cal.points); i++ {
+		if cal.points[i].Measured == cal.points[i-1].Measured {
+			return nil, fmt.Errorf("lookup table has measured value %g twice", cal.points[i].Measured)
+		}
+	}
+	return cal, nil
+}
+
+func (cal *LUTCalibration) Convert(x float64) (float64, error) {
+	if cal == nil {
+		panic("cal == nil")
+	}
+	// first point above x, the segment ends there
+	i := sort.Search(len(cal.points), func(i int) bool { return cal.points[i].Measured > x })
+	if i == 0 {
+		i = 1
+	} else if i == len(cal.points) {
+		i = len(cal.points) - 1
+	}
+	p0, p1 := cal.points[i-1], cal.points[i]
+	return p0.Actual + (x-p0.Measured)*(p1.Actual-p0.Actual)/(p1.Measured-p0.Measured), nil
+}
+
+// Input voltage of a resistive divider from the voltage across RBottom
+type VoltageDivider struct {
+	RTop, RBottom float64
+}
+
+func NewVoltageDivider(rtop, rbottom float64) (*VoltageDivider, error) {
+	if rtop < 0 || rbottom <= 0 {
+		return nil, fmt.Errorf("invalid voltage divider %gΩ/%gΩ", rtop, rbottom)
+	}
+	return &VoltageDivider{RTop: rtop, RBottom: rbottom}, nil
+}
+
+func (div *VoltageDivider) Convert(volts float64) (float64, error) {
+	if div == nil {
+		panic("div == nil")
+	}
+	return volts * (div.RTop + div.RBottom) / div.RBottom, nil
+}
+
+// Temperature in °C of an NTC thermistor in a divider with RSeries, powered by VRef, from the voltage at their junction.
+// Uses the Beta equation with the resistance R0 at T0 °C, e.g. 10kΩ at 25°C with a Beta of 3950.
+type NTCThermistor struct {
+	R0, T0, Beta float64
+	RSeries      float64
+	VRef         float64
+	// the NTC is between the ADC input and ground, RSeries between VRef and the input. Otherwise the other way round.
+	ToGround bool
+}
+
+const kelvin_at_zero_celsius_ = 273.15
+
+func NewNTCThermistor(r0, t0, beta, rseries, vref float64, toground bool) (*NTCThermistor, error) {
+	if r0 <= 0 || beta <= 0 || rseries <= 0 || vref <= 0 || t0 <= -kelvin_at_zero_celsius_ {
+		return nil, fmt.Errorf("invalid NTC parameters")
+	}
+	return &NTCThermistor{R0: r0, T0: t0, Beta: beta, RSeries: rseries, VRef: vref, ToGround: toground}, nil
+}
+
+func (ntc *NTCThermistor) Convert(volts float64) (float64, error) {
+	if ntc == nil {
+		panic("ntc == nil")
+	}
+	// at either rail the NTC is shorted or open
+	if volts <= 0 || volts >= ntc.VRef {
+		return math.NaN(), fmt.Errorf("%gV is outside (0,%g)V, NTC shorted or disconnected", volts, ntc.VRef)
+	}
+	r := ntc.RSeries * (ntc.VRef - volts) / volts
+	if ntc.ToGround {
+		r = ntc.RSeries * volts / (ntc.VRef - volts)
+	}
+	return 1/(1/(ntc.T0+kelvin_at_zero_celsius_)+math.Log(r/ntc.R0)/ntc.Beta) - kelvin_at_zero_celsius_, nil
+}
+
+// Reads an ADC in volts and passes the value through transfers in order, e.g. a calibration and then an NTCThermistor
+type ADCSensor struct {
+	adc       ScaledADC
+	transfers []ADCTransfer
+}
+
+func NewADCSensor(adc ScaledADC, transfers ...ADCTransfer) *ADCSensor {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	return &ADCSensor{adc: adc, transfers: transfers}
+}
+
+func (sensor *ADCSensor) Read() (value float64, err error) {
+	if sensor == nil {
+		panic("sensor == nil")
+	}
+	if value, err = sensor.adc.ReadVolts(); err != nil {
+		return
+	}
+	return sensor.Convert(value)
+}
+
+// Passes volts through the transfers, e.g. for samples of an IIOBuffer after scaling them
+func (sensor *ADCSensor) Convert(volts float64) (value float64, err error) {
+	if sensor == nil {
+		panic("sensor == nil")
+	}
+	value = volts
+	for _, transfer := range sensor.transfers {
+		if value, err = transfer.Convert(value); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,100 @@
+package bbhw
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func Test_ADCCalibration(t *testing.T) {
+	linear, err := NewTwoPointCalibration(0.012, 0, 1.790, 1.8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := linear.Convert(0.901); !closeTo(v, 0.9) {
+		t.Errorf("two-point calibration gives %g instead of 0.9", v)
+	}
+	if _, err := NewTwoPointCalibration(1, 0, 1, 1.8); err == nil {
+		t.Error("two points with the same measured value should be refused")
+	}
+
+	lut, err := NewLUTCalibration([]ADCCalibrationPoint{{1.0, 10}, {0, 0}, {0.5, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, want := range map[float64]float64{0: 0, 0.25: 1, 0.5: 2, 0.75: 6, 1: 10, 1.25: 14, -0.5: -2} {
+		if v, _ := lut.Convert(x); !closeTo(v, want) {
+			t.Errorf("lookup table gives %g for %g instead of %g", v, x, want)
+		}
+	}
+	if _, err := NewLUTCalibration([]ADCCalibrationPoint{{0, 0}, {0, 1}}); err == nil {
+		t.Error("lookup table with the same measured value twice should be refused")
+	}
+
+	divider, _ := NewVoltageDivider(30000, 10000)
+	if v, _ := divider.Convert(1.5); !closeTo(v, 6) {
+		t.Errorf("divider gives %g instead of 6V", v)
+	}
+
+	ntc, err := NewNTCThermistor(10000, 25, 3950, 10000, 1.8, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// resistance at 0°C by the Beta equation
+	r := 10000 * math.Exp(3950*(1/273.15-1/298.15))
+	for volts, want := range map[float64]float64{0.9: 25, 1.8 * r / (r + 10000): 0} {
+		if v, err := ntc.Convert(volts); err != nil || !closeTo(v, want) {
+			t.Errorf("NTC gives %g°C, %v for %gV instead of %g°C", v, err, volts, want)
+		}
+	}
+	ntc.ToGround = false
+	if v, _ := ntc.Convert(1.8 * 10000 / (r + 10000)); !closeTo(v, 0) {
+		t.Errorf("NTC towards VRef gives %g°C instead of 0°C", v)
+	}
+	if _, err := ntc.Convert(1.8); err == nil {
+		t.Error("NTC at the rail should be an error")
+	}
+}
+
+func Test_ADCSensor(t *testing.T) {
+	adc := NewFakeADCOrPanic(0)
+	adc.SimulateRaw(2048, nil)
+	if raw, _ := adc.ReadRaw(); raw != 2048 || adc.ReadValue() != 900 {
+		t.Errorf("raw 2048 reads as %d, %dmV", raw, adc.ReadValue())
+	}
+	divider, _ := NewVoltageDivider(10000, 10000)
+	sensor := NewADCSensor(adc, divider, ADCTransferFunc(func(v float64) (float64, error) { return v * 10, nil }))
+	if v, err := sensor.Read(); err != nil || !closeTo(v, 18) {
+		t.Errorf("sensor reads %g, %v instead of 18", v, err)
+	}
+	failure := errors.New("disconnected")
+	adc.SimulateValue(900, failure)
+	if _, err := sensor.Read(); err != failure {
+		t.Errorf("sensor should pass on the error of the ADC, got %v", err)
+	}
+}
+
+func Test_SysfsADCScale(t *testing.T) {
+	useFakeFilesystemRoot(t, map[string]string{
+		"/sys/devices/platform/ocp/44e0d000.tscadc/TI-am335x-adc/iio:device0/in_voltage1_raw":    "3000\n",
+		"/sys/devices/platform/ocp/44e0d000.tscadc/TI-am335x-adc/iio:device0/in_voltage_scale":   "0.5\n",
+		"/sys/devices/platform/ocp/44e0d000.tscadc/TI-am335x-adc/iio:device0/in_voltage1_offset": "-10\n",
+	})
+	adc, err := NewSysfsADC(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scale, offset := adc.Scale(); scale != 0.5 || offset != -10 {
+		t.Errorf("scale %g and offset %g", scale, offset)
+	}
+	if raw, err := adc.ReadRaw(); err != nil || raw != 3000 {
+		t.Errorf("raw %d, %v", raw, err)
+	}
+	if volts, err := adc.ReadVolts(); err != nil || !closeTo(volts, 1.495) || adc.ReadValue() != 1495 {
+		t.Errorf("%gV, %dmV, %v", volts, adc.ReadValue(), err)
+	}
+}
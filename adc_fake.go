@@ -1,17 +1,29 @@
 package bbhw
 
+import (
+	"math"
+	"sync"
+	"time"
+)
+
 // SysFS managed ADCs ------------------------------------
 
 type FakeADC struct {
 	Number uint
-	value  uint16
+	volts  float64
+	scale  float64 // mV per count
 	err    error
+	lock   sync.Mutex
+	source FakeADCSource
+	clock  Clock
+	start  time.Time
 }
 
-// Instantinate a new Fake ADC for Simulation
+// Instantinate a new Fake ADC for Simulation. Safe to use from several goroutines. Its counts have the scale of the AM335x ADC, 12 bit for 1.8V.
 func NewFakeADC(number uint) (adc *FakeADC, err error) {
 	adc = new(FakeADC)
 	adc.Number = number
+	adc.scale = sysfs_adc_default_scale_
 	return adc, nil
 }
 
@@ -20,27 +32,107 @@ func NewFakeADCOrPanic(number uint) (adc *FakeADC) {
 	return
 }
 
+// simulated voltage in mV, rounded
 func (adc *FakeADC) ReadValue() (value uint16) {
 	if adc == nil {
 		panic("adc == nil")
 	}
-	return adc.value
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.sample()
+	return adc.millivolts()
+}
+
+// takes the next value from the source, if any
+// must be called with adc.lock held
+func (adc *FakeADC) sample() {
+	if adc.source != nil {
+		adc.volts, adc.err = adc.source.Volts(adc.clock.Now().Sub(adc.start))
+	}
+}
+
+// must be called with adc.lock held
+func (adc *FakeADC) millivolts() (value uint16) {
+	if mv := math.Round(adc.volts * 1000); mv > 0 {
+		value = uint16(mv)
+	}
+	return
 }
 
 func (adc *FakeADC) CheckErrorOccurred() error {
 	if adc == nil {
 		panic("adc == nil")
 	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
 	return adc.err
 }
 
 func (adc *FakeADC) ReadValueCheckError() (value uint16, err error) {
-	value = adc.ReadValue()
-	err = adc.CheckErrorOccurred()
-	return
+	if adc == nil {
+		panic("adc == nil")
+	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.sample()
+	return adc.millivolts(), adc.err
+}
+
+// simulated voltage in counts, rounded
+func (adc *FakeADC) ReadRaw() (int64, error) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.sample()
+	return int64(math.Round(adc.volts * 1000 / adc.scale)), adc.err
 }
 
+func (adc *FakeADC) ReadVolts() (float64, error) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.sample()
+	return adc.volts, adc.err
+}
+
+// value in mV. Like SimulateVolts and SimulateRaw replaces the source set by SimulateSource.
 func (adc *FakeADC) SimulateValue(value uint16, err error) {
-	adc.value = value
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.source = nil
+	adc.volts = float64(value) / 1000
+	adc.err = err
+}
+
+func (adc *FakeADC) SimulateVolts(volts float64, err error) {
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.source = nil
+	adc.volts = volts
 	adc.err = err
 }
+
+func (adc *FakeADC) SimulateRaw(raw int64, err error) {
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.source = nil
+	adc.volts = float64(raw) * adc.scale / 1000
+	adc.err = err
+}
+
+// Every read takes its value from source at the time since this call, measured by clock.
+// Pass nil as clock to play back in real time, or a FakeClock for virtual time.
+// The source is called with the ADC locked and must not use it.
+func (adc *FakeADC) SimulateSource(source FakeADCSource, clock Clock) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	adc.lock.Lock()
+	defer adc.lock.Unlock()
+	adc.source, adc.clock = source, clockOrSystemClock(clock)
+	adc.start = adc.clock.Now()
+}
@@ -0,0 +1,179 @@
+package bbhw
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/// ---------- FakeADC value sources ---------------
+
+// Simulated input of a FakeADC, in V at time t since FakeADC.SimulateSource
+type FakeADCSource interface {
+	Volts(t time.Duration) (float64, error)
+}
+
+// Adapter to use a callback as FakeADCSource
+type FakeADCSourceFunc func(t time.Duration) (float64, error)
+
+func (f FakeADCSourceFunc) Volts(t time.Duration) (float64, error) {
+	return f(t)
+}
+
+// offset + amplitude * sin(2π t / period)
+type SineSource struct {
+	Offset, Amplitude float64
+	Period            time.Duration
+}
+
+func NewSineSource(offset, amplitude float64, period time.Duration) (*SineSource, error) {
+	if period <= 0 {
+		return nil, fmt.Errorf("period must be positive")
+	}
+	return &SineSource{Offset: offset, Amplitude: amplitude, Period: period}, nil
+}
+
+func (sine *SineSource) Volts(t time.Duration) (float64, error) {
+	return sine.Offset + sine.Amplitude*math.Sin(2*math.Pi*float64(t)/float64(sine.Period)), nil
+}
+
+// Rises or falls linearly from From to To within Duration. Then starts over if Repeat is set, a sawtooth, or stays at To.
+type RampSource struct {
+	From, To float64
+	Duration time.Duration
+	Repeat   bool
+}
+
+func NewRampSource(from, to float64, duration time.Duration, repeat bool) (*RampSource, error) {
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	return &RampSource{From: from, To: to, Duration: duration, Repeat: repeat}, nil
+}
+
+func (ramp *RampSource) Volts(t time.Duration) (float64, error) {
+	if ramp.Repeat {
+		t %= ramp.Duration
+	} else if t >= ramp.Duration {
+		return ramp.To, nil
+	}
+	return ramp.From + (ramp.To-ramp.From)*float64(t)/float64(ramp.Duration), nil
+}
+
+// Adds gaussian noise to another source. The same seed gives the same noise for the same sequence of reads.
+type NoiseSource struct {
+	base   FakeADCSource
+	stddev float64
+	rnd    *rand.Rand
+}
+
+// noise with standard deviation stddev in V around base, or around 0 if base is nil
+func NewNoiseSource(base FakeADCSource, stddev float64, seed int64) (*NoiseSource, error) {
+	if stddev < 0 {
+		return nil, fmt.Errorf("standard deviation must not be negative")
+	}
+	return &NoiseSource{base: base, stddev: stddev, rnd: rand.New(rand.NewSource(seed))}, nil
+}
+
+// not safe for concurrent use, which FakeADC ensures by calling it locked
+func (noise *NoiseSource) Volts(t time.Duration) (volts float64, err error) {
+	if noise.base != nil {
+		if volts, err = noise.base.Volts(t); err != nil {
+			return
+		}
+	}
+	return volts + noise.rnd.NormFloat64()*noise.stddev, nil
+}
+
+// Plays back recorded samples, holding each until the next one. Before the first sample the first value is returned.
+type CSVSource struct {
+	times []time.Duration
+	volts []float64
+	// start over after the last sample instead of holding it.
+	// The last sample is held as long as the interval before it, then the first one follows.
+	Loop bool
+}
+
+// Reads lines of "seconds,volts", e.g. "0.010,1.234". Lines that don't start with a number, like a header, are skipped.
+// The times have to be ascending, they are relative to the first sample.
+func NewCSVSource(r io.Reader) (source *CSVSource, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	source = new(CSVSource)
+	for line := 1; ; line++ {
+		record, rerr := reader.Read()
+		if rerr == io.EOF {
+			break
+		} else if rerr != nil {
+			return nil, rerr
+		}
+		seconds, perr := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		if perr != nil {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("line %d: expected seconds,volts", line)
+		}
+		volts, perr := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if perr != nil {
+			return nil, fmt.Errorf("line %d: %v", line, perr)
+		}
+		t := time.Duration(seconds * float64(time.Second))
+		if n := len(source.times); n > 0 && t < source.times[n-1] {
+			return nil, fmt.Errorf("line %d: time %gs is before the previous sample", line, seconds)
+		}
+		source.times = append(source.times, t)
+		source.volts = append(source.volts, volts)
+	}
+	if len(source.times) == 0 {
+		return nil, fmt.Errorf("no samples")
+	}
+	// relative to the first sample
+	for i := len(source.times) - 1; i >= 0; i-- {
+		source.times[i] -= source.times[0]
+	}
+	return source, nil
+}
+
+func NewCSVSourceFromFile(path string) (source *CSVSource, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	return NewCSVSource(file)
+}
+
+// time of the last sample
+func (source *CSVSource) Duration() time.Duration {
+	return source.times[len(source.times)-1]
+}
+
+// Duration plus the interval before the last sample, so every sample is played back once per loop
+func (source *CSVSource) loopDuration() time.Duration {
+	n := len(source.times)
+	if n < 2 {
+		return 0
+	}
+	return source.times[n-1] + source.times[n-1] - source.times[n-2]
+}
+
+func (source *CSVSource) Volts(t time.Duration) (float64, error) {
+	if loop := source.loopDuration(); source.Loop && loop > 0 {
+		t %= loop
+	}
+	// first sample after t, the one before holds
+	i := sort.Search(len(source.times), func(i int) bool { return source.times[i] > t })
+	if i == 0 {
+		return source.volts[0], nil
+	}
+	return source.volts[i-1], nil
+}
@@ -0,0 +1,126 @@
+package bbhw
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_FakeADCSources(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	adc := NewFakeADCOrPanic(0)
+	read := func(after time.Duration) float64 {
+		clock.Advance(after)
+		volts, err := adc.ReadVolts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return volts
+	}
+
+	sine, _ := NewSineSource(0.9, 0.5, 100*time.Millisecond)
+	adc.SimulateSource(sine, clock)
+	for i, want := range []float64{1.4, 0.9, 0.4, 0.9} {
+		if v := read(25 * time.Millisecond); !closeTo(v, want) {
+			t.Errorf("sine at %dms is %g instead of %g", 25*(i+1), v, want)
+		}
+	}
+
+	ramp, _ := NewRampSource(0, 1.8, time.Second, false)
+	adc.SimulateSource(ramp, clock)
+	if v := read(250 * time.Millisecond); !closeTo(v, 0.45) || adc.ReadValue() != 450 {
+		t.Errorf("ramp at 250ms is %g", v)
+	}
+	if v := read(time.Second); v != 1.8 {
+		t.Errorf("ramp should stay at its end, got %g", v)
+	}
+	ramp.Repeat = true
+	if v := read(0); !closeTo(v, 0.45) {
+		t.Errorf("repeated ramp at 1250ms is %g", v)
+	}
+
+	// same seed, same noise
+	var sequences [3][]uint16
+	for i, seed := range []int64{1, 1, 2} {
+		noise, _ := NewNoiseSource(sine, 0.01, seed)
+		adc.SimulateSource(noise, clock)
+		for j := 0; j < 10; j++ {
+			sequences[i] = append(sequences[i], adc.ReadValue())
+		}
+	}
+	if !equalUint16s(sequences[0], sequences[1]) || equalUint16s(sequences[0], sequences[2]) {
+		t.Errorf("noise sequences %v", sequences)
+	}
+
+	failure := errors.New("open circuit")
+	adc.SimulateSource(FakeADCSourceFunc(func(t time.Duration) (float64, error) {
+		if t > time.Second {
+			return 0, failure
+		}
+		return t.Seconds(), nil
+	}), clock)
+	if v := read(500 * time.Millisecond); v != 0.5 {
+		t.Errorf("callback gives %g", v)
+	}
+	clock.Advance(time.Second)
+	if _, err := adc.ReadValueCheckError(); err != failure || adc.CheckErrorOccurred() != failure {
+		t.Errorf("error of the callback should be returned, got %v", err)
+	}
+	adc.SimulateValue(1234, nil)
+	if v := read(time.Second); v != 1.234 {
+		t.Errorf("SimulateValue should replace the source, got %g", v)
+	}
+}
+
+func equalUint16s(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_FakeADCCSVSource(t *testing.T) {
+	recording := "# battery discharge\nseconds,volts\n10.0,1.5\n10.5,1.4\n11.0, 1.2\n"
+	source, err := NewCSVSource(strings.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source.Duration() != time.Second {
+		t.Errorf("recording lasts %v", source.Duration())
+	}
+	clock := NewFakeClock(time.Now())
+	adc := NewFakeADCOrPanic(0)
+	adc.SimulateSource(source, clock)
+	for _, c := range []struct {
+		after time.Duration
+		want  uint16
+	}{{0, 1500}, {499 * time.Millisecond, 1500}, {time.Millisecond, 1400}, {time.Second, 1200}} {
+		clock.Advance(c.after)
+		if v := adc.ReadValue(); v != c.want {
+			t.Errorf("%dmV instead of %dmV", v, c.want)
+		}
+	}
+	source.Loop = true
+	// the last sample lasts 0.5s like the one before, so the loop is 1.5s long
+	for _, c := range []struct {
+		after time.Duration
+		want  uint16
+	}{{0, 1500}, {time.Second, 1200}, {499 * time.Millisecond, 1200}, {time.Millisecond, 1500}} {
+		clock.Advance(c.after)
+		if v := adc.ReadValue(); v != c.want {
+			t.Errorf("looped playback gives %dmV instead of %dmV", v, c.want)
+		}
+	}
+
+	for _, bad := range []string{"", "seconds,volts\n", "0,1\n1\n", "0,1\n1,x\n", "1,1\n0,1\n"} {
+		if _, err := NewCSVSource(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q should be refused", bad)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package bbhw
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Samples any ADC periodically, filters the readings and reports threshold crossings.
+//
+// Each sample is the mean of the oversampling count of consecutive reads. The filter then
+// smooths the last window samples by their mean or median. Values are in mV like ADC.ReadValue.
+type ADCMonitor struct {
+	adc         ADC
+	clock       Clock
+	interval    time.Duration
+	oversample  int
+	filter      int
+	window      int
+	history     []float64 // last samples, oldest first
+	value       float64   // filtered
+	valid       bool
+	thresholds  []*adcThreshold
+	subscribers []chan ADCEvent
+	err         error
+	lock        sync.Mutex
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+const (
+	ADC_FILTER_NONE = iota
+	ADC_FILTER_MOVING_AVERAGE
+	ADC_FILTER_MEDIAN
+)
+
+// events are dropped if a subscriber does not read its channel
+const adc_monitor_event_buffer_size_ = 16
+
+// A crossed threshold, or an error of the ADC if Err is not nil
+type ADCEvent struct {
+	Threshold float64 // level of the crossed threshold
+	Edge      int     // EDGE_RISING or EDGE_FALLING, EDGE_NONE with Err
+	Value     float64 // filtered value that crossed it
+	Timestamp time.Time
+	Err       error
+}
+
+type adcThreshold struct {
+	level      float64
+	hysteresis float64
+	above      bool
+	known      bool // above is valid
+}
+
+// Monitor sampling adc every interval once started. Pass nil as clock to use SystemClock.
+func NewADCMonitor(adc ADC, interval time.Duration, clock Clock) (monitor *ADCMonitor, err error) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("sampling interval must be positive")
+	}
+	return &ADCMonitor{adc: adc, clock: clockOrSystemClock(clock), interval: interval, oversample: 1, window: 1}, nil
+}
+
+// Wrapper around NewADCMonitor. Does not return an error but panics instead.
+func NewADCMonitorOrPanic(adc ADC, interval time.Duration, clock Clock) *ADCMonitor {
+	monitor, err := NewADCMonitor(adc, interval, clock)
+	if err != nil {
+		panic(err)
+	}
+	return monitor
+}
+
+// Reads the ADC n times in a row for each sample and takes the mean. Gives fractions of mV for noisy inputs.
+func (monitor *ADCMonitor) SetOversampling(n int) error {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	if n < 1 {
+		return fmt.Errorf("oversampling must be at least 1")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	monitor.oversample = n
+	return nil
+}
+
+// ADC_FILTER_NONE, or ADC_FILTER_MOVING_AVERAGE or ADC_FILTER_MEDIAN over the last window samples. Forgets the previous samples.
+func (monitor *ADCMonitor) SetFilter(filter, window int) error {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	if filter < ADC_FILTER_NONE || filter > ADC_FILTER_MEDIAN {
+		return fmt.Errorf("Invalid filter value")
+	}
+	if filter == ADC_FILTER_NONE {
+		window = 1
+	} else if window < 1 {
+		return fmt.Errorf("filter window must be at least 1")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	monitor.filter, monitor.window = filter, window
+	monitor.history = nil
+	monitor.valid = false
+	return nil
+}
+
+// Reports crossings of level in mV. The value has to rise above level+hysteresis/2 for an EDGE_RISING event
+// and drop below level-hysteresis/2 for an EDGE_FALLING one. The first sample only determines the side, without event.
+// Example: AddThreshold(11000, 500) for a battery cutoff at 11V through a voltage divider of 1:10
+func (monitor *ADCMonitor) AddThreshold(level, hysteresis float64) error {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	if hysteresis < 0 {
+		return fmt.Errorf("hysteresis must not be negative")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	for _, th := range monitor.thresholds {
+		if th.level == level {
+			return fmt.Errorf("threshold %g already exists", level)
+		}
+	}
+	th := &adcThreshold{level: level, hysteresis: hysteresis}
+	if monitor.valid {
+		th.above, th.known = monitor.value > level, true
+	}
+	monitor.thresholds = append(monitor.thresholds, th)
+	return nil
+}
+
+func (monitor *ADCMonitor) RemoveThreshold(level float64) {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	for i, th := range monitor.thresholds {
+		if th.level == level {
+			monitor.thresholds = append(monitor.thresholds[:i], monitor.thresholds[i+1:]...)
+			return
+		}
+	}
+}
+
+// Returns a new channel which receives threshold crossings and errors of the ADC. Closed by Close.
+func (monitor *ADCMonitor) Subscribe() <-chan ADCEvent {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	events := make(chan ADCEvent, adc_monitor_event_buffer_size_)
+	monitor.subscribers = append(monitor.subscribers, events)
+	return events
+}
+
+// never blocks, drops the event for subscribers which do not keep up
+// must be called with monitor.lock held
+func (monitor *ADCMonitor) publish(ev ADCEvent) {
+	for _, events := range monitor.subscribers {
+		select {
+		case events <- ev:
+		default:
+		}
+	}
+}
+
+// Takes one sample now, updates the filter and checks the thresholds. Start calls it every interval.
+func (monitor *ADCMonitor) Sample() (value float64, err error) {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	n := monitor.oversample
+	monitor.lock.Unlock()
+	var sum float64
+	for i := 0; i < n; i++ {
+		var raw uint16
+		if raw, err = monitor.adc.ReadValueCheckError(); err != nil {
+			break
+		}
+		sum += float64(raw)
+	}
+	now := monitor.clock.Now()
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if err != nil {
+		monitor.err = err
+		monitor.publish(ADCEvent{Edge: EDGE_NONE, Timestamp: now, Err: err})
+		return
+	}
+	monitor.history = append(monitor.history, sum/float64(n))
+	if len(monitor.history) > monitor.window {
+		monitor.history = monitor.history[len(monitor.history)-monitor.window:]
+	}
+	monitor.value, monitor.valid = monitor.filtered(), true
+	for _, th := range monitor.thresholds {
+		monitor.checkThreshold(th, now)
+	}
+	return monitor.value, nil
+}
+
+// must be called with monitor.lock held
+func (monitor *ADCMonitor) filtered() float64 {
+	switch monitor.filter {
+	case ADC_FILTER_MEDIAN:
+		sorted := append([]float64(nil), monitor.history...)
+		sort.Float64s(sorted)
+		mid := len(sorted) / 2
+		if len(sorted)%2 == 0 {
+			return (sorted[mid-1] + sorted[mid]) / 2
+		}
+		return sorted[mid]
+	case ADC_FILTER_MOVING_AVERAGE:
+		var sum float64
+		for _, v := range monitor.history {
+			sum += v
+		}
+		return sum / float64(len(monitor.history))
+	}
+	return monitor.history[len(monitor.history)-1]
+}
+
+// must be called with monitor.lock held
+func (monitor *ADCMonitor) checkThreshold(th *adcThreshold, now time.Time) {
+	value := monitor.value
+	if !th.known {
+		th.above, th.known = value > th.level, true
+		return
+	}
+	if !th.above && value > th.level+th.hysteresis/2 {
+		th.above = true
+		monitor.publish(ADCEvent{Threshold: th.level, Edge: EDGE_RISING, Value: value, Timestamp: now})
+	} else if th.above && value < th.level-th.hysteresis/2 {
+		th.above = false
+		monitor.publish(ADCEvent{Threshold: th.level, Edge: EDGE_FALLING, Value: value, Timestamp: now})
+	}
+}
+
+// The filtered value of the last sample. Error if there was no sample yet.
+func (monitor *ADCMonitor) Value() (float64, error) {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if !monitor.valid {
+		return 0, fmt.Errorf("no sample yet")
+	}
+	return monitor.value, nil
+}
+
+// The last error of the ADC, also sent to the subscribers
+func (monitor *ADCMonitor) CheckErrorOccurred() error {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	return monitor.err
+}
+
+// Samples now and then every interval in the background, until Stop or Close
+func (monitor *ADCMonitor) Start() error {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	if monitor.stop != nil {
+		return fmt.Errorf("ADCMonitor is already running")
+	}
+	monitor.stop = make(chan struct{})
+	monitor.done = make(chan struct{})
+	go monitor.run(monitor.stop, monitor.done)
+	return nil
+}
+
+func (monitor *ADCMonitor) run(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		monitor.Sample()
+		timer := monitor.clock.NewTimer(monitor.interval)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+	}
+}
+
+// Stops sampling, the subscriptions stay
+func (monitor *ADCMonitor) Stop() {
+	if monitor == nil {
+		panic("monitor == nil")
+	}
+	monitor.lock.Lock()
+	stop, done := monitor.stop, monitor.done
+	monitor.stop, monitor.done = nil, nil
+	monitor.lock.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Stops sampling and closes the channels of all subscribers. Does not close the ADC.
+func (monitor *ADCMonitor) Close() {
+	monitor.Stop()
+	monitor.lock.Lock()
+	defer monitor.lock.Unlock()
+	for _, events := range monitor.subscribers {
+		close(events)
+	}
+	monitor.subscribers = nil
+}
@@ -0,0 +1,112 @@
+package bbhw
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// returns the values in turn, one per read
+type sequenceADC struct {
+	values []uint16
+	reads  int
+}
+
+func (adc *sequenceADC) ReadValue() uint16 {
+	v := adc.values[adc.reads%len(adc.values)]
+	adc.reads++
+	return v
+}
+
+func (adc *sequenceADC) CheckErrorOccurred() error { return nil }
+
+func (adc *sequenceADC) ReadValueCheckError() (uint16, error) { return adc.ReadValue(), nil }
+
+func Test_ADCMonitorFilters(t *testing.T) {
+	adc := NewFakeADCOrPanic(0)
+	monitor := NewADCMonitorOrPanic(adc, time.Second, nil)
+	if _, err := monitor.Value(); err == nil {
+		t.Error("no value before the first sample")
+	}
+	for _, c := range []struct {
+		filter, window int
+		values         []uint16
+		want           []float64
+	}{
+		{ADC_FILTER_NONE, 0, []uint16{10, 20}, []float64{10, 20}},
+		{ADC_FILTER_MOVING_AVERAGE, 3, []uint16{10, 20, 60, 0}, []float64{10, 15, 30, 80.0 / 3}},
+		{ADC_FILTER_MEDIAN, 3, []uint16{10, 100, 20, 30}, []float64{10, 55, 20, 30}},
+	} {
+		if err := monitor.SetFilter(c.filter, c.window); err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range c.values {
+			adc.SimulateValue(v, nil)
+			if got, err := monitor.Sample(); err != nil || !closeTo(got, c.want[i]) {
+				t.Errorf("filter %d, sample %d: %g instead of %g, %v", c.filter, i, got, c.want[i], err)
+			}
+		}
+	}
+	if err := monitor.SetFilter(ADC_FILTER_MEDIAN, 0); err == nil {
+		t.Error("empty window should be refused")
+	}
+
+	seq := &sequenceADC{values: []uint16{1000, 1001, 1003, 1004}}
+	oversampled := NewADCMonitorOrPanic(seq, time.Second, nil)
+	oversampled.SetOversampling(4)
+	if v, _ := oversampled.Sample(); v != 1002 || seq.reads != 4 {
+		t.Errorf("oversampling gives %g after %d reads", v, seq.reads)
+	}
+}
+
+func Test_ADCMonitorThresholds(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	adc := NewFakeADCOrPanic(0)
+	monitor := NewADCMonitorOrPanic(adc, 100*time.Millisecond, clock)
+	defer monitor.Close()
+	monitor.AddThreshold(1000, 100)
+	events := monitor.Subscribe()
+	adc.SimulateValue(1200, nil)
+	monitor.Start()
+	waitFor(t, "first sample", func() bool { return clock.Waiters() == 1 })
+	step := func(mv uint16, err error) {
+		adc.SimulateValue(mv, err)
+		clock.Advance(100 * time.Millisecond)
+		waitFor(t, "next sample", func() bool { return clock.Waiters() == 1 })
+	}
+	expect := func(edge int, value float64) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Edge != edge || ev.Value != value || ev.Threshold != 1000 || ev.Err != nil || !ev.Timestamp.Equal(clock.Now()) {
+				t.Errorf("unexpected event %+v", ev)
+			}
+		default:
+			if edge != EDGE_NONE {
+				t.Errorf("no event at %g", value)
+			}
+		}
+	}
+	expect(EDGE_NONE, 1200)
+	step(960, nil)
+	expect(EDGE_NONE, 960)
+	step(940, nil)
+	expect(EDGE_FALLING, 940)
+	step(1040, nil)
+	expect(EDGE_NONE, 1040)
+	step(1060, nil)
+	expect(EDGE_RISING, 1060)
+
+	failure := errors.New("ADC gone")
+	step(0, failure)
+	if ev := <-events; ev.Err != failure || monitor.CheckErrorOccurred() != failure {
+		t.Errorf("error should reach the subscribers, got %+v", ev)
+	}
+	if v, _ := monitor.Value(); v != 1060 {
+		t.Errorf("failed sample changed the value to %g", v)
+	}
+	monitor.Close()
+	if _, ok := <-events; ok || clock.Waiters() != 0 {
+		t.Error("Close should stop sampling and close the subscriptions")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"time"
 )
 
@@ -15,8 +14,13 @@ type SysfsADC struct {
 	Number uint
 	fd     *os.File
 	err    error
+	scale  float64 // mV per count
+	offset float64 // counts added before scaling
 }
 
+// without in_voltage_scale we assume the AM335x: 12 bit for 1.8V
+const sysfs_adc_default_scale_ = 1800.0 / 4096.0
+
 func LoadOverlayForSysfsADC() error {
 	err := AddDeviceTreeOverlayIfNotAlreadyLoaded("BB-ADC")
 	if err == ERROR_DTO_ALREADY_LOADED {
@@ -59,6 +63,8 @@ func NewSysfsADC(number uint) (adc *SysfsADC, err error) {
 	if err != nil {
 		return nil, err
 	}
+	adc.scale = readIIOChannelAttrFloat(adc_dir, fmt.Sprintf("in_voltage%d", number), "scale", sysfs_adc_default_scale_)
+	adc.offset = readIIOChannelAttrFloat(adc_dir, fmt.Sprintf("in_voltage%d", number), "offset", 0)
 	return adc, nil
 }
 
@@ -72,30 +78,48 @@ func NewSysfsADCOrPanic(number uint) (adc *SysfsADC) {
 	return adc
 }
 
-//returns raw SysFs Value.
-// In case of new kernel 4.4 that means raw value which we convert to actual mV
+// returns the input voltage in mV, converted from the raw value with the scale and offset of the IIO device
 func (adc *SysfsADC) ReadValue() (value uint16) {
+	var raw int64
+	raw, adc.err = adc.ReadRaw()
+	if adc.err != nil {
+		return
+	}
+	if mv := adc.rawToMillivolts(raw); mv > 0 {
+		value = uint16(mv)
+	}
+	return
+}
+
+// count as read from in_voltageN_raw
+func (adc *SysfsADC) ReadRaw() (raw int64, err error) {
 	if adc == nil {
 		panic("adc == nil")
 	}
 	if adc.fd == nil {
 		panic("adc.fd == nil")
 	}
-	_, adc.err = adc.fd.Seek(0, 0)
-	if adc.err != nil {
+	return readSysfsInt(adc.fd)
+}
+
+func (adc *SysfsADC) ReadVolts() (volts float64, err error) {
+	var raw int64
+	if raw, err = adc.ReadRaw(); err != nil {
 		return
 	}
+	return adc.rawToMillivolts(raw) / 1000, nil
+}
 
-	var numread int
-	buf := make([]byte, 16, 16)
-	numread, adc.err = adc.fd.Read(buf)
-	if adc.err != nil {
-		return
+// mV per count and the offset in counts, from in_voltage[N]_scale and _offset
+func (adc *SysfsADC) Scale() (scale, offset float64) {
+	if adc == nil {
+		panic("adc == nil")
 	}
-	var value64 uint64
-	value64, adc.err = strconv.ParseUint(string(buf[0:numread-1]), 10, 16)
+	return adc.scale, adc.offset
+}
 
-	return uint16(value64 * 1800 / 4096) //4096 means 1.8V means 1800mV
+func (adc *SysfsADC) rawToMillivolts(raw int64) float64 {
+	return (float64(raw) + adc.offset) * adc.scale
 }
 
 func (adc *SysfsADC) CheckErrorOccurred() error {
@@ -111,12 +135,22 @@ func (adc *SysfsADC) ReadValueCheckError() (value uint16, err error) {
 	return
 }
 
+// kernel 4.x puts the ADC below the ocp directory, 5.x below a chain of interconnects, so look it up by name in /sys/bus/iio there
 func findTSCADCDir() (adcdir string, err error) {
 	var ocp_dir string
-	if ocp_dir, err = findOCPDir(); err != nil {
-		return
+	if ocp_dir, err = findOCPDir(); err == nil {
+		adcdir = filepath.Join(ocp_dir, "44e0d000.tscadc/TI-am335x-adc/iio:device0/")
+		if doesPathExist(adcdir) {
+			return
+		}
+	}
+	if iiodev, finderr := FindIIODevice("TI-am335x-adc"); finderr == nil {
+		return iiodev.Path, nil
+	}
+	if err == nil {
+		// not loaded yet, WaitUntilSysFSADCRunning waits for it to appear
+		return adcdir, nil
 	}
-	adcdir = filepath.Join(ocp_dir, "44e0d000.tscadc/TI-am335x-adc/iio:device0/")
 	return
 }
 
@@ -0,0 +1,134 @@
+package bbhw
+
+import (
+	"sync"
+	"time"
+)
+
+// Source of time for everything that waits or measures time.
+// Use SystemClock, or a FakeClock to make tests deterministic.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) ClockTimer
+}
+
+// Like time.Timer. Stop it if you stop waiting before it fired.
+type ClockTimer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) NewTimer(d time.Duration) ClockTimer    { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// The real time
+var SystemClock Clock = systemClock{}
+
+// returns SystemClock if clock is nil
+func clockOrSystemClock(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock
+	}
+	return clock
+}
+
+/// ---------- FakeClock ---------------
+
+// Virtual time for testing. Time only passes when Advance or Sleep is called.
+type FakeClock struct {
+	now     time.Time
+	waiters []fakeClockWaiter
+	lock    sync.Mutex
+}
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (clock *FakeClock) Now() time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return clock.now
+}
+
+// Sleep does not block but advances the virtual time
+func (clock *FakeClock) Sleep(d time.Duration) {
+	clock.Advance(d)
+}
+
+// The returned channel receives the virtual time once Advance or Sleep moved it past d
+func (clock *FakeClock) After(d time.Duration) <-chan time.Time {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- clock.now
+		return c
+	}
+	clock.waiters = append(clock.waiters, fakeClockWaiter{deadline: clock.now.Add(d), c: c})
+	return c
+}
+
+// The timer fires once Advance or Sleep moved the virtual time past d
+func (clock *FakeClock) NewTimer(d time.Duration) ClockTimer {
+	return &fakeTimer{clock: clock, c: clock.After(d)}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	c     <-chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+// removes the waiter, returns false if it already fired
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	for i, w := range t.clock.waiters {
+		if w.c == t.c {
+			t.clock.waiters = append(t.clock.waiters[:i], t.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Moves the virtual time forward and fires all After channels which are due
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	clock.now = clock.now.Add(d)
+	pending := clock.waiters[:0]
+	for _, w := range clock.waiters {
+		if w.deadline.After(clock.now) {
+			pending = append(pending, w)
+		} else {
+			w.c <- clock.now
+		}
+	}
+	clock.waiters = pending
+}
+
+// Number of After channels and timers which have neither fired nor been stopped.
+// Lets tests wait until a goroutine is blocked on the clock.
+func (clock *FakeClock) Waiters() int {
+	clock.lock.Lock()
+	defer clock.lock.Unlock()
+	return len(clock.waiters)
+}
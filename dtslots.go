@@ -15,7 +15,7 @@ var ERROR_DTO_ALREADY_LOADED error
 var dtsslot_slots_file_ string
 var dtsslot_ocp_dir_ string = ""
 
-var dtsslot_path_ocp_regex_ *regexp.Regexp = regexp.MustCompile("^/sys/devices(?:/platform)?/ocp" + `(?:\.\d+)?`)
+var dtsslot_path_ocp_regex_ string = `(?:/platform)?/ocp(?:\.\d+)?$`
 var dtsslot_path_base_ string = "/sys/devices"
 
 func init() {
@@ -30,7 +30,7 @@ func doesPathExist(name string) bool {
 
 func findFile(basedir, searchdirregex, searchfilename string, maxdepth int) (sfile string, err error) {
 	var dir_regex *regexp.Regexp
-	if dir_regex, err = regexp.Compile(filepath.Join(basedir, searchdirregex)); err != nil {
+	if dir_regex, err = regexp.Compile("^" + regexp.QuoteMeta(filepath.Clean(basedir)) + "/" + searchdirregex); err != nil {
 		return "", err
 	}
 	err = filepath.Walk(basedir, makeFindDirHelperFunc(&sfile, dir_regex, maxdepth))
@@ -66,7 +66,7 @@ func findFileInSubDirectory(basedir, dirglobexpr string, searchfilename string)
 
 func makeFindDirHelperFunc(returnvalue *string, target_re *regexp.Regexp, maxdepth int) func(string, os.FileInfo, error) error {
 	return func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() {
+		if err != nil || !info.IsDir() {
 			return nil
 		}
 		if len(filepath.SplitList(path)) > maxdepth {
@@ -86,7 +86,7 @@ func findSlotsFile() (sfile string, err error) {
 		return dtsslot_slots_file_, nil
 	}
 
-	sfile, err = findFile(dtsslot_path_base_, "(?:platform)?/bone_capemgr"+`(?:\.\d+)?`+"$", "slots", 5)
+	sfile, err = findFile(rootedPath(dtsslot_path_base_), "(?:platform/)?bone_capemgr"+`(?:\.\d+)?`+"$", "slots", 5)
 
 	if err != nil {
 		err = fmt.Errorf("OverlaySlotsFile Not Found")
@@ -102,7 +102,9 @@ func findOCPDir() (path string, err error) {
 		return dtsslot_ocp_dir_, nil
 	} else {
 
-		err = filepath.Walk(dtsslot_path_base_, makeFindDirHelperFunc(&path, dtsslot_path_ocp_regex_, 6))
+		base := rootedPath(dtsslot_path_base_)
+		ocp_regex := regexp.MustCompile("^" + regexp.QuoteMeta(base) + dtsslot_path_ocp_regex_)
+		err = filepath.Walk(base, makeFindDirHelperFunc(&path, ocp_regex, 6))
 		if err == foundit_error_ && len(path) > 0 {
 			dtsslot_ocp_dir_ = path
 			err = nil
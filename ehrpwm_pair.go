@@ -0,0 +1,492 @@
+package bbhw
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The two outputs A and B of an AM335x EHRPWM module.
+//
+// Both outputs share one time base, so they always have the same period. EHRPWMPair keeps track of it,
+// sets both duties together and refuses settings one output can't have without disturbing the other.
+// With access to the registers through /dev/mem it also offers complementary outputs with dead-time
+// and a phase relative to ehrpwm0, otherwise it works through sysfs.
+type EHRPWMPair struct {
+	Module  int
+	lock    sync.Mutex
+	backend ehrpwmBackend
+	cfg     ehrpwmConfig
+}
+
+type ehrpwmConfig struct {
+	period        time.Duration
+	duty          [2]time.Duration
+	polarity      [2]bool
+	complementary bool
+	deadtime      time.Duration
+	phase         time.Duration
+}
+
+type ehrpwmBackend interface {
+	apply(old, cfg ehrpwmConfig) error
+	usesRegisters() bool
+	close() error
+}
+
+const (
+	EHRPWM_A = 0
+	EHRPWM_B = 1
+)
+
+// ePWM functional clock of the AM335x
+const ehrpwm_sysclk_period_ = 10 * time.Nanosecond
+
+// device names of ehrpwm0 to ehrpwm2, used if the device-tree has no labels
+var ehrpwm_devices_ = []string{"48300200.pwm", "48302200.pwm", "48304200.pwm"}
+
+// pwmchip numbers of ehrpwm0 to ehrpwm2 in pin_to_pwmchip_map_, used if nothing else is found
+var ehrpwm_default_chips_ = []int{0, 2, 4}
+
+// modules which have an EHRPWMPair, only one per module
+var ehrpwm_pairs_ = make(map[int]*EHRPWMPair)
+var ehrpwm_pairs_lock_ sync.Mutex
+
+// Opens both outputs of ehrpwm0, ehrpwm1 or ehrpwm2 through sysfs
+// and uses the registers in addition if /dev/mem can be mapped.
+// Load the pinmux for the outputs first, e.g. with LoadOverlayForSysfsPWM or config-pin.
+func NewEHRPWMPair(module int) (pair *EHRPWMPair, err error) {
+	if module < 0 || module >= len(ehrpwm_devices_) {
+		return nil, fmt.Errorf("ehrpwm%d does not exist", module)
+	}
+	chipid := ehrpwm_default_chips_[module]
+	if chip, finderr := FindPWMChip(fmt.Sprintf("ehrpwm%d", module)); finderr == nil {
+		chipid = chip.Chip
+	} else if chip, finderr := FindPWMChip(ehrpwm_devices_[module]); finderr == nil {
+		chipid = chip.Chip
+	}
+	a, err := NewPWMChipPWM(chipid, EHRPWM_A)
+	if err != nil {
+		return nil, err
+	}
+	b, err := NewPWMChipPWM(chipid, EHRPWM_B)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	sysfs := newEHRPWMSysfsBackend(a, b, func() (PWMControllablePin, error) {
+		chipdir, err := findPWMChipDir(chipid)
+		if err != nil {
+			return nil, err
+		}
+		if err = unexportPWMonPWMChip(chipdir, EHRPWM_B); err != nil {
+			return nil, err
+		}
+		return NewPWMChipPWM(chipid, EHRPWM_B)
+	})
+	var backend ehrpwmBackend = sysfs
+	if regs, maperr := newEHRPWMRegistersMMap(module); maperr == nil {
+		// the kernel keeps the clock of the module running while the outputs are enabled through sysfs
+		backend = newEHRPWMRegisterBackend(module, regs, sysfs)
+	}
+	if pair, err = newEHRPWMPair(module, backend); err != nil {
+		backend.close()
+	}
+	return
+}
+
+// Wrapper around NewEHRPWMPair. Does not return an error but panics instead.
+func NewEHRPWMPairOrPanic(module int) *EHRPWMPair {
+	pair, err := NewEHRPWMPair(module)
+	if err != nil {
+		panic(err)
+	}
+	return pair
+}
+
+func newEHRPWMPair(module int, backend ehrpwmBackend) (pair *EHRPWMPair, err error) {
+	ehrpwm_pairs_lock_.Lock()
+	defer ehrpwm_pairs_lock_.Unlock()
+	if _, inuse := ehrpwm_pairs_[module]; inuse {
+		return nil, fmt.Errorf("ehrpwm%d is already in use by another EHRPWMPair", module)
+	}
+	pair = &EHRPWMPair{Module: module, backend: backend}
+	ehrpwm_pairs_[module] = pair
+	return pair, nil
+}
+
+// true if the registers are used, which is needed for complementary outputs and phase
+func (pair *EHRPWMPair) UsesRegisters() bool {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	return pair.backend.usesRegisters()
+}
+
+// checks cfg and applies it, must be called with pair.lock held
+func (pair *EHRPWMPair) apply(cfg ehrpwmConfig) error {
+	if err := pair.check(cfg); err != nil {
+		return err
+	}
+	if err := pair.backend.apply(pair.cfg, cfg); err != nil {
+		return err
+	}
+	pair.cfg = cfg
+	return nil
+}
+
+func (pair *EHRPWMPair) check(cfg ehrpwmConfig) error {
+	for _, duty := range cfg.duty {
+		if err := checkPWMSetting(cfg.period, duty); err != nil {
+			return err
+		}
+	}
+	if cfg.complementary {
+		if !pair.backend.usesRegisters() {
+			return fmt.Errorf("ehrpwm%d: complementary outputs need access to the registers", pair.Module)
+		}
+		if cfg.duty[EHRPWM_B] != 0 {
+			return fmt.Errorf("ehrpwm%d: B follows A in complementary mode and can't have a duty of its own", pair.Module)
+		}
+		if cfg.period > 0 && 2*cfg.deadtime >= cfg.period {
+			return fmt.Errorf("ehrpwm%d: dead-time %v leaves nothing of period %v", pair.Module, cfg.deadtime, cfg.period)
+		}
+	}
+	if cfg.phase != 0 {
+		if !pair.backend.usesRegisters() {
+			return fmt.Errorf("ehrpwm%d: phase needs access to the registers", pair.Module)
+		}
+		if pair.Module == 0 {
+			return fmt.Errorf("ehrpwm0 is the source of the sync and can't have a phase")
+		}
+		if cfg.phase < 0 || cfg.phase >= cfg.period {
+			return fmt.Errorf("ehrpwm%d: phase %v is out of range [0,%v)", pair.Module, cfg.phase, cfg.period)
+		}
+		// the sync resets our counter every period of ehrpwm0
+		ehrpwm_pairs_lock_.Lock()
+		master := ehrpwm_pairs_[0]
+		ehrpwm_pairs_lock_.Unlock()
+		if master != nil {
+			if period, _, _ := master.GetPWM(); period != cfg.period {
+				return fmt.Errorf("ehrpwm%d: phase needs the period %v of ehrpwm0, not %v", pair.Module, period, cfg.period)
+			}
+		}
+	}
+	return nil
+}
+
+// Sets the shared period and both duties at once
+func (pair *EHRPWMPair) SetPWM(period, dutya, dutyb time.Duration) error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	cfg := pair.cfg
+	cfg.period, cfg.duty = period, [2]time.Duration{dutya, dutyb}
+	return pair.apply(cfg)
+}
+
+func (pair *EHRPWMPair) GetPWM() (period, dutya, dutyb time.Duration) {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	return pair.cfg.period, pair.cfg.duty[EHRPWM_A], pair.cfg.duty[EHRPWM_B]
+}
+
+// Changes the period and keeps the duties, fails if a duty is larger than the new period
+func (pair *EHRPWMPair) SetPeriod(period time.Duration) error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	cfg := pair.cfg
+	cfg.period = period
+	return pair.apply(cfg)
+}
+
+// B becomes the inverse of A. Both rising edges are delayed by deadtime, so A and B are never high at the same time.
+// Needs the registers. The duty of B has to be 0 while complementary.
+func (pair *EHRPWMPair) SetComplementary(deadtime time.Duration) error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	if deadtime < 0 {
+		return fmt.Errorf("dead-time must not be negative")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	cfg := pair.cfg
+	cfg.complementary, cfg.deadtime, cfg.duty[EHRPWM_B] = true, deadtime, 0
+	return pair.apply(cfg)
+}
+
+// A and B are independent again, B starts with duty 0
+func (pair *EHRPWMPair) ClearComplementary() error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	cfg := pair.cfg
+	cfg.complementary, cfg.deadtime, cfg.duty[EHRPWM_B] = false, 0, 0
+	return pair.apply(cfg)
+}
+
+// Delays both outputs by phase relative to ehrpwm0. Needs the registers and the same period as ehrpwm0,
+// so set up ehrpwm0 first.
+func (pair *EHRPWMPair) SetPhase(phase time.Duration) error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	cfg := pair.cfg
+	cfg.phase = phase
+	return pair.apply(cfg)
+}
+
+// Sets both duties to 0, polarities to normal and leaves complementary mode
+func (pair *EHRPWMPair) DisablePWM() error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	return pair.apply(ehrpwmConfig{period: pair.cfg.period, phase: pair.cfg.phase})
+}
+
+// One output of the pair as PWMControllablePin.
+// SetPWM fails if it would change the period while the other output is running.
+func (pair *EHRPWMPair) Channel(channel int) PWMControllablePin {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	if channel != EHRPWM_A && channel != EHRPWM_B {
+		panic("channel must be EHRPWM_A or EHRPWM_B")
+	}
+	return ehrpwmChannel{pair, channel}
+}
+
+// Releases the module, the outputs keep their last setting
+func (pair *EHRPWMPair) Close() error {
+	if pair == nil {
+		panic("pair == nil")
+	}
+	ehrpwm_pairs_lock_.Lock()
+	if ehrpwm_pairs_[pair.Module] == pair {
+		delete(ehrpwm_pairs_, pair.Module)
+	}
+	ehrpwm_pairs_lock_.Unlock()
+	pair.lock.Lock()
+	defer pair.lock.Unlock()
+	return pair.backend.close()
+}
+
+/// ---------- Channels ---------------
+
+type ehrpwmChannel struct {
+	pair    *EHRPWMPair
+	channel int
+}
+
+func (c ehrpwmChannel) SetPolarity(p bool) error {
+	c.pair.lock.Lock()
+	defer c.pair.lock.Unlock()
+	cfg := c.pair.cfg
+	cfg.polarity[c.channel] = p
+	return c.pair.apply(cfg)
+}
+
+func (c ehrpwmChannel) SetPWM(period, duty time.Duration) error {
+	c.pair.lock.Lock()
+	defer c.pair.lock.Unlock()
+	cfg := c.pair.cfg
+	other := 1 - c.channel
+	if period != cfg.period && cfg.duty[other] > 0 {
+		return fmt.Errorf("ehrpwm%d: can't change the period to %v, output %c uses %v", c.pair.Module, period, 'A'+other, cfg.period)
+	}
+	cfg.period, cfg.duty[c.channel] = period, duty
+	return c.pair.apply(cfg)
+}
+
+func (c ehrpwmChannel) GetPWM() (period, duty time.Duration, err error) {
+	c.pair.lock.Lock()
+	defer c.pair.lock.Unlock()
+	return c.pair.cfg.period, c.pair.cfg.duty[c.channel], nil
+}
+
+func (c ehrpwmChannel) DisablePWM() error {
+	c.pair.lock.Lock()
+	defer c.pair.lock.Unlock()
+	cfg := c.pair.cfg
+	cfg.duty[c.channel], cfg.polarity[c.channel] = 0, false
+	return c.pair.apply(cfg)
+}
+
+// Does nothing, close the EHRPWMPair instead
+func (c ehrpwmChannel) Close() error {
+	return nil
+}
+
+/// ---------- sysfs ---------------
+
+type ehrpwmSysfsBackend struct {
+	pins        [2]PWMControllablePin
+	reopenb     func() (PWMControllablePin, error)
+	bconfigured bool
+}
+
+// reopenb unexports B and exports it again, which is the only way to make the kernel forget the period of B
+func newEHRPWMSysfsBackend(a, b PWMControllablePin, reopenb func() (PWMControllablePin, error)) *ehrpwmSysfsBackend {
+	return &ehrpwmSysfsBackend{pins: [2]PWMControllablePin{a, b}, reopenb: reopenb}
+}
+
+func (sysfs *ehrpwmSysfsBackend) usesRegisters() bool {
+	return false
+}
+
+// The kernel refuses a period for one channel which differs from the one last set on the other
+func (sysfs *ehrpwmSysfsBackend) apply(old, cfg ehrpwmConfig) (err error) {
+	if cfg.period != old.period && sysfs.bconfigured {
+		sysfs.pins[EHRPWM_B].Close()
+		sysfs.bconfigured = false
+		if sysfs.pins[EHRPWM_B], err = sysfs.reopenb(); err != nil {
+			return
+		}
+		old.polarity[EHRPWM_B] = false
+	}
+	for c, pin := range sysfs.pins {
+		if cfg.polarity[c] != old.polarity[c] {
+			if err = pin.SetPolarity(cfg.polarity[c]); err != nil {
+				return
+			}
+		}
+	}
+	if err = sysfs.pins[EHRPWM_A].SetPWM(cfg.period, cfg.duty[EHRPWM_A]); err != nil {
+		return
+	}
+	if err = sysfs.pins[EHRPWM_B].SetPWM(cfg.period, cfg.duty[EHRPWM_B]); err != nil {
+		return
+	}
+	sysfs.bconfigured = true
+	return nil
+}
+
+func (sysfs *ehrpwmSysfsBackend) close() (err error) {
+	for _, pin := range sysfs.pins {
+		if closeerr := pin.Close(); err == nil {
+			err = closeerr
+		}
+	}
+	return
+}
+
+/// ---------- registers ---------------
+
+type ehrpwmRegisterBackend struct {
+	module int
+	regs   ehrpwmRegisters
+	sysfs  *ehrpwmSysfsBackend // may be nil, keeps the module enabled
+}
+
+func newEHRPWMRegisterBackend(module int, regs ehrpwmRegisters, sysfs *ehrpwmSysfsBackend) *ehrpwmRegisterBackend {
+	return &ehrpwmRegisterBackend{module: module, regs: regs, sysfs: sysfs}
+}
+
+func (backend *ehrpwmRegisterBackend) usesRegisters() bool {
+	return true
+}
+
+// smallest prescaler which fits period into the 16 bit counter, HSPCLKDIV is 1 or an even number up to 14, CLKDIV a power of 2 up to 128.
+// CLKDIV is preferred if both give the same division.
+func ehrpwmPrescaler(period time.Duration) (hspclkdiv, clkdiv uint16, tick time.Duration, err error) {
+	best := 0
+	for h := uint16(0); h < 8; h++ {
+		for c := uint16(0); c < 8; c++ {
+			div := 1 << c
+			if h > 0 {
+				div *= 2 * int(h)
+			}
+			if period/(ehrpwm_sysclk_period_*time.Duration(div)) <= 0xFFFF && (best == 0 || div < best) {
+				best, hspclkdiv, clkdiv = div, h, c
+			}
+		}
+	}
+	if best == 0 {
+		return 0, 0, 0, fmt.Errorf("period %v is too long for an EHRPWM", period)
+	}
+	return hspclkdiv, clkdiv, ehrpwm_sysclk_period_ * time.Duration(best), nil
+}
+
+// writes the whole configuration, shadowed registers take effect at the next start of a period
+func (backend *ehrpwmRegisterBackend) apply(old, cfg ehrpwmConfig) error {
+	hspclkdiv, clkdiv, tick, err := ehrpwmPrescaler(cfg.period)
+	if err != nil {
+		return err
+	}
+	if cfg.deadtime/tick > ehrpwm_db_max_ticks_ {
+		return fmt.Errorf("dead-time %v is too long for period %v, at most %v", cfg.deadtime, cfg.period, tick*ehrpwm_db_max_ticks_)
+	}
+	if backend.sysfs != nil && old.period == 0 && cfg.period != 0 {
+		// have the kernel enable the module with a harmless setting
+		if err = backend.sysfs.apply(ehrpwmConfig{}, ehrpwmConfig{period: cfg.period}); err != nil {
+			return err
+		}
+	}
+	ticks := uint16(cfg.period / tick)
+	tbctl := uint16(ehrpwm_tbctl_free_) | hspclkdiv<<ehrpwm_tbctl_hspdiv_ | clkdiv<<ehrpwm_tbctl_clkdiv_
+	if backend.module == 0 {
+		tbctl |= ehrpwm_tbctl_sync_zro_
+	} else if cfg.phase > 0 {
+		tbctl |= ehrpwm_tbctl_phsen_
+	}
+	// up-count mode, the output is set at zero and cleared at the compare value, or the other way round if inverted.
+	// compare before zero wins, so a compare value of 0 gives a duty of 0, one beyond the period a duty of 100%.
+	aqctla := uint16(ehrpwm_aq_zro_set_ | ehrpwm_aq_cau_clear_)
+	if cfg.polarity[EHRPWM_A] {
+		aqctla = ehrpwm_aq_zro_clear_ | ehrpwm_aq_cau_set_
+	}
+	aqctlb := uint16(ehrpwm_aq_zro_set_ | ehrpwm_aq_cbu_clear_)
+	if cfg.polarity[EHRPWM_B] {
+		aqctlb = ehrpwm_aq_zro_clear_ | ehrpwm_aq_cbu_set_
+	}
+	var dbctl uint16
+	if cfg.complementary {
+		dbctl = ehrpwm_db_complement_
+	}
+	deadticks := uint16(cfg.deadtime / tick)
+
+	backend.regs.write16(ehrpwm_tbctl_, tbctl)
+	// the sync from ehrpwm0 loads the counter with tbphs, so a delay of phase needs a counter that is behind
+	var tbphs uint16
+	if cfg.phase > 0 {
+		tbphs = ticks - uint16(cfg.phase/tick)
+	}
+	backend.regs.write16(ehrpwm_tbphs_, tbphs)
+	if ticks > 0 {
+		backend.regs.write16(ehrpwm_tbprd_, ticks-1)
+	} else {
+		backend.regs.write16(ehrpwm_tbprd_, 0)
+	}
+	backend.regs.write16(ehrpwm_cmpctl_, 0) // shadowed, loaded at zero
+	backend.regs.write16(ehrpwm_cmpa_, uint16(cfg.duty[EHRPWM_A]/tick))
+	backend.regs.write16(ehrpwm_cmpb_, uint16(cfg.duty[EHRPWM_B]/tick))
+	backend.regs.write16(ehrpwm_aqctla_, aqctla)
+	backend.regs.write16(ehrpwm_aqctlb_, aqctlb)
+	backend.regs.write16(ehrpwm_dbred_, deadticks)
+	backend.regs.write16(ehrpwm_dbfed_, deadticks)
+	backend.regs.write16(ehrpwm_dbctl_, dbctl)
+	return nil
+}
+
+func (backend *ehrpwmRegisterBackend) close() (err error) {
+	backend.regs.close()
+	if backend.sysfs != nil {
+		err = backend.sysfs.close()
+	}
+	return
+}
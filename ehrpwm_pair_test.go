@@ -0,0 +1,135 @@
+package bbhw
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestEHRPWMRegisterPair(t *testing.T, module int) (*EHRPWMPair, *fakeEHRPWMRegisters) {
+	regs := new(fakeEHRPWMRegisters)
+	pair, err := newEHRPWMPair(module, newEHRPWMRegisterBackend(module, regs, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pair.Close() })
+	return pair, regs
+}
+
+func Test_EHRPWMPairRegisters(t *testing.T) {
+	pair, regs := newTestEHRPWMRegisterPair(t, 1)
+	if _, err := newEHRPWMPair(1, newEHRPWMRegisterBackend(1, new(fakeEHRPWMRegisters), nil)); err == nil {
+		t.Error("a module can only have one EHRPWMPair")
+	}
+	if err := pair.SetPWM(time.Millisecond, 250*time.Microsecond, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// 1ms is 100000 ticks of 10ns, which needs CLKDIV /2
+	for reg, want := range map[uint]uint16{
+		ehrpwm_tbctl_:  ehrpwm_tbctl_free_ | 1<<ehrpwm_tbctl_clkdiv_,
+		ehrpwm_tbprd_:  49999,
+		ehrpwm_cmpa_:   12500,
+		ehrpwm_cmpb_:   50000,
+		ehrpwm_aqctla_: ehrpwm_aq_zro_set_ | ehrpwm_aq_cau_clear_,
+		ehrpwm_dbctl_:  0,
+	} {
+		if got := regs.read16(reg); got != want {
+			t.Errorf("register 0x%02x is 0x%04x instead of 0x%04x", reg, got, want)
+		}
+	}
+
+	var invalid *PWMInvalidSettingError
+	if err := pair.SetPeriod(500 * time.Microsecond); !errors.As(err, &invalid) {
+		t.Errorf("period shorter than duty of B should be refused, got %v", err)
+	}
+	if err := pair.Channel(EHRPWM_A).SetPWM(2*time.Millisecond, time.Millisecond); err == nil {
+		t.Error("channel A must not change the period while B is running")
+	}
+	if err := pair.Channel(EHRPWM_B).DisablePWM(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair.Channel(EHRPWM_A).SetPWM(2*time.Millisecond, time.Millisecond); err != nil {
+		t.Error(err)
+	}
+
+	if err := pair.SetComplementary(time.Millisecond); err == nil {
+		t.Error("dead-time of half the period should be refused")
+	}
+	if err := pair.SetComplementary(time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if regs.read16(ehrpwm_dbctl_) != ehrpwm_db_complement_ || regs.read16(ehrpwm_dbred_) != 25 || regs.read16(ehrpwm_dbfed_) != 25 {
+		t.Errorf("dead-band registers 0x%04x %d %d", regs.read16(ehrpwm_dbctl_), regs.read16(ehrpwm_dbred_), regs.read16(ehrpwm_dbfed_))
+	}
+	if err := pair.Channel(EHRPWM_B).SetPWM(2*time.Millisecond, time.Microsecond); err == nil {
+		t.Error("B can't have a duty in complementary mode")
+	}
+	pair.ClearComplementary()
+	if regs.read16(ehrpwm_dbctl_) != 0 {
+		t.Error("dead-band still enabled")
+	}
+	pair.Channel(EHRPWM_B).SetPolarity(true)
+	if regs.read16(ehrpwm_aqctlb_) != ehrpwm_aq_zro_clear_|ehrpwm_aq_cbu_set_ {
+		t.Errorf("AQCTLB 0x%04x for inverted polarity", regs.read16(ehrpwm_aqctlb_))
+	}
+}
+
+func Test_EHRPWMPairPhase(t *testing.T) {
+	master, masterregs := newTestEHRPWMRegisterPair(t, 0)
+	pair, regs := newTestEHRPWMRegisterPair(t, 2)
+	master.SetPWM(100*time.Microsecond, 50*time.Microsecond, 0)
+	if err := master.SetPhase(10 * time.Microsecond); err == nil {
+		t.Error("ehrpwm0 can't have a phase")
+	}
+	if masterregs.read16(ehrpwm_tbctl_)&ehrpwm_tbctl_sync_zro_ == 0 {
+		t.Error("ehrpwm0 should emit the sync")
+	}
+	pair.SetPWM(200*time.Microsecond, 0, 0)
+	if err := pair.SetPhase(25 * time.Microsecond); err == nil {
+		t.Error("phase with a period different from ehrpwm0 should be refused")
+	}
+	pair.SetPWM(100*time.Microsecond, 0, 0)
+	if err := pair.SetPhase(25 * time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if regs.read16(ehrpwm_tbctl_)&ehrpwm_tbctl_phsen_ == 0 || regs.read16(ehrpwm_tbphs_) != 7500 {
+		t.Errorf("TBCTL 0x%04x TBPHS %d", regs.read16(ehrpwm_tbctl_), regs.read16(ehrpwm_tbphs_))
+	}
+}
+
+func Test_EHRPWMPairSysfs(t *testing.T) {
+	a, b := NewFakePWMOrPanic("A"), NewFakePWMOrPanic("B")
+	reopened := 0
+	sysfs := newEHRPWMSysfsBackend(a, b, func() (PWMControllablePin, error) {
+		reopened++
+		b = NewFakePWMOrPanic("B")
+		return b, nil
+	})
+	pair, err := newEHRPWMPair(0, sysfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pair.Close()
+	if pair.UsesRegisters() {
+		t.Error("sysfs backend does not use registers")
+	}
+	if err := pair.SetComplementary(time.Microsecond); err == nil {
+		t.Error("complementary mode needs the registers")
+	}
+	pair.SetPWM(time.Millisecond, 100*time.Microsecond, 200*time.Microsecond)
+	pair.SetPWM(time.Millisecond, 300*time.Microsecond, 400*time.Microsecond)
+	if reopened != 0 {
+		t.Error("B reopened without a change of period")
+	}
+	if err := pair.SetPWM(2*time.Millisecond, time.Millisecond, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if reopened != 1 {
+		t.Error("B has to be reopened to change the period")
+	}
+	for _, pin := range []*FakePWMPin{a, b} {
+		if period, duty, _ := pin.GetPWM(); period != 2*time.Millisecond || duty != time.Millisecond {
+			t.Errorf("%s is at %v, %v", pin.name, period, duty)
+		}
+	}
+}
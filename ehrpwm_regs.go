@@ -0,0 +1,110 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+/// This ONLY works on the BeagleBone or similar AM335xx devices !!!
+/// see the AM335x Technical Reference Manual, chapter 15.2 Enhanced PWM (ePWM) Module
+
+const ( // AM335x ePWM registers, 16 bit, relative to the ePWM module inside its PWMSS
+	ehrpwm_offset_         = 0x200
+	ehrpwm_pagesize_       = 0x1000 //4KiB, one PWMSS
+	ehrpwm_regs_size_      = 0x60
+	ehrpwm_tbctl_          = 0x00
+	ehrpwm_tbphs_          = 0x06
+	ehrpwm_tbprd_          = 0x0A
+	ehrpwm_cmpctl_         = 0x0E
+	ehrpwm_cmpa_           = 0x12
+	ehrpwm_cmpb_           = 0x14
+	ehrpwm_aqctla_         = 0x16
+	ehrpwm_aqctlb_         = 0x18
+	ehrpwm_dbctl_          = 0x1E
+	ehrpwm_dbred_          = 0x20
+	ehrpwm_dbfed_          = 0x22
+	ehrpwm_tbctl_phsen_    = 1 << 2
+	ehrpwm_tbctl_sync_zro_ = 1 << 4 // SYNCOSEL: emit sync when the counter is zero, 0 passes the sync input through
+	ehrpwm_tbctl_hspdiv_   = 7      // bit position of HSPCLKDIV
+	ehrpwm_tbctl_clkdiv_   = 10     // bit position of CLKDIV
+	ehrpwm_tbctl_free_     = 3 << 14
+	ehrpwm_aq_zro_clear_   = 1
+	ehrpwm_aq_zro_set_     = 2
+	ehrpwm_aq_cau_clear_   = 1 << 4
+	ehrpwm_aq_cau_set_     = 2 << 4
+	ehrpwm_aq_cbu_clear_   = 1 << 8
+	ehrpwm_aq_cbu_set_     = 2 << 8
+	ehrpwm_db_complement_  = 3 | 2<<2 // OUT_MODE both delayed, POLSEL active high complementary, A is the source of both edges
+	ehrpwm_db_max_ticks_   = 0x3FF
+)
+
+// PWMSS base addresses of ehrpwm0 to ehrpwm2
+var ehrpwm_pwmss_addrs_ = []int64{0x48300000, 0x48302000, 0x48304000}
+
+type ehrpwmRegisters interface {
+	read16(offset uint) uint16
+	write16(offset uint, value uint16)
+	close()
+}
+
+type mappedEHRPWMRegisters struct {
+	memfd *os.File
+	page  []byte
+}
+
+func newEHRPWMRegistersMMap(module int) (regs *mappedEHRPWMRegisters, err error) {
+	if module < 0 || module >= len(ehrpwm_pwmss_addrs_) {
+		return nil, fmt.Errorf("ehrpwm%d does not exist", module)
+	}
+	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
+		return nil, fmt.Errorf("Looks like we aren't on a AM33xx CPU! Can't access the ePWM registers")
+	}
+	regs = new(mappedEHRPWMRegisters)
+	regs.memfd, err = os.OpenFile("/dev/mem", os.O_RDWR|os.O_SYNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	regs.page, err = syscall.Mmap(int(regs.memfd.Fd()), ehrpwm_pwmss_addrs_[module], ehrpwm_pagesize_, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		regs.memfd.Close()
+		return nil, err
+	}
+	return regs, nil
+}
+
+// the registers are 16 bit wide and have to be accessed as such
+func (regs *mappedEHRPWMRegisters) read16(offset uint) uint16 {
+	return *(*uint16)(unsafe.Pointer(&regs.page[ehrpwm_offset_+offset]))
+}
+
+func (regs *mappedEHRPWMRegisters) write16(offset uint, value uint16) {
+	*(*uint16)(unsafe.Pointer(&regs.page[ehrpwm_offset_+offset])) = value
+}
+
+func (regs *mappedEHRPWMRegisters) close() {
+	syscall.Munmap(regs.page)
+	regs.memfd.Close()
+}
+
+// In-memory stand-in for the registers of one ePWM module
+type fakeEHRPWMRegisters struct {
+	lock sync.Mutex
+	regs [ehrpwm_regs_size_ / 2]uint16
+}
+
+func (regs *fakeEHRPWMRegisters) read16(offset uint) uint16 {
+	regs.lock.Lock()
+	defer regs.lock.Unlock()
+	return regs.regs[offset/2]
+}
+
+func (regs *fakeEHRPWMRegisters) write16(offset uint, value uint16) {
+	regs.lock.Lock()
+	defer regs.lock.Unlock()
+	regs.regs[offset/2] = value
+}
+
+func (regs *fakeEHRPWMRegisters) close() {}
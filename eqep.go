@@ -0,0 +1,32 @@
+package bbhw
+
+import "time"
+
+// eQEP Interface, hardware quadrature decoders of the AM335x
+
+type EQEP interface {
+	ReadPosition() (int64, error)
+	SetPosition(position int64) error
+	SetMode(mode int) error
+	SetPeriod(period time.Duration) error
+	ReadVelocity() (float64, error)
+	Close()
+}
+
+// In EQEP_MODE_ABSOLUTE the position counts up and down until it is set.
+// In EQEP_MODE_RELATIVE the position is latched and reset at every unit timer period,
+// i.e. it reads the counts of the last period.
+const (
+	EQEP_MODE_ABSOLUTE = iota
+	EQEP_MODE_RELATIVE
+)
+
+/// --- Interface Functions
+
+// counts per second from the counts of one unit timer period
+func eqepVelocity(counts int64, period time.Duration) float64 {
+	if period <= 0 {
+		return 0
+	}
+	return float64(counts) * float64(time.Second) / float64(period)
+}
@@ -0,0 +1,110 @@
+package bbhw
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Fake eQEP for testing motion-control code
+
+type FakeEQEP struct {
+	Unit     uint
+	position int64 // absolute counter
+	latched  int64 // counts of the last unit timer period
+	mode     int
+	period   time.Duration
+	err      error
+	lock     sync.Mutex
+}
+
+func NewFakeEQEP(unit uint) (eqep *FakeEQEP, err error) {
+	eqep = new(FakeEQEP)
+	eqep.Unit = unit
+	return eqep, nil
+}
+
+func NewFakeEQEPOrPanic(unit uint) (eqep *FakeEQEP) {
+	eqep, _ = NewFakeEQEP(unit)
+	return
+}
+
+func (eqep *FakeEQEP) ReadPosition() (int64, error) {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	if eqep.mode == EQEP_MODE_RELATIVE {
+		return eqep.latched, eqep.err
+	}
+	return eqep.position, eqep.err
+}
+
+func (eqep *FakeEQEP) SetPosition(position int64) error {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	eqep.position = position
+	return eqep.err
+}
+
+func (eqep *FakeEQEP) SetMode(mode int) error {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	if mode != EQEP_MODE_ABSOLUTE && mode != EQEP_MODE_RELATIVE {
+		return fmt.Errorf("Invalid eQEP mode %d", mode)
+	}
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	eqep.mode = mode
+	return eqep.err
+}
+
+func (eqep *FakeEQEP) SetPeriod(period time.Duration) error {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	if period < 0 {
+		return fmt.Errorf("Invalid eQEP period %v", period)
+	}
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	eqep.period = period
+	return eqep.err
+}
+
+func (eqep *FakeEQEP) ReadVelocity() (float64, error) {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	if eqep.mode != EQEP_MODE_RELATIVE {
+		return 0, fmt.Errorf("eQEP%d velocity needs EQEP_MODE_RELATIVE", eqep.Unit)
+	}
+	if eqep.period <= 0 {
+		return 0, fmt.Errorf("eQEP%d unit timer is disabled", eqep.Unit)
+	}
+	return eqepVelocity(eqep.latched, eqep.period), eqep.err
+}
+
+func (eqep *FakeEQEP) Close() {}
+
+// Simulate one unit timer period in which the encoder moved by counts
+func (eqep *FakeEQEP) SimulateCounts(counts int64) {
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	eqep.position += counts
+	eqep.latched = counts
+}
+
+// All following calls return err
+func (eqep *FakeEQEP) SimulateError(err error) {
+	eqep.lock.Lock()
+	defer eqep.lock.Unlock()
+	eqep.err = err
+}
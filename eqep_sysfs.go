@@ -0,0 +1,270 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SysFS managed eQEPs ------------------------------------
+
+// Uses the files of the tieqep driver in the OCP directory:
+// position, mode, period (of the unit timer, in ns) and enabled
+type SysfsEQEP struct {
+	Unit        uint
+	fd_position *os.File
+	fd_mode     *os.File
+	fd_period   *os.File
+}
+
+type eqepUnit struct {
+	epwmss, eqep string // addresses in the device names, e.g. 48300000.epwmss/48300180.eqep
+	overlay      string
+}
+
+var eqep_units_ = []eqepUnit{
+	{"48300000", "48300180", "bone_eqep0"}, // P9_42B, P9_27
+	{"48302000", "48302180", "bone_eqep1"}, // P8_35, P8_33
+	{"48304000", "48304180", "bone_eqep2"}, // P8_12, P8_11
+}
+
+// eQEP2 on P8_41, P8_42 instead of P8_11, P8_12
+const eqep2_alternate_overlay_ = "bone_eqep2b"
+
+var eqep_pin_function_regex_ = regexp.MustCompile(`^eqep(\d)(?:[ab]_in|_index)$`)
+
+// number of times and interval to look for the eqep device after loading the overlay
+const (
+	eqep_overlay_wait_tries_ = 200
+	eqep_overlay_wait_       = 50 * time.Millisecond
+)
+
+func LoadOverlayForSysfsEQEP(unit uint) error {
+	if unit >= uint(len(eqep_units_)) {
+		return fmt.Errorf("No eQEP%d", unit)
+	}
+	return loadEQEPOverlay(eqep_units_[unit].overlay)
+}
+
+func loadEQEPOverlay(overlay string) error {
+	err := AddDeviceTreeOverlayIfNotAlreadyLoaded(overlay)
+	if err == ERROR_DTO_ALREADY_LOADED {
+		return nil
+	} else {
+		return err
+	}
+}
+
+func findEQEPDir(unit uint) (eqepdir string, err error) {
+	var ocp_dir string
+	if ocp_dir, err = findOCPDir(); err != nil {
+		return
+	}
+	u := eqep_units_[unit]
+	eqepdir, err = findFileInSubDirectory(ocp_dir, "*"+u.epwmss+".epwmss", u.eqep+".eqep")
+	if err != nil {
+		err = fmt.Errorf("eQEP%d Directory Not Found", unit)
+	}
+	return
+}
+
+// Instantinate eQEP 0, 1 or 2. Loads the overlay if the eqep device does not exist yet.
+// clock measures the wait for the device to appear, pass nil to use SystemClock.
+func NewSysfsEQEP(unit uint, clock Clock) (eqep *SysfsEQEP, err error) {
+	if unit >= uint(len(eqep_units_)) {
+		return nil, fmt.Errorf("No eQEP%d", unit)
+	}
+	return newSysfsEQEP(unit, eqep_units_[unit].overlay, clockOrSystemClock(clock))
+}
+
+// Instantinate the eQEP whose A, B or index input is on the header pin, e.g. "P8_12"
+func NewSysfsEQEPByPin(name string, clock Clock) (eqep *SysfsEQEP, err error) {
+	var pin *HeaderPin
+	if pin, err = LookupHeaderPin(name); err != nil {
+		return
+	}
+	// P9_41 and P9_42 are wired to a second pad each, eQEP0 is on that one
+	pads := []*HeaderPin{pin}
+	if twin, twinerr := LookupHeaderPin(pin.Name + "B"); twinerr == nil {
+		pads = append(pads, twin)
+	}
+	for _, pad := range pads {
+		for _, function := range pad.Modes {
+			if match := eqep_pin_function_regex_.FindStringSubmatch(function); match != nil {
+				unit, _ := strconv.ParseUint(match[1], 10, 8)
+				overlay := eqep_units_[unit].overlay
+				if pin.Name == "P8_41" || pin.Name == "P8_42" {
+					overlay = eqep2_alternate_overlay_
+				}
+				return newSysfsEQEP(uint(unit), overlay, clockOrSystemClock(clock))
+			}
+		}
+	}
+	return nil, fmt.Errorf("Pin %s is no eQEP input", pin.Name)
+}
+
+// Wrapper around NewSysfsEQEP. Does not return an error but panics instead.
+func NewSysfsEQEPOrPanic(unit uint, clock Clock) (eqep *SysfsEQEP) {
+	eqep, err := NewSysfsEQEP(unit, clock)
+	if err != nil {
+		panic(err)
+	}
+	return eqep
+}
+
+func newSysfsEQEP(unit uint, overlay string, clock Clock) (eqep *SysfsEQEP, err error) {
+	var eqep_dir string
+	if eqep_dir, err = findEQEPDir(unit); err != nil {
+		if err = loadEQEPOverlay(overlay); err != nil {
+			return nil, err
+		}
+		for wait := eqep_overlay_wait_tries_; wait > 0; wait-- {
+			if eqep_dir, err = findEQEPDir(unit); err == nil {
+				break
+			}
+			<-clock.After(eqep_overlay_wait_)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	eqep = &SysfsEQEP{Unit: unit}
+	if doesPathExist(filepath.Join(eqep_dir, "enabled")) {
+		var fd_enabled *os.File
+		fd_enabled, err = os.OpenFile(filepath.Join(eqep_dir, "enabled"), os.O_WRONLY|os.O_SYNC, 0666)
+		if err != nil {
+			return nil, err
+		}
+		err = writeSysfsInt(fd_enabled, 1)
+		fd_enabled.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	eqep.fd_position, err = os.OpenFile(filepath.Join(eqep_dir, "position"), os.O_RDWR|os.O_SYNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	eqep.fd_mode, err = os.OpenFile(filepath.Join(eqep_dir, "mode"), os.O_RDWR|os.O_SYNC, 0666)
+	if err != nil {
+		eqep.fd_position.Close()
+		return nil, err
+	}
+	eqep.fd_period, err = os.OpenFile(filepath.Join(eqep_dir, "period"), os.O_RDWR|os.O_SYNC, 0666)
+	if err != nil {
+		eqep.fd_position.Close()
+		eqep.fd_mode.Close()
+		return nil, err
+	}
+	return eqep, nil
+}
+
+func readSysfsInt(fd *os.File) (value int64, err error) {
+	buf := make([]byte, 32)
+	var numread int
+	if numread, err = fd.ReadAt(buf, 0); err != nil && numread == 0 {
+		return
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(buf[:numread])), 10, 64)
+}
+
+func writeSysfsInt(fd *os.File, value int64) (err error) {
+	return writeSysfsString(fd, fmt.Sprintf("%d", value))
+}
+
+func writeSysfsString(fd *os.File, value string) (err error) {
+	if err = fd.Truncate(0); err != nil {
+		return
+	}
+	_, err = fd.WriteAt([]byte(value+"\n"), 0)
+	return
+}
+
+func (eqep *SysfsEQEP) ReadPosition() (int64, error) {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	return readSysfsInt(eqep.fd_position)
+}
+
+func (eqep *SysfsEQEP) SetPosition(position int64) error {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	return writeSysfsInt(eqep.fd_position, position)
+}
+
+// EQEP_MODE_ABSOLUTE or EQEP_MODE_RELATIVE
+func (eqep *SysfsEQEP) SetMode(mode int) error {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	if mode != EQEP_MODE_ABSOLUTE && mode != EQEP_MODE_RELATIVE {
+		return fmt.Errorf("Invalid eQEP mode %d", mode)
+	}
+	return writeSysfsInt(eqep.fd_mode, int64(mode))
+}
+
+func (eqep *SysfsEQEP) GetMode() (int, error) {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	mode, err := readSysfsInt(eqep.fd_mode)
+	return int(mode), err
+}
+
+// Period of the unit timer, which latches the position in EQEP_MODE_RELATIVE. 0 disables the unit timer.
+func (eqep *SysfsEQEP) SetPeriod(period time.Duration) error {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	if period < 0 {
+		return fmt.Errorf("Invalid eQEP period %v", period)
+	}
+	return writeSysfsInt(eqep.fd_period, period.Nanoseconds())
+}
+
+func (eqep *SysfsEQEP) GetPeriod() (time.Duration, error) {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	period, err := readSysfsInt(eqep.fd_period)
+	return time.Duration(period) * time.Nanosecond, err
+}
+
+// Counts per second during the last unit timer period. Needs EQEP_MODE_RELATIVE.
+func (eqep *SysfsEQEP) ReadVelocity() (float64, error) {
+	if eqep == nil {
+		panic("eqep == nil")
+	}
+	mode, err := eqep.GetMode()
+	if err != nil {
+		return 0, err
+	}
+	if mode != EQEP_MODE_RELATIVE {
+		return 0, fmt.Errorf("eQEP%d velocity needs EQEP_MODE_RELATIVE", eqep.Unit)
+	}
+	period, err := eqep.GetPeriod()
+	if err != nil {
+		return 0, err
+	}
+	if period <= 0 {
+		return 0, fmt.Errorf("eQEP%d unit timer is disabled", eqep.Unit)
+	}
+	counts, err := eqep.ReadPosition()
+	if err != nil {
+		return 0, err
+	}
+	return eqepVelocity(counts, period), nil
+}
+
+func (eqep *SysfsEQEP) Close() {
+	eqep.fd_position.Close()
+	eqep.fd_mode.Close()
+	eqep.fd_period.Close()
+	eqep = nil
+}
@@ -0,0 +1,163 @@
+package bbhw
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// creates the files of the tieqep driver in a temporary OCP directory
+func makeFakeEQEPDir(t *testing.T, ocp_dir string, unit uint) string {
+	u := eqep_units_[unit]
+	eqep_dir := filepath.Join(ocp_dir, u.epwmss+".epwmss", u.eqep+".eqep")
+	if err := os.MkdirAll(eqep_dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"enabled", "mode", "period", "position"} {
+		if err := ioutil.WriteFile(filepath.Join(eqep_dir, name), []byte("0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return eqep_dir
+}
+
+func useTempOCPDir(t *testing.T) string {
+	orig_ocp, orig_slots := dtsslot_ocp_dir_, dtsslot_slots_file_
+	t.Cleanup(func() { dtsslot_ocp_dir_, dtsslot_slots_file_ = orig_ocp, orig_slots })
+	dtsslot_ocp_dir_ = t.TempDir()
+	dtsslot_slots_file_ = filepath.Join(t.TempDir(), "slots")
+	if err := ioutil.WriteFile(dtsslot_slots_file_, []byte(" 0: 54:PF---  ,BB-BONELT-HDMI\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dtsslot_ocp_dir_
+}
+
+func Test_SysfsEQEP(t *testing.T) {
+	eqep_dir := makeFakeEQEPDir(t, useTempOCPDir(t), 2)
+	if _, err := NewSysfsEQEPByPin("P9_12", nil); err == nil {
+		t.Error("P9_12 is no eQEP input")
+	}
+	eqep, err := NewSysfsEQEPByPin("P8_12", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer eqep.Close()
+	if eqep.Unit != 2 {
+		t.Errorf("P8_12 is eQEP2, not eQEP%d", eqep.Unit)
+	}
+	if enabled, _ := ioutil.ReadFile(filepath.Join(eqep_dir, "enabled")); string(enabled) != "1\n" {
+		t.Error("eQEP was not enabled")
+	}
+	if err := eqep.SetPosition(-1234); err != nil {
+		t.Fatal(err)
+	}
+	if pos, err := eqep.ReadPosition(); pos != -1234 || err != nil {
+		t.Errorf("ReadPosition returned %d, %v", pos, err)
+	}
+	if _, err := eqep.ReadVelocity(); err == nil {
+		t.Error("velocity needs relative mode")
+	}
+	eqep.SetMode(EQEP_MODE_RELATIVE)
+	eqep.SetPeriod(10 * time.Millisecond)
+	if period, _ := ioutil.ReadFile(filepath.Join(eqep_dir, "period")); string(period) != "10000000\n" {
+		t.Errorf("period file contains %q", period)
+	}
+	ioutil.WriteFile(filepath.Join(eqep_dir, "position"), []byte("-50\n"), 0644)
+	if v, err := eqep.ReadVelocity(); v != -5000 || err != nil {
+		t.Errorf("ReadVelocity returned %f, %v", v, err)
+	}
+	if err := eqep.SetMode(7); err == nil {
+		t.Error("mode 7 should be refused")
+	}
+}
+
+func waitForOverlay(t *testing.T, overlay string) {
+	t.Helper()
+	waitFor(t, overlay, func() bool {
+		slots, _ := ioutil.ReadFile(dtsslot_slots_file_)
+		for _, line := range strings.Split(string(slots), "\n") {
+			if strings.HasSuffix(strings.TrimSpace(line), overlay) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func Test_SysfsEQEPByPinTwin(t *testing.T) {
+	makeFakeEQEPDir(t, useTempOCPDir(t), 0)
+	// eQEP0 A and index are on the second pads of P9_42 and P9_41
+	for _, name := range []string{"P9_42", "P9_27", "P9_41"} {
+		eqep, err := NewSysfsEQEPByPin(name, nil)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if eqep.Unit != 0 {
+			t.Errorf("%s is eQEP0, not eQEP%d", name, eqep.Unit)
+		}
+		eqep.Close()
+	}
+}
+
+func Test_SysfsEQEPLoadsOverlay(t *testing.T) {
+	ocp_dir := useTempOCPDir(t)
+	clock := NewFakeClock(time.Now())
+	done := make(chan error)
+	go func() {
+		eqep, err := NewSysfsEQEP(1, clock)
+		if err == nil {
+			eqep.Close()
+		}
+		done <- err
+	}()
+	// pretend to be the kernel, which takes a while to create the device
+	waitForOverlay(t, "bone_eqep1")
+	waitFor(t, "wait for device", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(eqep_overlay_wait_)
+	waitFor(t, "wait for device", func() bool { return clock.Waiters() == 1 })
+	makeFakeEQEPDir(t, ocp_dir, 1)
+	clock.Advance(eqep_overlay_wait_)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func Test_SysfsEQEPOverlayTimeout(t *testing.T) {
+	useTempOCPDir(t)
+	clock := NewFakeClock(time.Now())
+	done := make(chan error)
+	go func() {
+		_, err := NewSysfsEQEPByPin("P8_42", clock)
+		done <- err
+	}()
+	waitForOverlay(t, "bone_eqep2b")
+	for i := 0; i < eqep_overlay_wait_tries_; i++ {
+		waitFor(t, "wait for device", func() bool { return clock.Waiters() == 1 })
+		clock.Advance(eqep_overlay_wait_)
+	}
+	if err := <-done; err == nil {
+		t.Error("expected error when the device does not appear")
+	}
+}
+
+func Test_FakeEQEP(t *testing.T) {
+	var eqep EQEP = NewFakeEQEPOrPanic(0)
+	fake := eqep.(*FakeEQEP)
+	fake.SimulateCounts(100)
+	fake.SimulateCounts(20)
+	if pos, _ := eqep.ReadPosition(); pos != 120 {
+		t.Errorf("position %d instead of 120", pos)
+	}
+	eqep.SetMode(EQEP_MODE_RELATIVE)
+	eqep.SetPeriod(100 * time.Millisecond)
+	if pos, _ := eqep.ReadPosition(); pos != 20 {
+		t.Errorf("relative position %d instead of 20", pos)
+	}
+	if v, _ := eqep.ReadVelocity(); v != 200 {
+		t.Errorf("velocity %f instead of 200", v)
+	}
+}
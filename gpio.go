@@ -3,7 +3,7 @@
 package bbhw
 
 import (
-	"log"
+	"context"
 	"time"
 )
 
@@ -15,6 +15,18 @@ type GPIOControllablePin interface {
 	SetActiveLow(bool) error
 }
 
+// GPIOs which can notify about edges on inputs instead of having to be polled with GetState
+type GPIOEdgeWatchablePin interface {
+	GPIOControllablePin
+	WatchEdges(edge int) (<-chan GPIOEdgeEvent, error)
+	StopWatchingEdges() error
+}
+
+type GPIOEdgeEvent struct {
+	Edge      int // EDGE_RISING or EDGE_FALLING
+	Timestamp time.Time
+}
+
 type GPIOCollectionFactory interface {
 	EndTransactionApplySetStates()
 	BeginTransactionRecordSetStates()
@@ -38,12 +50,29 @@ const (
 	IN_PULLUP
 )
 
+const (
+	EDGE_NONE = iota
+	EDGE_RISING
+	EDGE_FALLING
+	EDGE_BOTH
+)
+
+// events are dropped if nobody reads the channel returned by WatchEdges
+const gpio_edge_event_buffer_size_ = 64
+
 type ADC interface {
 	ReadValue() uint16
 	CheckErrorOccurred() error
 	ReadValueCheckError() (uint16, error)
 }
 
+// ADC that also tells the raw count and the input voltage in V instead of whole mV
+type ScaledADC interface {
+	ADC
+	ReadRaw() (int64, error)
+	ReadVolts() (float64, error)
+}
+
 /// GPIOControllablePin Interface and Methods -----------------
 
 func GetStateOrPanic(gpio GPIOControllablePin) bool {
@@ -62,31 +91,36 @@ func CheckDirectionOrPanic(gpio GPIOControllablePin) int {
 	return r
 }
 
+func edgeMatches(edge int, rising bool) bool {
+	return edge == EDGE_BOTH || (edge == EDGE_RISING && rising) || (edge == EDGE_FALLING && !rising)
+}
+
+func newGPIOEdgeEvent(rising bool, ts time.Time) GPIOEdgeEvent {
+	if rising {
+		return GPIOEdgeEvent{Edge: EDGE_RISING, Timestamp: ts}
+	}
+	return GPIOEdgeEvent{Edge: EDGE_FALLING, Timestamp: ts}
+}
+
+// never blocks, drops the event if the receiver does not keep up
+func sendGPIOEdgeEvent(events chan<- GPIOEdgeEvent, ev GPIOEdgeEvent) {
+	select {
+	case events <- ev:
+	default:
+	}
+}
+
+// Emits steps pulses, each delay high and delay low. abortcheck, if not nil, is called between pulses and stops Step by returning true.
+// Returns the number of pulses emitted. Wrapper around PulseTrain, which can be cancelled through a context and has separate high and low durations.
 func Step(gpio GPIOControllablePin, steps uint32, delay time.Duration, abortcheck func() bool) (c uint32, err error) {
-	var curstate, oldstate bool
-	oldstate, err = gpio.GetState()
+	if steps == 0 {
+		return 0, nil
+	}
+	pt, err := NewPulseTrain(gpio, delay, delay)
 	if err != nil {
-		log.Println("Step GetState Error:", err)
 		return
 	}
-	//on abort or return set old state
-	defer gpio.SetState(oldstate)
-	// fix return value
-	// due to defer above, we always finish last step, so the number of actual steps takes is roundup(c/2)
-	// which is what we want to return
-	defer func() { c += c % 2; c /= 2 }()
-	curstate = oldstate
-	for c = 0; c < steps*2; c++ {
-		curstate = !curstate
-		err = gpio.SetState(curstate)
-		if err != nil {
-			log.Println("Step SetState Error:", err)
-			return
-		}
-		time.Sleep(delay)
-		if c%2 == 0 && abortcheck != nil && abortcheck() {
-			break
-		}
-	}
-	return
+	pt.betweenpulses = abortcheck
+	stats, err := pt.Run(context.Background(), uint64(steps))
+	return uint32(stats.Pulses), err
 }
@@ -0,0 +1,614 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Uses the /dev/gpiochipN character devices and the GPIO v2 ioctl uAPI provided by linux >= 5.10.
+// Works on any linux system with GPIOs, even if /sys/class/gpio has been removed from the kernel.
+type ChardevGPIO struct {
+	Chip   uint
+	Offset uint
+	chipfd *os.File
+	linefd *os.File
+	lfd    uintptr
+	chardevLineConfig
+	applied  uint64 // flags currently configured in the kernel
+	edgedone chan struct{}
+}
+
+type chardevLineConfig struct {
+	direction int
+	bias      int
+	drive     int
+	edge      int
+	activelow bool
+}
+
+const (
+	BIAS_AS_IS = iota
+	BIAS_DISABLE
+	BIAS_PULLUP
+	BIAS_PULLDOWN
+)
+
+const (
+	DRIVE_PUSHPULL = iota
+	DRIVE_OPENDRAIN
+	DRIVE_OPENSOURCE
+)
+
+/// ---------- GPIO v2 uAPI (see linux/include/uapi/linux/gpio.h) ---------------
+
+const (
+	gpio_max_name_size_         = 32
+	gpio_v2_lines_max_          = 64
+	gpio_v2_line_num_attrs_max_ = 10
+
+	gpio_v2_line_flag_used_           = 1 << 0
+	gpio_v2_line_flag_active_low_     = 1 << 1
+	gpio_v2_line_flag_input_          = 1 << 2
+	gpio_v2_line_flag_output_         = 1 << 3
+	gpio_v2_line_flag_edge_rising_    = 1 << 4
+	gpio_v2_line_flag_edge_falling_   = 1 << 5
+	gpio_v2_line_flag_open_drain_     = 1 << 6
+	gpio_v2_line_flag_open_source_    = 1 << 7
+	gpio_v2_line_flag_bias_pull_up_   = 1 << 8
+	gpio_v2_line_flag_bias_pull_down_ = 1 << 9
+	gpio_v2_line_flag_bias_disabled_  = 1 << 10
+
+	gpio_v2_line_attr_id_flags_         = 1
+	gpio_v2_line_attr_id_output_values_ = 2
+	gpio_v2_line_attr_id_debounce_      = 3
+
+	gpio_consumer_label_ = "bbhw"
+)
+
+type gpiochipInfo struct {
+	name  [gpio_max_name_size_]byte
+	label [gpio_max_name_size_]byte
+	lines uint32
+}
+
+// value is a union of flags, values and debounce_period_us in the kernel struct
+type gpioV2LineAttribute struct {
+	id      uint32
+	padding uint32
+	value   uint64
+}
+
+type gpioV2LineConfigAttribute struct {
+	attr gpioV2LineAttribute
+	mask uint64
+}
+
+type gpioV2LineConfig struct {
+	flags     uint64
+	num_attrs uint32
+	padding   [5]uint32
+	attrs     [gpio_v2_line_num_attrs_max_]gpioV2LineConfigAttribute
+}
+
+type gpioV2LineRequest struct {
+	offsets           [gpio_v2_lines_max_]uint32
+	consumer          [gpio_max_name_size_]byte
+	config            gpioV2LineConfig
+	num_lines         uint32
+	event_buffer_size uint32
+	padding           [5]uint32
+	fd                int32
+}
+
+type gpioV2LineValues struct {
+	bits uint64
+	mask uint64
+}
+
+type gpioV2LineEvent struct {
+	timestamp_ns uint64
+	id           uint32
+	offset       uint32
+	seqno        uint32
+	line_seqno   uint32
+	padding      [6]uint32
+}
+
+const (
+	gpio_v2_line_event_rising_edge_  = 1
+	gpio_v2_line_event_falling_edge_ = 2
+)
+
+type gpioV2LineInfo struct {
+	name      [gpio_max_name_size_]byte
+	consumer  [gpio_max_name_size_]byte
+	offset    uint32
+	num_attrs uint32
+	flags     uint64
+	attrs     [gpio_v2_line_num_attrs_max_]gpioV2LineAttribute
+	padding   [4]uint32
+}
+
+const (
+	ioc_write_ = 1
+	ioc_read_  = 2
+
+	gpio_get_chipinfo_ioctl_       = ioc_read_<<30 | uintptr(unsafe.Sizeof(gpiochipInfo{}))<<16 | 0xB4<<8 | 0x01
+	gpio_v2_get_lineinfo_ioctl_    = (ioc_read_|ioc_write_)<<30 | uintptr(unsafe.Sizeof(gpioV2LineInfo{}))<<16 | 0xB4<<8 | 0x05
+	gpio_v2_get_line_ioctl_        = (ioc_read_|ioc_write_)<<30 | uintptr(unsafe.Sizeof(gpioV2LineRequest{}))<<16 | 0xB4<<8 | 0x07
+	gpio_v2_line_set_config_ioctl_ = (ioc_read_|ioc_write_)<<30 | uintptr(unsafe.Sizeof(gpioV2LineConfig{}))<<16 | 0xB4<<8 | 0x0D
+	gpio_v2_line_get_values_ioctl_ = (ioc_read_|ioc_write_)<<30 | uintptr(unsafe.Sizeof(gpioV2LineValues{}))<<16 | 0xB4<<8 | 0x0E
+	gpio_v2_line_set_values_ioctl_ = (ioc_read_|ioc_write_)<<30 | uintptr(unsafe.Sizeof(gpioV2LineValues{}))<<16 | 0xB4<<8 | 0x0F
+)
+
+var gpiochip_dev_dir_ string = "/dev"
+
+// all ioctls on gpiochip and line request fds go through this variable
+// so tests can replace the kernel with a fake implementation
+var gpiochip_ioctl_ func(fd, req uintptr, arg unsafe.Pointer) error = gpiochipIoctl
+
+func gpiochipIoctl(fd, req uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if errno != 0 {
+		return os.NewSyscallError("SYS_IOCTL", errno)
+	}
+	return nil
+}
+
+func cstringToString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+/// ---------- gpiochip helpers ---------------
+
+func openGPIOChip(chip uint) (*os.File, error) {
+	return os.OpenFile(filepath.Join(gpiochip_dev_dir_, fmt.Sprintf("gpiochip%d", chip)), os.O_RDWR, 0666)
+}
+
+// returns the numbers of all /dev/gpiochipN in ascending order
+func listGPIOChips() (chips []uint, err error) {
+	var slist []string
+	slist, err = filepath.Glob(filepath.Join(gpiochip_dev_dir_, "gpiochip*"))
+	if err != nil {
+		return
+	}
+	for _, path := range slist {
+		n, perr := strconv.ParseUint(strings.TrimPrefix(filepath.Base(path), "gpiochip"), 10, 32)
+		if perr != nil {
+			continue
+		}
+		chips = append(chips, uint(n))
+	}
+	sort.Slice(chips, func(i, j int) bool { return chips[i] < chips[j] })
+	if len(chips) == 0 {
+		err = fmt.Errorf("No gpiochip found in %s", gpiochip_dev_dir_)
+	}
+	return
+}
+
+func getGPIOChipInfo(chipfd *os.File) (info gpiochipInfo, err error) {
+	err = gpiochip_ioctl_(chipfd.Fd(), gpio_get_chipinfo_ioctl_, unsafe.Pointer(&info))
+	return
+}
+
+func getGPIOLineInfo(chipfd *os.File, offset uint) (info gpioV2LineInfo, err error) {
+	info.offset = uint32(offset)
+	err = gpiochip_ioctl_(chipfd.Fd(), gpio_v2_get_lineinfo_ioctl_, unsafe.Pointer(&info))
+	return
+}
+
+// Translates a linux GPIO number (as in sysfs) into gpiochip and line offset
+// by counting the lines of all gpiochips in ascending order.
+// On the BeagleBone this yields gpio0..127 => gpiochip0..3, just like sysfs.
+func findGPIOChipLineByNumber(number uint) (chip, offset uint, err error) {
+	var chips []uint
+	if chips, err = listGPIOChips(); err != nil {
+		return
+	}
+	var base uint
+	for _, chip = range chips {
+		var chipfd *os.File
+		var info gpiochipInfo
+		if chipfd, err = openGPIOChip(chip); err != nil {
+			return
+		}
+		info, err = getGPIOChipInfo(chipfd)
+		chipfd.Close()
+		if err != nil {
+			return
+		}
+		if number < base+uint(info.lines) {
+			return chip, number - base, nil
+		}
+		base += uint(info.lines)
+	}
+	err = fmt.Errorf("GPIO %d not found on any gpiochip", number)
+	return
+}
+
+// Finds a line by the name given to it in the device-tree (gpio-line-names)
+func findGPIOChipLineByName(linename string) (chip, offset uint, err error) {
+	var chips []uint
+	if chips, err = listGPIOChips(); err != nil {
+		return
+	}
+	for _, chip = range chips {
+		var chipfd *os.File
+		var info gpiochipInfo
+		if chipfd, err = openGPIOChip(chip); err != nil {
+			return
+		}
+		if info, err = getGPIOChipInfo(chipfd); err != nil {
+			chipfd.Close()
+			return
+		}
+		for offset = 0; offset < uint(info.lines); offset++ {
+			lineinfo, lerr := getGPIOLineInfo(chipfd, offset)
+			if lerr == nil && cstringToString(lineinfo.name[:]) == linename {
+				chipfd.Close()
+				return chip, offset, nil
+			}
+		}
+		chipfd.Close()
+	}
+	err = fmt.Errorf("GPIO line named %s not found on any gpiochip", linename)
+	return
+}
+
+/// ---------- ChardevGPIO ---------------
+
+// Instantinate a new GPIO to control through /dev/gpiochipN. Takes GPIO numer (same as in sysfs) and direction bbhw.IN or bbhw.OUT
+//
+// Same signature as NewSysfsGPIO, so the two are readily interchangeable.
+func NewChardevGPIO(number uint, direction int) (gpio *ChardevGPIO, err error) {
+	var chip, offset uint
+	if chip, offset, err = findGPIOChipLineByNumber(number); err != nil {
+		return nil, err
+	}
+	return NewChardevGPIOOnChip(chip, offset, direction)
+}
+
+// Wrapper around NewChardevGPIO. Does not return an error but panics instead. Useful to avoid multiple return values.
+func NewChardevGPIOOrPanic(number uint, direction int) (gpio *ChardevGPIO) {
+	gpio, err := NewChardevGPIO(number, direction)
+	if err != nil {
+		panic(err)
+	}
+	return gpio
+}
+
+// Request a line by its name as given in the device-tree (e.g. "P8_12" on newer BeagleBone kernels)
+func NewChardevGPIOByName(linename string, direction int) (gpio *ChardevGPIO, err error) {
+	var chip, offset uint
+	if chip, offset, err = findGPIOChipLineByName(linename); err != nil {
+		return nil, err
+	}
+	return NewChardevGPIOOnChip(chip, offset, direction)
+}
+
+// Request line offset on /dev/gpiochip<chip>
+func NewChardevGPIOOnChip(chip, offset uint, direction int) (gpio *ChardevGPIO, err error) {
+	gpio = &ChardevGPIO{Chip: chip, Offset: offset}
+	if err = gpio.setDirection(direction); err != nil {
+		return nil, err
+	}
+	if gpio.chipfd, err = openGPIOChip(chip); err != nil {
+		return nil, err
+	}
+	var req gpioV2LineRequest
+	req.offsets[0] = uint32(offset)
+	req.num_lines = 1
+	copy(req.consumer[:gpio_max_name_size_-1], gpio_consumer_label_)
+	gpio.applied = gpio.flags()
+	req.config.flags = gpio.applied
+	if err = gpiochip_ioctl_(gpio.chipfd.Fd(), gpio_v2_get_line_ioctl_, unsafe.Pointer(&req)); err != nil {
+		gpio.chipfd.Close()
+		return nil, err
+	}
+	gpio.lfd = uintptr(req.fd)
+	// non-blocking, so pending reads of edge events can be interrupted
+	syscall.SetNonblock(int(gpio.lfd), true)
+	gpio.linefd = os.NewFile(gpio.lfd, fmt.Sprintf("gpiochip%d:%d", chip, offset))
+	return gpio, nil
+}
+
+func (lc *chardevLineConfig) setDirection(direction int) error {
+	switch direction {
+	case IN, OUT:
+	case IN_PULLDOWN:
+		lc.bias = BIAS_PULLDOWN
+	case IN_PULLUP:
+		lc.bias = BIAS_PULLUP
+	default:
+		return fmt.Errorf("Invalid Direction value")
+	}
+	lc.direction = direction
+	return nil
+}
+
+func (lc *chardevLineConfig) flags() (flags uint64) {
+	if lc.direction == OUT {
+		flags = gpio_v2_line_flag_output_
+		switch lc.drive {
+		case DRIVE_OPENDRAIN:
+			flags |= gpio_v2_line_flag_open_drain_
+		case DRIVE_OPENSOURCE:
+			flags |= gpio_v2_line_flag_open_source_
+		}
+	} else {
+		flags = gpio_v2_line_flag_input_
+		switch lc.edge {
+		case EDGE_RISING:
+			flags |= gpio_v2_line_flag_edge_rising_
+		case EDGE_FALLING:
+			flags |= gpio_v2_line_flag_edge_falling_
+		case EDGE_BOTH:
+			flags |= gpio_v2_line_flag_edge_rising_ | gpio_v2_line_flag_edge_falling_
+		}
+	}
+	switch lc.bias {
+	case BIAS_DISABLE:
+		flags |= gpio_v2_line_flag_bias_disabled_
+	case BIAS_PULLUP:
+		flags |= gpio_v2_line_flag_bias_pull_up_
+	case BIAS_PULLDOWN:
+		flags |= gpio_v2_line_flag_bias_pull_down_
+	}
+	if lc.activelow {
+		flags |= gpio_v2_line_flag_active_low_
+	}
+	return
+}
+
+// Fills config for a request with individual flags for each line.
+// Output lines are set to the (logical) values in outputvalues.
+func buildGPIOLineConfig(config *gpioV2LineConfig, lineflags []uint64, outputvalues uint64) error {
+	var outputmask uint64
+	config.flags = lineflags[0]
+	config.num_attrs = 0
+	for i, flags := range lineflags {
+		bit := uint64(1) << uint(i)
+		if flags&gpio_v2_line_flag_output_ > 0 {
+			outputmask |= bit
+		}
+		if flags == config.flags {
+			continue
+		}
+		a := 0
+		for ; a < int(config.num_attrs); a++ {
+			if config.attrs[a].attr.value == flags {
+				break
+			}
+		}
+		if a == int(config.num_attrs) {
+			if a >= gpio_v2_line_num_attrs_max_-1 {
+				return fmt.Errorf("Too many different line configurations in one request")
+			}
+			config.attrs[a].attr = gpioV2LineAttribute{id: gpio_v2_line_attr_id_flags_, value: flags}
+			config.attrs[a].mask = 0
+			config.num_attrs++
+		}
+		config.attrs[a].mask |= bit
+	}
+	if outputmask > 0 {
+		config.attrs[config.num_attrs].attr = gpioV2LineAttribute{id: gpio_v2_line_attr_id_output_values_, value: outputvalues & outputmask}
+		config.attrs[config.num_attrs].mask = outputmask
+		config.num_attrs++
+	}
+	return nil
+}
+
+// Returns the logical values which keep outputs at their current electrical level
+// once newflags replace the applied flags, i.e. just like SysfsGPIO changing active_low or bias keeps the level.
+// Lines which have not been outputs before start out low.
+func keepGPIOOutputLevels(lfd uintptr, applied, newflags []uint64) uint64 {
+	values := gpioV2LineValues{}
+	for i := range applied {
+		if applied[i]&gpio_v2_line_flag_output_ > 0 {
+			values.mask |= 1 << uint(i)
+		}
+	}
+	if values.mask == 0 || gpiochip_ioctl_(lfd, gpio_v2_line_get_values_ioctl_, unsafe.Pointer(&values)) != nil {
+		return 0
+	}
+	for i := range applied {
+		if (applied[i]^newflags[i])&gpio_v2_line_flag_active_low_ > 0 {
+			values.bits ^= 1 << uint(i)
+		}
+	}
+	return values.bits & values.mask
+}
+
+func (gpio *ChardevGPIO) reconfigure() error {
+	var config gpioV2LineConfig
+	flags := gpio.flags()
+	outputvalues := keepGPIOOutputLevels(gpio.lfd, []uint64{gpio.applied}, []uint64{flags})
+	if err := buildGPIOLineConfig(&config, []uint64{flags}, outputvalues); err != nil {
+		return err
+	}
+	if err := gpiochip_ioctl_(gpio.lfd, gpio_v2_line_set_config_ioctl_, unsafe.Pointer(&config)); err != nil {
+		return err
+	}
+	gpio.applied = flags
+	return nil
+}
+
+// Queries the kernel for the current line configuration
+func (gpio *ChardevGPIO) CheckDirection() (direction int, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	var info gpioV2LineInfo
+	direction = -1
+	if info, err = getGPIOLineInfo(gpio.chipfd, gpio.Offset); err != nil {
+		return
+	}
+	if info.flags&gpio_v2_line_flag_output_ > 0 {
+		direction = OUT
+	} else if info.flags&gpio_v2_line_flag_bias_pull_up_ > 0 {
+		direction = IN_PULLUP
+	} else if info.flags&gpio_v2_line_flag_bias_pull_down_ > 0 {
+		direction = IN_PULLDOWN
+	} else {
+		direction = IN
+	}
+	return
+}
+
+// bbhw.IN_PULLUP and bbhw.IN_PULLDOWN set the bias, bbhw.IN keeps the bias set previously
+func (gpio *ChardevGPIO) SetDirection(direction int) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if err := gpio.setDirection(direction); err != nil {
+		return err
+	}
+	return gpio.reconfigure()
+}
+
+// Takes bbhw.BIAS_AS_IS, bbhw.BIAS_DISABLE, bbhw.BIAS_PULLUP or bbhw.BIAS_PULLDOWN
+func (gpio *ChardevGPIO) SetBias(bias int) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if bias < BIAS_AS_IS || bias > BIAS_PULLDOWN {
+		return fmt.Errorf("Invalid Bias value")
+	}
+	gpio.bias = bias
+	return gpio.reconfigure()
+}
+
+// Takes bbhw.DRIVE_PUSHPULL, bbhw.DRIVE_OPENDRAIN or bbhw.DRIVE_OPENSOURCE.
+// Only has an effect while the gpio is configured as output.
+func (gpio *ChardevGPIO) SetDrive(drive int) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if drive < DRIVE_PUSHPULL || drive > DRIVE_OPENSOURCE {
+		return fmt.Errorf("Invalid Drive value")
+	}
+	gpio.drive = drive
+	return gpio.reconfigure()
+}
+
+// this inverts the meaning of 0 and 1, the kernel takes care of it
+// just like SysfsGPIO, the electrical level of an output does not change
+func (gpio *ChardevGPIO) SetActiveLow(activelow bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.activelow = activelow
+	return gpio.reconfigure()
+}
+
+func (gpio *ChardevGPIO) GetState() (state bool, err error) {
+	if gpio == nil || gpio.linefd == nil {
+		panic("gpio == nil")
+	}
+	values := gpioV2LineValues{mask: 1}
+	err = gpiochip_ioctl_(gpio.lfd, gpio_v2_line_get_values_ioctl_, unsafe.Pointer(&values))
+	state = values.bits&1 > 0
+	return
+}
+
+func (gpio *ChardevGPIO) SetState(state bool) error {
+	if gpio == nil || gpio.linefd == nil {
+		panic("gpio == nil")
+	}
+	values := gpioV2LineValues{mask: 1}
+	if state {
+		values.bits = 1
+	}
+	return gpiochip_ioctl_(gpio.lfd, gpio_v2_line_set_values_ioctl_, unsafe.Pointer(&values))
+}
+
+func (gpio *ChardevGPIO) SetStateNow(state bool) error { return gpio.SetState(state) }
+
+// converts a CLOCK_MONOTONIC timestamp as used by line events into wall clock time
+func monotonicToTime(ns uint64) time.Time {
+	var ts syscall.Timespec
+	now := time.Now()
+	if _, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, 1 /*CLOCK_MONOTONIC*/, uintptr(unsafe.Pointer(&ts)), 0); errno != 0 {
+		return now
+	}
+	return now.Add(-time.Duration(ts.Nano() - int64(ns)))
+}
+
+// Enables edge detection in the kernel and reads line events in a goroutine.
+// Returns a channel of events which is closed by StopWatchingEdges.
+// The gpio has to be an input.
+func (gpio *ChardevGPIO) WatchEdges(edge int) (<-chan GPIOEdgeEvent, error) {
+	if gpio == nil || gpio.linefd == nil {
+		panic("gpio == nil")
+	}
+	if gpio.edgedone != nil {
+		return nil, fmt.Errorf("gpiochip%d:%d is already being watched", gpio.Chip, gpio.Offset)
+	}
+	if gpio.direction == OUT {
+		return nil, fmt.Errorf("gpiochip%d:%d is not configured as Input", gpio.Chip, gpio.Offset)
+	}
+	if edge <= EDGE_NONE || edge > EDGE_BOTH {
+		return nil, fmt.Errorf("Invalid Edge value")
+	}
+	gpio.edge = edge
+	if err := gpio.reconfigure(); err != nil {
+		gpio.edge = EDGE_NONE
+		return nil, err
+	}
+	gpio.linefd.SetReadDeadline(time.Time{})
+	events := make(chan GPIOEdgeEvent, gpio_edge_event_buffer_size_)
+	gpio.edgedone = make(chan struct{})
+	go func(linefd *os.File, done chan struct{}) {
+		defer close(done)
+		defer close(events)
+		var lineevents [16]gpioV2LineEvent
+		evsize := int(unsafe.Sizeof(lineevents[0]))
+		buf := (*[unsafe.Sizeof(lineevents)]byte)(unsafe.Pointer(&lineevents))[:]
+		for {
+			n, err := linefd.Read(buf)
+			if err != nil {
+				return
+			}
+			for i := 0; i < n/evsize; i++ {
+				rising := lineevents[i].id == gpio_v2_line_event_rising_edge_
+				sendGPIOEdgeEvent(events, newGPIOEdgeEvent(rising, monotonicToTime(lineevents[i].timestamp_ns)))
+			}
+		}
+	}(gpio.linefd, gpio.edgedone)
+	return events, nil
+}
+
+// Stops the goroutine started by WatchEdges, closes its channel and disables edge detection
+func (gpio *ChardevGPIO) StopWatchingEdges() error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if gpio.edgedone == nil {
+		return nil
+	}
+	gpio.linefd.SetReadDeadline(time.Now())
+	<-gpio.edgedone
+	gpio.edgedone = nil
+	gpio.edge = EDGE_NONE
+	return gpio.reconfigure()
+}
+
+// releases the line and closes the gpiochip
+func (gpio *ChardevGPIO) Close() {
+	gpio.StopWatchingEdges()
+	gpio.linefd.Close()
+	gpio.chipfd.Close()
+	gpio = nil
+}
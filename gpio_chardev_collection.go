@@ -0,0 +1,336 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// Uses the /dev/gpiochipN character devices just like ChardevGPIO.
+// Same as ChardevGPIO, but part of a collection of GPIOs you can set all at once using database-like transactions.
+type ChardevGPIOInCollection struct {
+	Chip   uint
+	Offset uint
+	chardevLineConfig
+	group      *chardevLineGroup
+	idx        uint
+	collection *ChardevGPIOCollectionFactory
+}
+
+// Collection of GPIOs. Records SetState() calls after BeginTransactionRecordSetStates() has been called and delays their effect until EndTransactionApplySetStates() is called.
+// Use it to toggle many GPIOs in the very same instant.
+//
+// All lines of a gpiochip are held in a single multi-line request,
+// so EndTransactionApplySetStates() needs only one ioctl per gpiochip.
+type ChardevGPIOCollectionFactory struct {
+	groups         map[uint]*chardevLineGroup
+	record_changes bool
+	lock           sync.Mutex
+}
+
+// all lines of one gpiochip
+type chardevLineGroup struct {
+	chip         uint
+	chipfd       *os.File
+	linefd       *os.File
+	lfd          uintptr
+	lines        []*ChardevGPIOInCollection
+	applied      []uint64
+	future_bits  uint64
+	future_mask  uint64
+	outputvalues uint64 // output levels to restore when re-requesting lines
+}
+
+/// ---------- chardevLineGroup ---------------
+
+func (group *chardevLineGroup) lineflags() []uint64 {
+	flags := make([]uint64, len(group.lines))
+	for i, gpio := range group.lines {
+		flags[i] = gpio.flags()
+	}
+	return flags
+}
+
+// releases all lines and requests them again, including any line added in the meantime
+func (group *chardevLineGroup) request() (err error) {
+	var req gpioV2LineRequest
+	flags := group.lineflags()
+	if len(flags) > gpio_v2_lines_max_ {
+		return fmt.Errorf("Can not request more than %d lines of gpiochip%d", gpio_v2_lines_max_, group.chip)
+	}
+	if group.linefd != nil {
+		group.outputvalues = keepGPIOOutputLevels(group.lfd, group.applied, flags[:len(group.applied)])
+		group.linefd.Close()
+		group.linefd = nil
+	}
+	for i, gpio := range group.lines {
+		req.offsets[i] = uint32(gpio.Offset)
+	}
+	req.num_lines = uint32(len(group.lines))
+	copy(req.consumer[:gpio_max_name_size_-1], gpio_consumer_label_)
+	if err = buildGPIOLineConfig(&req.config, flags, group.outputvalues); err != nil {
+		return
+	}
+	if err = gpiochip_ioctl_(group.chipfd.Fd(), gpio_v2_get_line_ioctl_, unsafe.Pointer(&req)); err != nil {
+		return
+	}
+	group.lfd = uintptr(req.fd)
+	group.linefd = os.NewFile(group.lfd, fmt.Sprintf("gpiochip%d", group.chip))
+	group.applied = flags
+	return nil
+}
+
+func (group *chardevLineGroup) reconfigure() error {
+	var config gpioV2LineConfig
+	flags := group.lineflags()
+	outputvalues := keepGPIOOutputLevels(group.lfd, group.applied, flags)
+	if err := buildGPIOLineConfig(&config, flags, outputvalues); err != nil {
+		return err
+	}
+	if err := gpiochip_ioctl_(group.lfd, gpio_v2_line_set_config_ioctl_, unsafe.Pointer(&config)); err != nil {
+		return err
+	}
+	group.applied = flags
+	return nil
+}
+
+func (group *chardevLineGroup) setValues(bits, mask uint64) error {
+	values := gpioV2LineValues{bits: bits, mask: mask}
+	return gpiochip_ioctl_(group.lfd, gpio_v2_line_set_values_ioctl_, unsafe.Pointer(&values))
+}
+
+func (group *chardevLineGroup) getValues(mask uint64) (uint64, error) {
+	values := gpioV2LineValues{mask: mask}
+	err := gpiochip_ioctl_(group.lfd, gpio_v2_line_get_values_ioctl_, unsafe.Pointer(&values))
+	return values.bits, err
+}
+
+/// ---------- ChardevGPIOCollectionFactory ---------------
+
+// Create a collection of GPIOs.
+// Doubles as factory for the ChardevGPIOInCollection type.
+func NewChardevGPIOCollectionFactory() (gpiocf *ChardevGPIOCollectionFactory) {
+	gpiocf = new(ChardevGPIOCollectionFactory)
+	gpiocf.groups = make(map[uint]*chardevLineGroup)
+	return gpiocf
+}
+
+// Apply States recorded with BeginTransactionRecordSetStates
+// using a single ioctl for each gpiochip
+func (gpiocf *ChardevGPIOCollectionFactory) EndTransactionApplySetStates() {
+	gpiocf.lock.Lock()
+	defer gpiocf.lock.Unlock()
+	for _, group := range gpiocf.groups {
+		if group.future_mask > 0 {
+			group.setValues(group.future_bits, group.future_mask)
+		}
+		group.future_bits = 0
+		group.future_mask = 0
+	}
+	gpiocf.record_changes = false
+}
+
+// Begin recording calls to SetState for later
+func (gpiocf *ChardevGPIOCollectionFactory) BeginTransactionRecordSetStates() {
+	gpiocf.lock.Lock()
+	defer gpiocf.lock.Unlock()
+	gpiocf.record_changes = true
+}
+
+// Same as NewChardevGPIO but part of a ChardevGPIOCollectionFactory
+func (gpiocf *ChardevGPIOCollectionFactory) NewChardevGPIO(number uint, direction int) (gpio *ChardevGPIOInCollection, err error) {
+	var chip, offset uint
+	if chip, offset, err = findGPIOChipLineByNumber(number); err != nil {
+		return nil, err
+	}
+	return gpiocf.NewChardevGPIOOnChip(chip, offset, direction)
+}
+
+// Wrapper around NewChardevGPIO. Does not return an error but panics instead.
+func (gpiocf *ChardevGPIOCollectionFactory) NewChardevGPIOOrPanic(number uint, direction int) (gpio *ChardevGPIOInCollection) {
+	gpio, err := gpiocf.NewChardevGPIO(number, direction)
+	if err != nil {
+		panic(err)
+	}
+	return gpio
+}
+
+// Same as NewChardevGPIOByName but part of a ChardevGPIOCollectionFactory
+func (gpiocf *ChardevGPIOCollectionFactory) NewChardevGPIOByName(linename string, direction int) (gpio *ChardevGPIOInCollection, err error) {
+	var chip, offset uint
+	if chip, offset, err = findGPIOChipLineByName(linename); err != nil {
+		return nil, err
+	}
+	return gpiocf.NewChardevGPIOOnChip(chip, offset, direction)
+}
+
+// Same as NewChardevGPIOOnChip but part of a ChardevGPIOCollectionFactory.
+// Re-requests all lines of the gpiochip already in the collection, outputs keep their level.
+func (gpiocf *ChardevGPIOCollectionFactory) NewChardevGPIOOnChip(chip, offset uint, direction int) (gpio *ChardevGPIOInCollection, err error) {
+	gpio = &ChardevGPIOInCollection{Chip: chip, Offset: offset, collection: gpiocf}
+	if err = gpio.setDirection(direction); err != nil {
+		return nil, err
+	}
+	gpiocf.lock.Lock()
+	defer gpiocf.lock.Unlock()
+	group, exists := gpiocf.groups[chip]
+	if !exists {
+		group = &chardevLineGroup{chip: chip}
+		if group.chipfd, err = openGPIOChip(chip); err != nil {
+			return nil, err
+		}
+	}
+	for _, other := range group.lines {
+		if other.Offset == offset {
+			return nil, fmt.Errorf("gpiochip%d:%d is already part of this collection", chip, offset)
+		}
+	}
+	gpio.group = group
+	gpio.idx = uint(len(group.lines))
+	group.lines = append(group.lines, gpio)
+	if err = group.request(); err != nil {
+		group.lines = group.lines[:gpio.idx]
+		if len(group.lines) > 0 {
+			group.request()
+		} else {
+			group.chipfd.Close()
+		}
+		return nil, err
+	}
+	gpiocf.groups[chip] = group
+	return gpio, nil
+}
+
+func (gpiocf *ChardevGPIOCollectionFactory) NewGPIO(number uint, direction int) GPIOControllablePinInCollection {
+	return gpiocf.NewChardevGPIOOrPanic(number, direction)
+}
+
+// releases all lines and closes all gpiochips
+func (gpiocf *ChardevGPIOCollectionFactory) Close() {
+	gpiocf.lock.Lock()
+	defer gpiocf.lock.Unlock()
+	for chip, group := range gpiocf.groups {
+		group.linefd.Close()
+		group.chipfd.Close()
+		delete(gpiocf.groups, chip)
+	}
+}
+
+/// ------------- ChardevGPIOInCollection Methods -------------------
+
+func (gpio *ChardevGPIOInCollection) bit() uint64 {
+	return uint64(1) << gpio.idx
+}
+
+func (gpio *ChardevGPIOInCollection) CheckDirection() (direction int, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	var info gpioV2LineInfo
+	direction = -1
+	if info, err = getGPIOLineInfo(gpio.group.chipfd, gpio.Offset); err != nil {
+		return
+	}
+	if info.flags&gpio_v2_line_flag_output_ > 0 {
+		direction = OUT
+	} else if info.flags&gpio_v2_line_flag_bias_pull_up_ > 0 {
+		direction = IN_PULLUP
+	} else if info.flags&gpio_v2_line_flag_bias_pull_down_ > 0 {
+		direction = IN_PULLDOWN
+	} else {
+		direction = IN
+	}
+	return
+}
+
+func (gpio *ChardevGPIOInCollection) SetDirection(direction int) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	if err := gpio.setDirection(direction); err != nil {
+		return err
+	}
+	return gpio.group.reconfigure()
+}
+
+// this inverts the meaning of 0 and 1, the kernel takes care of it
+// just like SysfsGPIO, the electrical level of an output does not change
+func (gpio *ChardevGPIOInCollection) SetActiveLow(activelow bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	gpio.activelow = activelow
+	return gpio.group.reconfigure()
+}
+
+func (gpio *ChardevGPIOInCollection) GetState() (state bool, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	// request closes and replaces the line fd of the group when a line is added to it
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	var bits uint64
+	bits, err = gpio.group.getValues(gpio.bit())
+	state = bits&gpio.bit() > 0
+	return
+}
+
+func (gpio *ChardevGPIOInCollection) SetStateNow(state bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	var bits uint64
+	if state {
+		bits = gpio.bit()
+	}
+	return gpio.group.setValues(bits, gpio.bit())
+}
+
+func (gpio *ChardevGPIOInCollection) SetFutureState(state bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	gpio.group.future_mask |= gpio.bit()
+	if state {
+		gpio.group.future_bits |= gpio.bit()
+	} else {
+		gpio.group.future_bits &= ^gpio.bit()
+	}
+	return nil
+}
+
+// / Checks if State was Set during a transaction but not yet applied
+// / state_known returns true if state was set
+// / state returns the future state
+// / err returns nil
+func (gpio *ChardevGPIOInCollection) GetFutureState() (state_known, state bool, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	state_known = gpio.group.future_mask&gpio.bit() > 0
+	state = gpio.group.future_bits&gpio.bit() > 0
+	return
+}
+
+func (gpio *ChardevGPIOInCollection) SetState(state bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if gpio.collection.record_changes {
+		return gpio.SetFutureState(state)
+	} else {
+		return gpio.SetStateNow(state)
+	}
+}
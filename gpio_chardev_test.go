@@ -0,0 +1,470 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// Fake GPIO v2 uAPI. Replaces the kernel by handling all ioctls going through gpiochip_ioctl_.
+// Chips are regular files named gpiochipN in a temporary directory, line requests are pipes.
+
+type fakeGPIOLine struct {
+	name      string
+	flags     uint64
+	value     bool // physical value
+	requested bool
+}
+
+type fakeGPIOChip struct {
+	lines []fakeGPIOLine
+}
+
+type fakeGPIOLineRequest struct {
+	chip    *fakeGPIOChip
+	offsets []uint32
+	w       *os.File // write end of the pipe handed out as line request fd
+	rlink   string   // to notice when the read end got closed
+}
+
+type fakeGPIOUAPI struct {
+	lock     sync.Mutex
+	chips    map[string]*fakeGPIOChip
+	requests map[uintptr]*fakeGPIOLineRequest
+	olddir   string
+	setcalls int
+}
+
+func newFakeGPIOUAPI(t *testing.T, chiplines ...[]string) *fakeGPIOUAPI {
+	fake := &fakeGPIOUAPI{chips: make(map[string]*fakeGPIOChip), requests: make(map[uintptr]*fakeGPIOLineRequest)}
+	dir := t.TempDir()
+	for n, names := range chiplines {
+		chipname := fmt.Sprintf("gpiochip%d", n)
+		if err := os.WriteFile(filepath.Join(dir, chipname), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		chip := &fakeGPIOChip{lines: make([]fakeGPIOLine, len(names))}
+		for i, name := range names {
+			chip.lines[i].name = name
+		}
+		fake.chips[chipname] = chip
+	}
+	fake.olddir = gpiochip_dev_dir_
+	gpiochip_dev_dir_ = dir
+	gpiochip_ioctl_ = fake.ioctl
+	t.Cleanup(fake.restore)
+	return fake
+}
+
+func (fake *fakeGPIOUAPI) restore() {
+	gpiochip_dev_dir_ = fake.olddir
+	gpiochip_ioctl_ = gpiochipIoctl
+	for _, req := range fake.requests {
+		req.w.Close()
+	}
+}
+
+func (fake *fakeGPIOUAPI) line(chip string, offset int) *fakeGPIOLine {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	return &fake.chips[chip].lines[offset]
+}
+
+// like the kernel, release lines of requests whose fd has been closed
+func (fake *fakeGPIOUAPI) releaseClosedRequests() {
+	for fd, fr := range fake.requests {
+		if link, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd)); err == nil && link == fr.rlink {
+			continue
+		}
+		for _, offset := range fr.offsets {
+			fr.chip.lines[offset].requested = false
+		}
+		fr.w.Close()
+		delete(fake.requests, fd)
+	}
+}
+
+func (fake *fakeGPIOUAPI) chipForFd(fd uintptr) *fakeGPIOChip {
+	path, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err != nil {
+		return nil
+	}
+	return fake.chips[filepath.Base(path)]
+}
+
+// flags for line idx of a request, honouring attributes with a matching mask
+func (fake *fakeGPIOUAPI) configFlags(config *gpioV2LineConfig, idx int) uint64 {
+	flags := config.flags
+	for a := 0; a < int(config.num_attrs); a++ {
+		if config.attrs[a].attr.id == gpio_v2_line_attr_id_flags_ && config.attrs[a].mask&(1<<uint(idx)) > 0 {
+			flags = config.attrs[a].attr.value
+		}
+	}
+	return flags
+}
+
+// like the kernel, outputs are set to the output values attribute or low
+func (fake *fakeGPIOUAPI) applyConfig(line *fakeGPIOLine, config *gpioV2LineConfig, idx int) {
+	line.flags = fake.configFlags(config, idx)
+	if line.flags&gpio_v2_line_flag_output_ == 0 {
+		return
+	}
+	logical := false
+	for a := 0; a < int(config.num_attrs); a++ {
+		if config.attrs[a].attr.id == gpio_v2_line_attr_id_output_values_ && config.attrs[a].mask&(1<<uint(idx)) > 0 {
+			logical = config.attrs[a].attr.value&(1<<uint(idx)) > 0
+		}
+	}
+	line.value = logical != (line.flags&gpio_v2_line_flag_active_low_ > 0)
+}
+
+func (fake *fakeGPIOUAPI) ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	switch req {
+	case gpio_get_chipinfo_ioctl_:
+		chip := fake.chipForFd(fd)
+		if chip == nil {
+			return syscall.ENOTTY
+		}
+		(*gpiochipInfo)(arg).lines = uint32(len(chip.lines))
+	case gpio_v2_get_lineinfo_ioctl_:
+		chip := fake.chipForFd(fd)
+		info := (*gpioV2LineInfo)(arg)
+		if chip == nil || int(info.offset) >= len(chip.lines) {
+			return syscall.EINVAL
+		}
+		line := chip.lines[info.offset]
+		copy(info.name[:], line.name)
+		info.flags = line.flags
+		if line.requested {
+			info.flags |= gpio_v2_line_flag_used_
+		}
+	case gpio_v2_get_line_ioctl_:
+		fake.releaseClosedRequests()
+		chip := fake.chipForFd(fd)
+		lreq := (*gpioV2LineRequest)(arg)
+		if chip == nil || lreq.num_lines == 0 || lreq.num_lines > gpio_v2_lines_max_ {
+			return syscall.EINVAL
+		}
+		fr := &fakeGPIOLineRequest{chip: chip, offsets: make([]uint32, lreq.num_lines)}
+		for i := range fr.offsets {
+			offset := lreq.offsets[i]
+			if int(offset) >= len(chip.lines) {
+				return syscall.EINVAL
+			}
+			if chip.lines[offset].requested {
+				return syscall.EBUSY
+			}
+			fr.offsets[i] = offset
+		}
+		for i, offset := range fr.offsets {
+			chip.lines[offset].requested = true
+			fake.applyConfig(&chip.lines[offset], &lreq.config, i)
+		}
+		var p [2]int
+		if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+			return err
+		}
+		fr.w = os.NewFile(uintptr(p[1]), "fakegpiolinerequest")
+		fr.rlink, _ = os.Readlink(fmt.Sprintf("/proc/self/fd/%d", p[0]))
+		lreq.fd = int32(p[0])
+		fake.requests[uintptr(lreq.fd)] = fr
+	case gpio_v2_line_set_config_ioctl_:
+		fr, ok := fake.requests[fd]
+		if !ok {
+			return syscall.EBADF
+		}
+		for i, offset := range fr.offsets {
+			fake.applyConfig(&fr.chip.lines[offset], (*gpioV2LineConfig)(arg), i)
+		}
+	case gpio_v2_line_get_values_ioctl_:
+		fr, ok := fake.requests[fd]
+		if !ok {
+			return syscall.EBADF
+		}
+		values := (*gpioV2LineValues)(arg)
+		values.bits = 0
+		for i, offset := range fr.offsets {
+			line := fr.chip.lines[offset]
+			if values.mask&(1<<uint(i)) > 0 && line.value != (line.flags&gpio_v2_line_flag_active_low_ > 0) {
+				values.bits |= 1 << uint(i)
+			}
+		}
+	case gpio_v2_line_set_values_ioctl_:
+		fr, ok := fake.requests[fd]
+		if !ok {
+			return syscall.EBADF
+		}
+		fake.setcalls++
+		values := (*gpioV2LineValues)(arg)
+		for i, offset := range fr.offsets {
+			line := &fr.chip.lines[offset]
+			if values.mask&(1<<uint(i)) == 0 {
+				continue
+			}
+			if line.flags&gpio_v2_line_flag_output_ == 0 {
+				return syscall.EPERM
+			}
+			line.value = (values.bits&(1<<uint(i)) > 0) != (line.flags&gpio_v2_line_flag_active_low_ > 0)
+		}
+	default:
+		return syscall.ENOTTY
+	}
+	return nil
+}
+
+// writes a line event to the pipe backing the line request of gpio
+func (fake *fakeGPIOUAPI) emitEvent(gpio *ChardevGPIO, id uint32) {
+	fake.lock.Lock()
+	fr := fake.requests[gpio.lfd]
+	fake.lock.Unlock()
+	ev := gpioV2LineEvent{timestamp_ns: 1, id: id, offset: uint32(gpio.Offset)}
+	fr.w.Write((*[unsafe.Sizeof(ev)]byte)(unsafe.Pointer(&ev))[:])
+}
+
+func Test_ChardevGPIOStructSizes(t *testing.T) {
+	// sizes as in linux/include/uapi/linux/gpio.h
+	if s := unsafe.Sizeof(gpiochipInfo{}); s != 68 {
+		t.Errorf("sizeof gpiochip_info is %d", s)
+	}
+	if s := unsafe.Sizeof(gpioV2LineInfo{}); s != 256 {
+		t.Errorf("sizeof gpio_v2_line_info is %d", s)
+	}
+	if s := unsafe.Sizeof(gpioV2LineConfig{}); s != 272 {
+		t.Errorf("sizeof gpio_v2_line_config is %d", s)
+	}
+	if s := unsafe.Sizeof(gpioV2LineRequest{}); s != 592 {
+		t.Errorf("sizeof gpio_v2_line_request is %d", s)
+	}
+	if s := unsafe.Sizeof(gpioV2LineEvent{}); s != 48 {
+		t.Errorf("sizeof gpio_v2_line_event is %d", s)
+	}
+	if gpio_v2_get_line_ioctl_ != 0xC250B407 {
+		t.Errorf("GPIO_V2_GET_LINE_IOCTL is %x", gpio_v2_get_line_ioctl_)
+	}
+}
+
+func Test_ChardevGPIO(t *testing.T) {
+	fake := newFakeGPIOUAPI(t, []string{"a", "b", "c", "d"}, []string{"P8_12", "P8_11", "", ""})
+
+	// gpio 5 is the second line of the second chip
+	g := NewChardevGPIOOrPanic(5, OUT)
+	defer g.Close()
+	if g.Chip != 1 || g.Offset != 1 {
+		t.Fatalf("gpio 5 mapped to gpiochip%d:%d", g.Chip, g.Offset)
+	}
+	if CheckDirectionOrPanic(g) != OUT {
+		t.Error("CheckDirection != OUT")
+	}
+	g.SetState(true)
+	if !fake.line("gpiochip1", 1).value || GetStateOrPanic(g) != true {
+		t.Error("SetState(true) did not work")
+	}
+	g.SetActiveLow(true)
+	if fake.line("gpiochip1", 1).flags&gpio_v2_line_flag_active_low_ == 0 {
+		t.Error("active low flag not set")
+	}
+	if GetStateOrPanic(g) != false {
+		t.Error("SetActiveLow did not invert state")
+	}
+	g.SetState(true)
+	if fake.line("gpiochip1", 1).value {
+		t.Error("SetState(true) on active low gpio did not set line low")
+	}
+	g.SetDrive(DRIVE_OPENDRAIN)
+	if fake.line("gpiochip1", 1).flags&gpio_v2_line_flag_open_drain_ == 0 {
+		t.Error("open drain flag not set")
+	}
+
+	if _, err := NewChardevGPIO(8, IN); err == nil {
+		t.Error("gpio 8 should not exist")
+	}
+	if _, err := NewChardevGPIO(5, IN); err == nil {
+		t.Error("requesting a line twice should fail")
+	}
+}
+
+func Test_ChardevGPIOByName(t *testing.T) {
+	fake := newFakeGPIOUAPI(t, []string{"a", "b"}, []string{"P8_12", "P8_11"})
+
+	g, err := NewChardevGPIOByName("P8_11", IN_PULLUP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+	if g.Chip != 1 || g.Offset != 1 {
+		t.Fatalf("P8_11 mapped to gpiochip%d:%d", g.Chip, g.Offset)
+	}
+	if CheckDirectionOrPanic(g) != IN_PULLUP {
+		t.Error("CheckDirection != IN_PULLUP")
+	}
+	fake.line("gpiochip1", 1).value = true
+	if GetStateOrPanic(g) != true {
+		t.Error("GetState did not read line")
+	}
+	if err := g.SetState(false); err == nil {
+		t.Error("SetState on input should fail")
+	}
+	g.SetBias(BIAS_PULLDOWN)
+	if CheckDirectionOrPanic(g) != IN_PULLDOWN {
+		t.Error("SetBias(BIAS_PULLDOWN) did not work")
+	}
+	g.SetDirection(OUT)
+	if flags := fake.line("gpiochip1", 1).flags; flags&gpio_v2_line_flag_output_ == 0 || flags&gpio_v2_line_flag_input_ > 0 {
+		t.Error("SetDirection(OUT) did not work")
+	}
+	if _, err := NewChardevGPIOByName("P9_99", IN); err == nil || !strings.Contains(err.Error(), "P9_99") {
+		t.Error("unknown line name should fail")
+	}
+}
+
+func Test_ChardevGPIOEdges(t *testing.T) {
+	fake := newFakeGPIOUAPI(t, []string{"a", "b"})
+	g := NewChardevGPIOOrPanic(1, IN)
+	defer g.Close()
+
+	events, err := g.WatchEdges(EDGE_BOTH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flags := fake.line("gpiochip0", 1).flags
+	if flags&gpio_v2_line_flag_edge_rising_ == 0 || flags&gpio_v2_line_flag_edge_falling_ == 0 {
+		t.Error("edge flags not set")
+	}
+	fake.emitEvent(g, gpio_v2_line_event_rising_edge_)
+	fake.emitEvent(g, gpio_v2_line_event_falling_edge_)
+	for _, expected := range []int{EDGE_RISING, EDGE_FALLING} {
+		select {
+		case ev := <-events:
+			if ev.Edge != expected {
+				t.Errorf("expected edge %d, got %d", expected, ev.Edge)
+			}
+			if ev.Timestamp.After(time.Now()) {
+				t.Error("event timestamp lies in the future")
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no edge event")
+		}
+	}
+	if err := g.StopWatchingEdges(); err != nil {
+		t.Error(err)
+	}
+	if _, stillopen := <-events; stillopen {
+		t.Error("StopWatchingEdges did not close channel")
+	}
+	if fake.line("gpiochip0", 1).flags&gpio_v2_line_flag_edge_rising_ > 0 {
+		t.Error("edge flags not cleared")
+	}
+	if GetStateOrPanic(g) != false {
+		t.Error("line request unusable after StopWatchingEdges")
+	}
+}
+
+func Test_ChardevGPIOCollection(t *testing.T) {
+	fake := newFakeGPIOUAPI(t, []string{"a", "b", "c", "d"}, []string{"e", "f", "g", "h"})
+	var gf GPIOCollectionFactory = NewChardevGPIOCollectionFactory()
+	defer gf.(*ChardevGPIOCollectionFactory).Close()
+
+	g1 := gf.NewGPIO(1, OUT)
+	g1.SetState(true)
+	// adding lines of the same chip re-requests g1, which has to keep its level
+	g2 := gf.NewGPIO(2, OUT)
+	g3 := gf.NewGPIO(3, IN)
+	g5 := gf.NewGPIO(5, OUT)
+	if !fake.line("gpiochip0", 1).value || GetStateOrPanic(g1) != true {
+		t.Fatal("g1 lost its state when g2 was added")
+	}
+	if flags := fake.line("gpiochip0", 3).flags; flags&gpio_v2_line_flag_input_ == 0 {
+		t.Error("g3 not configured as input")
+	}
+	if CheckDirectionOrPanic(g3) != IN || CheckDirectionOrPanic(g2) != OUT {
+		t.Error("CheckDirection does not match")
+	}
+
+	gf.BeginTransactionRecordSetStates()
+	g1.SetState(false)
+	g2.SetState(true)
+	g5.SetState(true)
+	if GetStateOrPanic(g1) != true || GetStateOrPanic(g2) != false || GetStateOrPanic(g5) != false {
+		t.Fatal("BeginTransactionRecordSetStates did not work")
+	}
+	if known, state, _ := g2.GetFutureState(); !known || !state {
+		t.Error("GetFutureState does not return recorded state")
+	}
+	if known, _, _ := g3.GetFutureState(); known {
+		t.Error("GetFutureState knows state that was never set")
+	}
+	fake.setcalls = 0
+	gf.EndTransactionApplySetStates()
+	if fake.setcalls != 2 {
+		t.Errorf("expected one ioctl per gpiochip, got %d", fake.setcalls)
+	}
+	if GetStateOrPanic(g1) != false || GetStateOrPanic(g2) != true || GetStateOrPanic(g5) != true {
+		t.Fatal("EndTransactionApplySetStates did not work")
+	}
+
+	g2.SetActiveLow(true)
+	if !fake.line("gpiochip0", 2).value || GetStateOrPanic(g2) != false {
+		t.Error("SetActiveLow changed level or did not invert state")
+	}
+	if fake.line("gpiochip0", 1).flags&gpio_v2_line_flag_active_low_ > 0 {
+		t.Error("SetActiveLow affected other line")
+	}
+	if _, err := gf.(*ChardevGPIOCollectionFactory).NewChardevGPIO(2, IN); err == nil {
+		t.Error("adding a line twice should fail")
+	}
+}
+
+func Test_SysfsGPIOCollection(t *testing.T) {
+	files := map[string]string{"/sys/class/gpio/export": ""}
+	for _, number := range []int{30, 31, 60} {
+		files[fmt.Sprintf("/sys/class/gpio/gpio%d/direction", number)] = "in\n"
+		files[fmt.Sprintf("/sys/class/gpio/gpio%d/value", number)] = "0\n"
+	}
+	root := useFakeFilesystemRoot(t, files)
+	value := func(number int) string {
+		return readFakeFile(t, root, fmt.Sprintf("/sys/class/gpio/gpio%d/value", number))
+	}
+	var gf GPIOCollectionFactory = NewSysfsGPIOCollectionFactory()
+	g30 := gf.NewGPIO(30, OUT)
+	g31 := gf.NewGPIO(31, OUT)
+	g60 := gf.NewGPIO(60, OUT)
+	if direction := readFakeFile(t, root, "/sys/class/gpio/gpio31/direction"); direction != "out\n" {
+		t.Errorf("direction is %q", direction)
+	}
+	if _, err := gf.(*SysfsGPIOCollectionFactory).NewSysfsGPIO(61, OUT); err == nil {
+		t.Error("gpio61 can't appear without a kernel")
+	}
+
+	gf.BeginTransactionRecordSetStates()
+	g30.SetState(true)
+	g31.SetState(true)
+	if value(30) != "0\n" || value(31) != "0\n" {
+		t.Fatal("BeginTransactionRecordSetStates did not work")
+	}
+	if known, state, _ := g31.GetFutureState(); !known || !state {
+		t.Error("GetFutureState does not return recorded state")
+	}
+	if known, _, _ := g60.GetFutureState(); known {
+		t.Error("GetFutureState knows state that was never set")
+	}
+	gf.EndTransactionApplySetStates()
+	if value(30) != "1\n" || value(31) != "1\n" || value(60) != "0\n" {
+		t.Fatalf("EndTransactionApplySetStates did not work: %q %q %q", value(30), value(31), value(60))
+	}
+	if known, _, _ := g31.GetFutureState(); known {
+		t.Error("recorded state should be cleared when applied")
+	}
+	// outside a transaction SetState takes effect immediately
+	g60.SetState(true)
+	if value(60) != "1\n" {
+		t.Errorf("gpio60 value is %q", value(60))
+	}
+}
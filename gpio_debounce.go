@@ -0,0 +1,265 @@
+package bbhw
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Software debounce and glitch filter for any GPIO input.
+// A change of level is only reported after the input has been stable for the settle time.
+// Changes that revert within the settle time are counted as glitches.
+//
+// GetState filters on its own, by comparing the level at each call.
+// WatchEdges uses edge events of the wrapped pin if it implements GPIOEdgeWatchablePin and polls it otherwise.
+type DebouncedGPIO struct {
+	pin        GPIOControllablePin
+	settle     time.Duration
+	clock      Clock
+	stable     bool
+	candidate  bool
+	since      time.Time
+	glitches   uint64
+	lock       sync.Mutex
+	edge       int
+	edgeevents chan GPIOEdgeEvent
+	edgestop   chan struct{}
+	edgedone   chan struct{}
+	rawwatch   bool // we are watching edges of the wrapped pin
+}
+
+// without edge events, the wrapped pin is polled this many times per settle time
+const debounce_polls_per_settle_time_ = 4
+
+// Wrap an input to only report levels which have been stable for settle.
+// Pass nil as clock to use SystemClock.
+func NewDebouncedGPIO(pin GPIOControllablePin, settle time.Duration, clock Clock) (gpio *DebouncedGPIO, err error) {
+	if pin == nil {
+		panic("pin == nil")
+	}
+	if settle <= 0 {
+		return nil, fmt.Errorf("settle time must be positive")
+	}
+	gpio = &DebouncedGPIO{pin: pin, settle: settle, clock: clockOrSystemClock(clock)}
+	if gpio.stable, err = pin.GetState(); err != nil {
+		return nil, err
+	}
+	gpio.candidate = gpio.stable
+	gpio.since = gpio.clock.Now()
+	return gpio, nil
+}
+
+// Wrapper around NewDebouncedGPIO. Does not return an error but panics instead.
+func NewDebouncedGPIOOrPanic(pin GPIOControllablePin, settle time.Duration, clock Clock) (gpio *DebouncedGPIO) {
+	gpio, err := NewDebouncedGPIO(pin, settle, clock)
+	if err != nil {
+		panic(err)
+	}
+	return gpio
+}
+
+// feed the current raw level into the filter,
+// returns true if the stable level changed
+// must be called with gpio.lock held
+func (gpio *DebouncedGPIO) update(raw bool, now time.Time) (changed bool) {
+	if raw != gpio.candidate {
+		if gpio.candidate != gpio.stable {
+			// the level reverted before it settled
+			gpio.glitches++
+		}
+		gpio.candidate = raw
+		gpio.since = now
+	}
+	if gpio.candidate != gpio.stable && now.Sub(gpio.since) >= gpio.settle {
+		gpio.stable = gpio.candidate
+		if gpio.edgeevents != nil && edgeMatches(gpio.edge, gpio.stable) {
+			sendGPIOEdgeEvent(gpio.edgeevents, newGPIOEdgeEvent(gpio.stable, gpio.since))
+		}
+		return true
+	}
+	return false
+}
+
+func (gpio *DebouncedGPIO) poll() error {
+	raw, err := gpio.pin.GetState()
+	if err != nil {
+		return err
+	}
+	gpio.lock.Lock()
+	defer gpio.lock.Unlock()
+	gpio.update(raw, gpio.clock.Now())
+	return nil
+}
+
+// returns the last level that has been stable for the settle time
+func (gpio *DebouncedGPIO) GetState() (state bool, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if err = gpio.poll(); err != nil {
+		return
+	}
+	gpio.lock.Lock()
+	defer gpio.lock.Unlock()
+	return gpio.stable, nil
+}
+
+// returns the unfiltered level of the wrapped pin
+func (gpio *DebouncedGPIO) GetRawState() (bool, error) {
+	return gpio.pin.GetState()
+}
+
+// Number of level changes that reverted within the settle time
+func (gpio *DebouncedGPIO) GlitchCount() uint64 {
+	gpio.lock.Lock()
+	defer gpio.lock.Unlock()
+	return gpio.glitches
+}
+
+func (gpio *DebouncedGPIO) SettleTime() time.Duration {
+	return gpio.settle
+}
+
+func (gpio *DebouncedGPIO) CheckDirection() (int, error) {
+	return gpio.pin.CheckDirection()
+}
+
+// passed on to the wrapped pin
+func (gpio *DebouncedGPIO) SetState(state bool) error {
+	return gpio.pin.SetState(state)
+}
+
+// passed on to the wrapped pin
+func (gpio *DebouncedGPIO) SetStateNow(state bool) error {
+	return gpio.pin.SetStateNow(state)
+}
+
+// inverts the meaning of 0 and 1 of the wrapped pin, the filter state is inverted accordingly
+func (gpio *DebouncedGPIO) SetActiveLow(activelow bool) error {
+	before, err := gpio.pin.GetState()
+	if err != nil {
+		return err
+	}
+	if err = gpio.pin.SetActiveLow(activelow); err != nil {
+		return err
+	}
+	after, err := gpio.pin.GetState()
+	if err != nil {
+		return err
+	}
+	gpio.lock.Lock()
+	defer gpio.lock.Unlock()
+	if before != after {
+		gpio.stable = !gpio.stable
+		gpio.candidate = !gpio.candidate
+	}
+	return nil
+}
+
+// Returns a channel which receives debounced edges.
+// The Timestamp of an event is the time the level changed, the event is sent once it has been stable for the settle time.
+func (gpio *DebouncedGPIO) WatchEdges(edge int) (<-chan GPIOEdgeEvent, error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if edge <= EDGE_NONE || edge > EDGE_BOTH {
+		return nil, fmt.Errorf("Invalid Edge value")
+	}
+	gpio.lock.Lock()
+	defer gpio.lock.Unlock()
+	if gpio.edgeevents != nil {
+		return nil, fmt.Errorf("DebouncedGPIO is already being watched")
+	}
+	var rawevents <-chan GPIOEdgeEvent
+	if watchable, ok := gpio.pin.(GPIOEdgeWatchablePin); ok {
+		if ch, err := watchable.WatchEdges(EDGE_BOTH); err == nil {
+			rawevents = ch
+			gpio.rawwatch = true
+		}
+	}
+	gpio.edge = edge
+	gpio.edgeevents = make(chan GPIOEdgeEvent, gpio_edge_event_buffer_size_)
+	gpio.edgestop = make(chan struct{})
+	gpio.edgedone = make(chan struct{})
+	go gpio.watch(rawevents, gpio.edgeevents, gpio.edgestop, gpio.edgedone)
+	return gpio.edgeevents, nil
+}
+
+func (gpio *DebouncedGPIO) watch(rawevents <-chan GPIOEdgeEvent, events chan GPIOEdgeEvent, stop, done chan struct{}) {
+	defer close(done)
+	defer close(events)
+	for {
+		var timer ClockTimer
+		var timeout <-chan time.Time
+		gpio.lock.Lock()
+		if gpio.candidate != gpio.stable {
+			timer = gpio.clock.NewTimer(gpio.since.Add(gpio.settle).Sub(gpio.clock.Now()))
+		} else if rawevents == nil {
+			timer = gpio.clock.NewTimer(gpio.settle / debounce_polls_per_settle_time_)
+		}
+		gpio.lock.Unlock()
+		if timer != nil {
+			timeout = timer.C()
+		}
+		select {
+		case <-stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case ev, ok := <-rawevents:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				// wrapped pin stopped sending events, poll instead
+				rawevents = nil
+				continue
+			}
+			gpio.lock.Lock()
+			gpio.update(ev.Edge == EDGE_RISING, gpio.clock.Now())
+			gpio.lock.Unlock()
+		case <-timeout:
+			if rawevents == nil {
+				gpio.poll()
+				continue
+			}
+			// the edge events keep the candidate level up to date
+			gpio.lock.Lock()
+			gpio.update(gpio.candidate, gpio.clock.Now())
+			gpio.lock.Unlock()
+		}
+	}
+}
+
+func (gpio *DebouncedGPIO) StopWatchingEdges() error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.lock.Lock()
+	if gpio.edgestop == nil {
+		gpio.lock.Unlock()
+		return nil
+	}
+	// no more events once edgeevents is nil, so the watcher can close it
+	gpio.edgeevents = nil
+	gpio.edge = EDGE_NONE
+	close(gpio.edgestop)
+	done := gpio.edgedone
+	gpio.edgestop = nil
+	gpio.lock.Unlock()
+	<-done
+	gpio.lock.Lock()
+	rawwatch := gpio.rawwatch
+	gpio.rawwatch = false
+	gpio.lock.Unlock()
+	if rawwatch {
+		return gpio.pin.(GPIOEdgeWatchablePin).StopWatchingEdges()
+	}
+	return nil
+}
+
+// stops watching edges, does not close the wrapped pin
+func (gpio *DebouncedGPIO) Close() {
+	gpio.StopWatchingEdges()
+}
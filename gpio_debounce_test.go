@@ -0,0 +1,202 @@
+package bbhw
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// input without edge events which can be driven from the test while DebouncedGPIO polls it
+type lockedTestInput struct {
+	state bool
+	lock  sync.Mutex
+}
+
+func (pin *lockedTestInput) set(state bool) {
+	pin.lock.Lock()
+	defer pin.lock.Unlock()
+	pin.state = state
+}
+
+func (pin *lockedTestInput) GetState() (bool, error) {
+	pin.lock.Lock()
+	defer pin.lock.Unlock()
+	return pin.state, nil
+}
+
+func (pin *lockedTestInput) SetState(bool) error          { return nil }
+func (pin *lockedTestInput) SetStateNow(bool) error       { return nil }
+func (pin *lockedTestInput) CheckDirection() (int, error) { return IN, nil }
+func (pin *lockedTestInput) SetActiveLow(bool) error      { return nil }
+
+// waits in real time for a goroutine to get to cond
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_FakeClock(t *testing.T) {
+	start := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	c1 := clock.After(time.Second)
+	c2 := clock.After(3 * time.Second)
+	if clock.Waiters() != 2 {
+		t.Errorf("expected 2 waiters, got %d", clock.Waiters())
+	}
+	clock.Advance(time.Second)
+	select {
+	case now := <-c1:
+		if !now.Equal(start.Add(time.Second)) {
+			t.Errorf("After fired at %v", now)
+		}
+	default:
+		t.Error("After(1s) did not fire")
+	}
+	clock.Sleep(time.Second)
+	if len(c2) != 0 || clock.Waiters() != 1 {
+		t.Error("After(3s) fired too early")
+	}
+	clock.Advance(time.Hour)
+	if len(c2) != 1 || clock.Waiters() != 0 {
+		t.Error("After(3s) did not fire")
+	}
+	if !clock.Now().Equal(start.Add(time.Hour + 2*time.Second)) {
+		t.Errorf("Now is %v", clock.Now())
+	}
+	timer := clock.NewTimer(time.Second)
+	if !timer.Stop() || clock.Waiters() != 0 {
+		t.Error("Stop did not remove the timer")
+	}
+	timer = clock.NewTimer(time.Second)
+	clock.Advance(time.Second)
+	if timer.Stop() || len(timer.C()) != 1 {
+		t.Error("timer did not fire")
+	}
+}
+
+func Test_DebouncedGPIO(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	in := NewFakeGPIO(1, IN)
+	if _, err := NewDebouncedGPIO(in, 0, clock); err == nil {
+		t.Error("settle time 0 should be refused")
+	}
+	gpio := NewDebouncedGPIOOrPanic(in, 10*time.Millisecond, clock)
+	in.FakeInput(true)
+	if GetStateOrPanic(gpio) {
+		t.Error("level reported before it settled")
+	}
+	if raw, _ := gpio.GetRawState(); !raw {
+		t.Error("GetRawState should be unfiltered")
+	}
+	clock.Advance(9 * time.Millisecond)
+	if GetStateOrPanic(gpio) {
+		t.Error("level reported before it settled")
+	}
+	clock.Advance(time.Millisecond)
+	if !GetStateOrPanic(gpio) {
+		t.Error("level not reported after settle time")
+	}
+	// glitch
+	in.FakeInput(false)
+	gpio.GetState()
+	clock.Advance(5 * time.Millisecond)
+	in.FakeInput(true)
+	clock.Advance(20 * time.Millisecond)
+	if !GetStateOrPanic(gpio) {
+		t.Error("glitch was not suppressed")
+	}
+	if gpio.GlitchCount() != 1 {
+		t.Errorf("expected 1 glitch, got %d", gpio.GlitchCount())
+	}
+	// a glitch that happens between two calls of GetState can not be seen
+	in.FakeInput(false)
+	in.FakeInput(true)
+	clock.Advance(20 * time.Millisecond)
+	if !GetStateOrPanic(gpio) || gpio.GlitchCount() != 1 {
+		t.Error("unseen glitch changed state")
+	}
+}
+
+func Test_DebouncedGPIOEdges(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	in := NewFakeGPIO(1, IN)
+	gpio := NewDebouncedGPIOOrPanic(in, 10*time.Millisecond, clock)
+	events, err := gpio.WatchEdges(EDGE_BOTH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gpio.WatchEdges(EDGE_BOTH); err == nil {
+		t.Error("watching twice should fail")
+	}
+	changedat := clock.Now()
+	in.FakeInput(true)
+	waitFor(t, "settle timer", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(10 * time.Millisecond)
+	select {
+	case ev := <-events:
+		if ev.Edge != EDGE_RISING {
+			t.Error("expected rising edge")
+		}
+		if !ev.Timestamp.Equal(changedat) {
+			t.Error("event should be stamped with the time the level changed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event after settle time")
+	}
+	// glitch
+	in.FakeInput(false)
+	waitFor(t, "settle timer", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(5 * time.Millisecond)
+	in.FakeInput(true)
+	waitFor(t, "glitch", func() bool { return gpio.GlitchCount() == 1 })
+	// the settle timer is no longer needed
+	waitFor(t, "stopped settle timer", func() bool { return clock.Waiters() == 0 })
+	clock.Advance(time.Second)
+	if len(events) != 0 {
+		t.Error("glitch was not suppressed")
+	}
+	gpio.StopWatchingEdges()
+	if _, stillopen := <-events; stillopen {
+		t.Error("StopWatchingEdges did not close channel")
+	}
+	// the wrapped pin must be free again
+	if _, err := in.WatchEdges(EDGE_BOTH); err != nil {
+		t.Error(err)
+	}
+	in.StopWatchingEdges()
+}
+
+func Test_DebouncedGPIOPolling(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	in := &lockedTestInput{}
+	gpio := NewDebouncedGPIOOrPanic(in, 8*time.Millisecond, clock)
+	events, err := gpio.WatchEdges(EDGE_FALLING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gpio.Close()
+	in.set(true)
+	// the first poll sees the change, after another 8ms it has settled
+	for i := 0; i < 3; i++ {
+		waitFor(t, "poll timer", func() bool { return clock.Waiters() > 0 })
+		clock.Advance(4 * time.Millisecond)
+	}
+	waitFor(t, "rising level", func() bool { gpio.lock.Lock(); defer gpio.lock.Unlock(); return gpio.stable })
+	if len(events) != 0 {
+		t.Error("rising edge should have been filtered")
+	}
+	in.set(false)
+	for len(events) == 0 {
+		waitFor(t, "poll timer", func() bool { return clock.Waiters() > 0 || len(events) > 0 })
+		clock.Advance(2 * time.Millisecond)
+	}
+	if ev := <-events; ev.Edge != EDGE_FALLING {
+		t.Error("expected falling edge")
+	}
+}
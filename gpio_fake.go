@@ -5,6 +5,8 @@ package bbhw
 import (
 	"fmt"
 	"log"
+	"sync"
+	"time"
 )
 
 // Use FakeGPIO for testing and debugging.
@@ -16,6 +18,9 @@ type FakeGPIO struct {
 	activelow   bool
 	logTarget   *log.Logger
 	connectedTo []*FakeGPIO
+	edge        int
+	edgeevents  chan GPIOEdgeEvent
+	edgelock    sync.Mutex
 }
 
 type FakeGPIONullWriter struct{}
@@ -97,6 +102,7 @@ func (gpio *FakeGPIO) SetActiveLow(activelow bool) error {
 func (gpio *FakeGPIO) SetStateNow(state bool) error { return gpio.SetState(state) }
 
 func (gpio *FakeGPIO) Close() {
+	gpio.StopWatchingEdges()
 	gpio = nil
 }
 
@@ -125,13 +131,60 @@ func (gpio *FakeGPIO) FakeInput(state bool) error {
 	}
 	if gpio.dir == IN {
 		gpio.log("faking input >%+v<", state)
+		changed := gpio.value != state
 		gpio.value = state
+		if changed {
+			gpio.emitEdgeEvent()
+		}
 	} else {
 		panic("tried to fake input for output gpio")
 	}
 	return nil
 }
 
+func (gpio *FakeGPIO) emitEdgeEvent() {
+	gpio.edgelock.Lock()
+	defer gpio.edgelock.Unlock()
+	rising := gpio.activelow != gpio.value
+	if gpio.edgeevents != nil && edgeMatches(gpio.edge, rising) {
+		sendGPIOEdgeEvent(gpio.edgeevents, newGPIOEdgeEvent(rising, time.Now()))
+	}
+}
+
+// Returns a channel of events generated whenever FakeInput or a connected output changes the state of the gpio.
+// Events are dropped if the channel is not read.
+func (gpio *FakeGPIO) WatchEdges(edge int) (<-chan GPIOEdgeEvent, error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if edge <= EDGE_NONE || edge > EDGE_BOTH {
+		return nil, fmt.Errorf("Invalid Edge value")
+	}
+	gpio.edgelock.Lock()
+	defer gpio.edgelock.Unlock()
+	if gpio.edgeevents != nil {
+		return nil, fmt.Errorf("%s is already being watched", gpio.name)
+	}
+	gpio.edge = edge
+	gpio.edgeevents = make(chan GPIOEdgeEvent, gpio_edge_event_buffer_size_)
+	gpio.log("watching edges")
+	return gpio.edgeevents, nil
+}
+
+func (gpio *FakeGPIO) StopWatchingEdges() error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.edgelock.Lock()
+	defer gpio.edgelock.Unlock()
+	if gpio.edgeevents != nil {
+		close(gpio.edgeevents)
+		gpio.edgeevents = nil
+	}
+	gpio.edge = EDGE_NONE
+	return nil
+}
+
 func (gpio *FakeGPIO) log(fmt string, attr ...interface{}) {
 	logT := gpio.logTarget
 	if logT == nil {
@@ -2,61 +2,104 @@
 
 package bbhw
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Uses the memory mapped IO to directly interface with AM335x registers.
 // Toggles GPIOs about 800 times faster than SysFS.
+// Also works on AM57xx and Raspberry Pi (BCM2835 and successors), see mmap_soc.go
 type MMappedGPIO struct {
 	chipid    int
 	gpioid    uint
 	activelow bool
+	mmapreg   *mappedRegisters
 }
 
 /// Fast MemoryMapped GPIO Stuff -----------------------------------------
 
 // Instantinate a new and fast GPIO controlled using direct access to AM335x registers.
 // Takes GPIO numer (same as in sysfs) and direction bbhw.IN or bbhw.OUT
-// Only works on AM335x and address compatible SoCs
+// Only works on the SoCs described in mmap_soc.go
 //
 // See http://kilobaser.com/blog/2014-07-15-beaglebone-black-gpios#1gpiopin regarding the numbering of GPIO pins.
-func NewMMappedGPIO(number uint, direction int) (gpio *MMappedGPIO) {
+func NewMMappedGPIO(number uint, direction int) (gpio *MMappedGPIO, err error) {
+	var mmapreg *mappedRegisters
+	if mmapreg, err = getgpiommap(); err != nil {
+		return nil, err
+	}
 	//Set direction and export GPIO via sysfs
-	NewSysfsGPIOOrPanic(number, direction).Close()
-	gpio = new(MMappedGPIO)
-
+	if err = mmapreg.prepareGPIO(number, direction); err != nil {
+		return nil, err
+	}
+	gpio = &MMappedGPIO{mmapreg: mmapreg}
 	gpio.chipid, gpio.gpioid = calcGPIOAddrFromLinuxGPIONum(number)
+	return gpio, nil
+}
+
+// Wrapper around NewMMappedGPIO. Does not return an error but panics instead.
+func NewMMappedGPIOOrPanic(number uint, direction int) (gpio *MMappedGPIO) {
+	gpio, err := NewMMappedGPIO(number, direction)
+	if err != nil {
+		panic(err)
+	}
 	return gpio
 }
 
 func (gpio *MMappedGPIO) CheckDirection() (direction int, err error) {
-	mmapreg := getgpiommap()
-	input_enabled := mmapreg.memgpiochipreg[gpio.chipid][intgpio_output_enabled_+(gpio.gpioid/8)]&(1<<(gpio.gpioid%8)) > 0
-
-	//TODO: check pinmux_controlmodule_offset_ ddr_data0_ioctrl Register (offset = 1440h) and ddr_data1_ioctrl Register for pullup/pulldown
-	//TODO: or check bits in mux mask to return IN_PULLUP IN_PULLDOWN as well
+	mmapreg := gpio.mmapreg
+	input_enabled := mmapreg.soc.isInput(mmapreg, gpio.chipid, gpio.gpioid)
 
-	if input_enabled {
-		return IN, nil
-	} else {
+	if !input_enabled {
 		return OUT, nil
 	}
+	// the pull resistor is configured in the pad conf register of the control module
+	if !mmapreg.soc.pinmux || mmapreg.fake {
+		return IN, nil
+	}
+	if offset, err := findPadOffsetByGPIO(gpio.number()); err == nil {
+		if conf, err := getPadConfAtOffset(offset); err == nil {
+			switch conf.Pull {
+			case BIAS_PULLUP:
+				return IN_PULLUP, nil
+			case BIAS_PULLDOWN:
+				return IN_PULLDOWN, nil
+			}
+		}
+	}
+	return IN, nil
+}
+
+func (gpio *MMappedGPIO) number() uint {
+	return uint(gpio.chipid)*32 + gpio.gpioid
 }
 
+// Enables or disables the hardware debouncing of an input.
+// Uses the debounce time of the gpio bank, see SetDebounceTime
 func (gpio *MMappedGPIO) SetDebounce(enable_debounce bool) error {
-	mmapreg := getgpiommap()
-	if dir, err := gpio.CheckDirection(); dir != IN || err != nil {
+	if dir, err := gpio.CheckDirection(); dir == OUT || err != nil {
 		return fmt.Errorf("GPIO %+v is not configured as Input, setting debounce won't have an effect", gpio)
 	}
-	mmapreg.reglock.Lock()
-	debounce_state := mmapreg.memgpiochipreg[gpio.chipid][intgpio_debounceenable_+(gpio.gpioid/8)]
-	if enable_debounce {
-		debounce_state |= byte(gpio.gpioid % 8)
-	} else {
-		debounce_state &= byte(^(gpio.gpioid % 8))
+	return gpio.mmapreg.setDebounce(gpio.chipid, gpio.gpioid, enable_debounce)
+}
+
+// Sets the debounce time and enables debouncing of an input.
+//
+// The AM335x has only one debounce time for all 32 gpios of a bank,
+// given in multiples of 31µs from 31µs to 7.936ms.
+// Returns an error if another input of the same bank set a different debounce time and still debounces.
+// Call SetDebounce(false) on that input first.
+func (gpio *MMappedGPIO) SetDebounceTime(debounce time.Duration) error {
+	if dir, err := gpio.CheckDirection(); dir == OUT || err != nil {
+		return fmt.Errorf("GPIO %+v is not configured as Input, setting debounce won't have an effect", gpio)
 	}
-	mmapreg.memgpiochipreg[gpio.chipid][intgpio_debounceenable_+(gpio.gpioid/8)] = debounce_state
-	mmapreg.reglock.Unlock()
-	return nil
+	return gpio.mmapreg.setDebounceTime(gpio.chipid, gpio.gpioid, debounce)
+}
+
+// Returns the debounce time of the gpio bank of this gpio, rounded to 31µs
+func (gpio *MMappedGPIO) GetDebounceTime() (time.Duration, error) {
+	return gpio.mmapreg.getDebounceTime(gpio.chipid)
 }
 
 // This should be about 800 times faster than SysFS GPIOs SetState
@@ -65,11 +108,11 @@ func (gpio *MMappedGPIO) SetDebounce(enable_debounce bool) error {
 // a DeviceTreeOverlay for that pin has been loaded (even after you have removed the Overlay)
 // in this case: reboot
 func (gpio *MMappedGPIO) SetState(state bool) error {
-	mmapreg := getgpiommap()
+	mmapreg := gpio.mmapreg
 	if state != gpio.activelow {
-		mmapreg.memgpiochipreg[gpio.chipid][intgpio_setdataout_+(gpio.gpioid/8)] = 1 << (gpio.gpioid % 8)
+		mmapreg.write32(gpio.chipid, mmapreg.soc.setdataout, 1<<gpio.gpioid)
 	} else {
-		mmapreg.memgpiochipreg[gpio.chipid][intgpio_cleardataout_+(gpio.gpioid/8)] = 1 << (gpio.gpioid % 8)
+		mmapreg.write32(gpio.chipid, mmapreg.soc.cleardataout, 1<<gpio.gpioid)
 	}
 
 	//sync / flush memory
@@ -100,14 +143,14 @@ func (gpio *MMappedGPIO) SetActiveLow(activelow bool) error {
 // note that SetActiveLow inverts return value
 // internal note: in contrast to SysFS we need to query two different registers depending on the pin direction
 func (gpio *MMappedGPIO) GetState() (state bool, err error) {
-	mmapreg := getgpiommap()
+	mmapreg := gpio.mmapreg
 	var register uint
-	if mmapreg.memgpiochipreg[gpio.chipid][intgpio_output_enabled_+(gpio.gpioid/8)]&(1<<(gpio.gpioid%8)) > 0 {
-		register = intgpio_datain_ // if DIRECTION==IN
+	if mmapreg.soc.isInput(mmapreg, gpio.chipid, gpio.gpioid) {
+		register = mmapreg.soc.datain // if DIRECTION==IN
 	} else {
-		register = intgpio_dataout_ // if DIRECTION==OUT
+		register = mmapreg.soc.dataout // if DIRECTION==OUT
 	}
-	state = gpio.activelow != (mmapreg.memgpiochipreg[gpio.chipid][register+(gpio.gpioid/8)]&(1<<(gpio.gpioid%8)) > 0)
+	state = gpio.activelow != (mmapreg.read32(gpio.chipid, register)&(1<<gpio.gpioid) > 0)
 	return
 }
 
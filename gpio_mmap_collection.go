@@ -14,7 +14,8 @@ type MMappedGPIOInCollection struct {
 // Collection of GPIOs. Records SetState() calls after BeginTransactionRecordSetStates() has been called and delays their effect until EndTransactionApplySetStates() is called.
 // Use it to toggle many GPIOs in the very same instant.
 type MMappedGPIOCollectionFactory struct {
-	//4 32bit arrays to be copied to register
+	mmapreg *mappedRegisters
+	//one 32bit array per gpio bank to be copied to register
 	gpios_to_set   []uint32
 	gpios_to_clear []uint32
 	record_changes bool
@@ -25,31 +26,43 @@ type MMappedGPIOCollectionFactory struct {
 
 // Create a collection of GPIOs.
 // Doubles as factory for the MMappedGPIOInCollection type.
-func NewMMappedGPIOCollectionFactory() (gpiocf *MMappedGPIOCollectionFactory) {
-	mmapreg := getgpiommap()
-	gpiocf = new(MMappedGPIOCollectionFactory)
+func NewMMappedGPIOCollectionFactory() (gpiocf *MMappedGPIOCollectionFactory, err error) {
+	var mmapreg *mappedRegisters
+	if mmapreg, err = getgpiommap(); err != nil {
+		return nil, err
+	}
+	gpiocf = &MMappedGPIOCollectionFactory{mmapreg: mmapreg}
 	gpiocf.gpios_to_set = make([]uint32, len(mmapreg.memgpiochipreg32))
 	gpiocf.gpios_to_clear = make([]uint32, len(mmapreg.memgpiochipreg32))
+	return gpiocf, nil
+}
+
+// Wrapper around NewMMappedGPIOCollectionFactory. Does not return an error but panics instead.
+func NewMMappedGPIOCollectionFactoryOrPanic() (gpiocf *MMappedGPIOCollectionFactory) {
+	gpiocf, err := NewMMappedGPIOCollectionFactory()
+	if err != nil {
+		panic(err)
+	}
 	return gpiocf
 }
 
 // Quickly and concurrently (i.e. faster than GPIOChips can react) applies new state
 // by writing a 32 bit value to the corresponding CLEARDATA and SETDATA registers for each
-// of the four gpio chips.
+// of the gpio chips.
 //
 // Note that at LEAST one gpio for each gpiochip has to be exported in sysfs
 // in order to active the corresponding gpiochip
 // otherwise clocksource of gpiochip remains gated and we hang on receiving SIGBUS immediately after trying to write that register
 // see: https://groups.google.com/forum/#!msg/beagleboard/OYFp4EXawiI/Mq6s3sg14HoJ
 func (gpiocf *MMappedGPIOCollectionFactory) EndTransactionApplySetStates() {
-	mmapreg := getgpiommap()
+	mmapreg := gpiocf.mmapreg
 	gpiocf.lock.Lock()
 	defer gpiocf.lock.Unlock()
 	for i, _ := range gpiocf.gpios_to_clear {
 		if gpiocf.gpios_to_set[i] > 0 || gpiocf.gpios_to_clear[i] > 0 {
 			// only set registers which are known to be enabled (i.e. have been set by our code thus have had NewMMappedGPIO called, thus have been exported in sysfs and thus are provided with a clk by the CPU/Linux)
-			mmapreg.memgpiochipreg32[i][intgpio_cleardataout_o32_] = gpiocf.gpios_to_clear[i]
-			mmapreg.memgpiochipreg32[i][intgpio_setdataout_o32_] = gpiocf.gpios_to_set[i]
+			mmapreg.write32(i, mmapreg.soc.cleardataout, gpiocf.gpios_to_clear[i])
+			mmapreg.write32(i, mmapreg.soc.setdataout, gpiocf.gpios_to_set[i])
 		}
 		gpiocf.gpios_to_set[i] = 0
 		gpiocf.gpios_to_clear[i] = 0
@@ -65,16 +78,28 @@ func (gpiocf *MMappedGPIOCollectionFactory) BeginTransactionRecordSetStates() {
 }
 
 // Same as NewMMappedGPIO but part of a MMappedGPIOCollectionFactory
-func (gpiocf *MMappedGPIOCollectionFactory) NewMMappedGPIO(number uint, direction int) (gpio *MMappedGPIOInCollection) {
-	NewSysfsGPIOOrPanic(number, direction).Close()
+func (gpiocf *MMappedGPIOCollectionFactory) NewMMappedGPIO(number uint, direction int) (gpio *MMappedGPIOInCollection, err error) {
+	if err = gpiocf.mmapreg.prepareGPIO(number, direction); err != nil {
+		return nil, err
+	}
 	gpio = new(MMappedGPIOInCollection)
+	gpio.mmapreg = gpiocf.mmapreg
 	gpio.chipid, gpio.gpioid = calcGPIOAddrFromLinuxGPIONum(number)
 	gpio.collection = gpiocf
+	return gpio, nil
+}
+
+// Wrapper around NewMMappedGPIO. Does not return an error but panics instead.
+func (gpiocf *MMappedGPIOCollectionFactory) NewMMappedGPIOOrPanic(number uint, direction int) (gpio *MMappedGPIOInCollection) {
+	gpio, err := gpiocf.NewMMappedGPIO(number, direction)
+	if err != nil {
+		panic(err)
+	}
 	return gpio
 }
 
 func (gpiocf *MMappedGPIOCollectionFactory) NewGPIO(number uint, direction int) GPIOControllablePinInCollection {
-	return gpiocf.NewMMappedGPIO(number, direction)
+	return gpiocf.NewMMappedGPIOOrPanic(number, direction)
 }
 
 /// ------------- MMappedGPIOInCollection Methods -------------------
@@ -10,8 +10,8 @@ func Test_MethodOverriding(t *testing.T) {
 		t.Logf("test only works on BeagleBone")
 		return
 	}
-	gf := NewMMappedGPIOCollectionFactory()
-	g := gf.NewMMappedGPIO(67, OUT)
+	gf := NewMMappedGPIOCollectionFactoryOrPanic()
+	g := gf.NewMMappedGPIOOrPanic(67, OUT)
 	g.SetStateNow(false)
 	if GetStateOrPanic(g) != false {
 		t.Fatal("real state is not false")
@@ -53,9 +53,9 @@ func Test_MethodOverriding(t *testing.T) {
 func checkSysfsVersusMMapGPIOFromCollection(gpionum uint, t *testing.T) {
 	chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(gpionum)
 	t.Logf("Testing sysfs:gpio/gpio%d chip:gpio%d[%d]", gpionum, chipid, gpioid)
-	fg := NewMMappedGPIO(gpionum, OUT)
-	gf := NewMMappedGPIOCollectionFactory()
-	sg := gf.NewMMappedGPIO(gpionum, OUT)
+	fg := NewMMappedGPIOOrPanic(gpionum, OUT)
+	gf := NewMMappedGPIOCollectionFactoryOrPanic()
+	sg := gf.NewMMappedGPIOOrPanic(gpionum, OUT)
 
 	defer sg.Close()
 	defer fg.Close()
@@ -133,7 +133,7 @@ func Test_MMapGpioFromCollectionVersusSysfsGPIO(t *testing.T) {
 		t.Logf("test only works on BeagleBone")
 		return
 	}
-	// fg := NewMMappedGPIO(67, OUT)
+	// fg := NewMMappedGPIOOrPanic(67, OUT)
 	// sg := NewSysfsGPIOOrPanic(67, OUT)
 	checkSysfsVersusMMapGPIOFromCollection(2, t)
 	checkSysfsVersusMMapGPIOFromCollection(3, t)
@@ -149,17 +149,49 @@ func Test_MMapGpioFromCollectionVersusSysfsGPIO(t *testing.T) {
 	checkSysfsVersusMMapGPIOFromCollection(117, t)
 }
 
+func Test_MMapGpioFromCollectionFakeRegisters(t *testing.T) {
+	for _, socname := range []string{"AM335x", "BCM2835"} {
+		mmapreg := useFakeGPIORegisters(t, socname)
+		for _, gpionum := range []uint{2, 5, 50, 51} {
+			checkSysfsVersusMMapGPIOFromCollection(gpionum, t)
+		}
+
+		gf := NewMMappedGPIOCollectionFactoryOrPanic()
+		g1 := gf.NewMMappedGPIOOrPanic(3, OUT)
+		g2 := gf.NewMMappedGPIOOrPanic(40, OUT)
+		g1.SetState(true)
+		gf.BeginTransactionRecordSetStates()
+		g1.SetState(false)
+		g2.SetState(true)
+		if GetStateOrPanic(g1) != true || GetStateOrPanic(g2) != false {
+			t.Errorf("%s: states changed before transaction was applied", socname)
+		}
+		gf.EndTransactionApplySetStates()
+		if GetStateOrPanic(g1) != false || GetStateOrPanic(g2) != true {
+			t.Errorf("%s: transaction was not applied", socname)
+		}
+		if mmapreg.read32(1, mmapreg.soc.dataout)&(1<<8) == 0 {
+			t.Errorf("%s: DATAOUT of bank 1 does not reflect GPIO 40", socname)
+		}
+		// keeps the logical state and thus inverts the physical output
+		g2.SetActiveLow(true)
+		if GetStateOrPanic(g2) != true || mmapreg.read32(1, mmapreg.soc.dataout)&(1<<8) != 0 {
+			t.Errorf("%s: SetActiveLow did not invert the output", socname)
+		}
+	}
+}
+
 func Test_checkGPIO2Chip(t *testing.T) {
 	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
 		t.Logf("test only works on BeagleBone")
 		return
 	}
-	gf := NewMMappedGPIOCollectionFactory()
+	gf := NewMMappedGPIOCollectionFactoryOrPanic()
 	g := make([]*MMappedGPIOInCollection, 32)
 	for gpionum := uint(32) * 2; gpionum < 32*3; gpionum++ {
 		chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(gpionum)
 		t.Logf("Creating gpio/gpio%d chip:gpio%d[%d]", gpionum, chipid, gpioid)
-		g[gpionum%32] = gf.NewMMappedGPIO(gpionum, OUT)
+		g[gpionum%32] = gf.NewMMappedGPIOOrPanic(gpionum, OUT)
 	}
 	for i, gpio := range g {
 		t.Logf("Set gpio2[%d==%d] to true", i, gpio.gpioid)
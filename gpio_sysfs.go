@@ -6,13 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"syscall"
+	"time"
 )
 
 // Uses the /sys/class/gpio/**/* file-interface provided by the linux kernel.
 // Slightly slower than mmapped implementations but will work on any linux system with GPIOs.
 type SysfsGPIO struct {
-	Number uint
-	fd     *os.File
+	Number   uint
+	fd       *os.File
+	edgestop *os.File
+	edgedone chan struct{}
 }
 
 // SysFS managed GPIO ------------------------------------
@@ -32,7 +36,7 @@ func NewSysfsGPIO(number uint, direction int) (gpio *SysfsGPIO, err error) {
 		return nil, err
 	}
 	//check if file really exists and open for OUT
-	gpio.fd, err = os.OpenFile(fmt.Sprintf("/sys/class/gpio/gpio%d/value", gpio.Number), os.O_RDWR|os.O_SYNC, 0666)
+	gpio.fd, err = os.OpenFile(rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d/value", gpio.Number)), os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -66,7 +70,7 @@ func (gpio *SysfsGPIO) enable_export() error {
 	if gpio == nil {
 		panic("gpio == nil")
 	}
-	_, err := os.Stat(fmt.Sprintf("/sys/class/gpio/gpio%d", gpio.Number))
+	_, err := os.Stat(rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d", gpio.Number)))
 	if err == nil {
 		// already exported
 		return nil
@@ -74,10 +78,11 @@ func (gpio *SysfsGPIO) enable_export() error {
 		// some other error
 		return err
 	}
-	fd, err := os.OpenFile("/sys/class/gpio/export", os.O_WRONLY|os.O_SYNC, 0666)
+	fd, err := os.OpenFile(rootedPath("/sys/class/gpio/export"), os.O_WRONLY|os.O_SYNC, 0666)
 	if err != nil {
 		return err
 	}
+	defer fd.Close()
 	_, err = fmt.Fprintf(fd, "%d\n", gpio.Number)
 	return err
 }
@@ -90,7 +95,7 @@ func (gpio *SysfsGPIO) CheckDirection() (direction int, err error) {
 	if gpio == nil {
 		panic("gpio == nil")
 	}
-	filename := fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.Number)
+	filename := rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.Number))
 	df, err = os.OpenFile(filename, os.O_RDONLY|os.O_SYNC, 0666)
 	if err != nil {
 		return
@@ -124,7 +129,7 @@ func (gpio *SysfsGPIO) SetDirection(direction int) error {
 	if gpio == nil {
 		panic("gpio == nil")
 	}
-	df, err := os.OpenFile(fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.Number),
+	df, err := os.OpenFile(rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d/direction", gpio.Number)),
 		os.O_WRONLY|os.O_SYNC, 0666)
 	if err != nil {
 		return err
@@ -150,7 +155,7 @@ func (gpio *SysfsGPIO) SetActiveLow(activelow bool) error {
 	if gpio == nil {
 		panic("gpio == nil")
 	}
-	df, err := os.OpenFile(fmt.Sprintf("/sys/class/gpio/gpio%d/active_low", gpio.Number),
+	df, err := os.OpenFile(rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d/active_low", gpio.Number)),
 		os.O_WRONLY|os.O_SYNC, 0666)
 	if err != nil {
 		return err
@@ -209,9 +214,130 @@ func (gpio *SysfsGPIO) SetState(state bool) error {
 
 func (gpio *SysfsGPIO) SetStateNow(state bool) error { return gpio.SetState(state) }
 
+func (gpio *SysfsGPIO) setEdge(edge int) error {
+	df, err := os.OpenFile(rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d/edge", gpio.Number)),
+		os.O_WRONLY|os.O_SYNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer df.Close()
+	switch edge {
+	case EDGE_NONE:
+		_, err = fmt.Fprintln(df, "none")
+	case EDGE_RISING:
+		_, err = fmt.Fprintln(df, "rising")
+	case EDGE_FALLING:
+		_, err = fmt.Fprintln(df, "falling")
+	case EDGE_BOTH:
+		_, err = fmt.Fprintln(df, "both")
+	default:
+		return fmt.Errorf("Invalid Edge value")
+	}
+	return err
+}
+
+// Configures /sys/class/gpio/gpio*/edge and waits for POLLPRI on the value file in a goroutine.
+// Returns a channel of events which is closed by StopWatchingEdges.
+// The gpio has to be an input.
+func (gpio *SysfsGPIO) WatchEdges(edge int) (<-chan GPIOEdgeEvent, error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if gpio.edgedone != nil {
+		return nil, fmt.Errorf("gpio %d is already being watched", gpio.Number)
+	}
+	if edge == EDGE_NONE {
+		return nil, fmt.Errorf("Invalid Edge value")
+	}
+	if err := gpio.setEdge(edge); err != nil {
+		return nil, err
+	}
+	valuefd, err := os.OpenFile(rootedPath(fmt.Sprintf("/sys/class/gpio/gpio%d/value", gpio.Number)), os.O_RDONLY, 0666)
+	if err != nil {
+		return nil, err
+	}
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		valuefd.Close()
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	var stoppipe [2]int
+	if err = syscall.Pipe2(stoppipe[:], syscall.O_CLOEXEC); err != nil {
+		valuefd.Close()
+		syscall.Close(epfd)
+		return nil, os.NewSyscallError("pipe2", err)
+	}
+	valueev := syscall.EpollEvent{Events: syscall.EPOLLPRI | syscall.EPOLLERR, Fd: int32(valuefd.Fd())}
+	stopev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(stoppipe[0])}
+	if err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, int(valuefd.Fd()), &valueev); err == nil {
+		err = syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, stoppipe[0], &stopev)
+	}
+	if err != nil {
+		valuefd.Close()
+		syscall.Close(epfd)
+		syscall.Close(stoppipe[0])
+		syscall.Close(stoppipe[1])
+		return nil, os.NewSyscallError("epoll_ctl", err)
+	}
+	// sysfs always signals a freshly opened value file, consume that
+	buf := make([]byte, 16)
+	evs := make([]syscall.EpollEvent, 2)
+	syscall.EpollWait(epfd, evs, 0)
+	syscall.Pread(int(valuefd.Fd()), buf, 0)
+
+	events := make(chan GPIOEdgeEvent, gpio_edge_event_buffer_size_)
+	gpio.edgestop = os.NewFile(uintptr(stoppipe[1]), "edgestop")
+	gpio.edgedone = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		defer close(events)
+		defer syscall.Close(stoppipe[0])
+		defer syscall.Close(epfd)
+		defer valuefd.Close()
+		for {
+			n, err := syscall.EpollWait(epfd, evs, -1)
+			if err == syscall.EINTR {
+				continue
+			} else if err != nil {
+				return
+			}
+			ts := time.Now()
+			for i := 0; i < n; i++ {
+				if evs[i].Fd == int32(stoppipe[0]) {
+					return
+				}
+			}
+			if numread, err := syscall.Pread(int(valuefd.Fd()), buf, 0); err != nil || numread < 1 {
+				continue
+			}
+			// watching a single edge, the level might already have changed back
+			rising := edge == EDGE_RISING || (edge == EDGE_BOTH && buf[0] == '1')
+			sendGPIOEdgeEvent(events, newGPIOEdgeEvent(rising, ts))
+		}
+	}(gpio.edgedone)
+	return events, nil
+}
+
+// Stops the goroutine started by WatchEdges, closes its channel and resets edge to none
+func (gpio *SysfsGPIO) StopWatchingEdges() error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if gpio.edgedone == nil {
+		return nil
+	}
+	gpio.edgestop.Write([]byte{0})
+	<-gpio.edgedone
+	gpio.edgestop.Close()
+	gpio.edgestop = nil
+	gpio.edgedone = nil
+	return gpio.setEdge(EDGE_NONE)
+}
+
 //closes filedescriptor
 //does NOT unexport gpio, since gpio_mmap_collection and gpio_mmap depend on the gpio remaining exported and the gpiobank activated
 func (gpio *SysfsGPIO) Close() {
+	gpio.StopWatchingEdges()
 	gpio.fd.Close()
 	gpio = nil
 }
@@ -0,0 +1,116 @@
+package bbhw
+
+import "sync"
+
+// Same as SysfsGPIO, but part of a collection of GPIOs you can set all at once using database-like transactions.
+// Works on any linux system with /sys/class/gpio, but since every gpio has its own value file,
+// the recorded states are applied one after another and not in the very same instant.
+type SysfsGPIOInCollection struct {
+	SysfsGPIO
+	futureEnable bool
+	futureState  bool
+	collection   *SysfsGPIOCollectionFactory
+}
+
+// Collection of GPIOs. Records SetState() calls after BeginTransactionRecordSetStates() has been called and delays their effect until EndTransactionApplySetStates() is called.
+type SysfsGPIOCollectionFactory struct {
+	collection     []*SysfsGPIOInCollection
+	record_changes bool
+	lock           sync.Mutex
+}
+
+/// ---------- SysfsGPIOCollectionFactory ---------------
+
+// Create a collection of GPIOs.
+// Doubles as factory for the SysfsGPIOInCollection type.
+func NewSysfsGPIOCollectionFactory() (gpiocf *SysfsGPIOCollectionFactory) {
+	gpiocf = new(SysfsGPIOCollectionFactory)
+	gpiocf.collection = make([]*SysfsGPIOInCollection, 0)
+	return gpiocf
+}
+
+// Apply States recorded with BeginTransactionRecordSetStates
+func (gpiocf *SysfsGPIOCollectionFactory) EndTransactionApplySetStates() {
+	gpiocf.lock.Lock()
+	defer gpiocf.lock.Unlock()
+	for _, gpio := range gpiocf.collection {
+		if gpio.futureEnable {
+			gpio.SetStateNow(gpio.futureState)
+		}
+		gpio.futureEnable = false
+	}
+	gpiocf.record_changes = false
+}
+
+// Begin recording calls to SetState for later
+func (gpiocf *SysfsGPIOCollectionFactory) BeginTransactionRecordSetStates() {
+	gpiocf.lock.Lock()
+	defer gpiocf.lock.Unlock()
+	gpiocf.record_changes = true
+}
+
+// Same as NewSysfsGPIO but part of a SysfsGPIOCollectionFactory
+func (gpiocf *SysfsGPIOCollectionFactory) NewSysfsGPIO(number uint, direction int) (gpio *SysfsGPIOInCollection, err error) {
+	var sg *SysfsGPIO
+	if sg, err = NewSysfsGPIO(number, direction); err != nil {
+		return nil, err
+	}
+	gpio = &SysfsGPIOInCollection{SysfsGPIO: *sg, collection: gpiocf}
+	gpiocf.lock.Lock()
+	gpiocf.collection = append(gpiocf.collection, gpio)
+	gpiocf.lock.Unlock()
+	return gpio, nil
+}
+
+// Wrapper around NewSysfsGPIO. Does not return an error but panics instead.
+func (gpiocf *SysfsGPIOCollectionFactory) NewSysfsGPIOOrPanic(number uint, direction int) (gpio *SysfsGPIOInCollection) {
+	gpio, err := gpiocf.NewSysfsGPIO(number, direction)
+	if err != nil {
+		panic(err)
+	}
+	return gpio
+}
+
+func (gpiocf *SysfsGPIOCollectionFactory) NewGPIO(number uint, direction int) GPIOControllablePinInCollection {
+	return gpiocf.NewSysfsGPIOOrPanic(number, direction)
+}
+
+/// ------------- SysfsGPIOInCollection Methods -------------------
+
+func (gpio *SysfsGPIOInCollection) SetStateNow(state bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	return gpio.SysfsGPIO.SetState(state)
+}
+
+func (gpio *SysfsGPIOInCollection) SetFutureState(state bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	gpio.futureEnable = true
+	gpio.futureState = state
+	return nil
+}
+
+func (gpio *SysfsGPIOInCollection) GetFutureState() (state_known, state bool, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	gpio.collection.lock.Lock()
+	defer gpio.collection.lock.Unlock()
+	return gpio.futureEnable, gpio.futureState, nil
+}
+
+func (gpio *SysfsGPIOInCollection) SetState(state bool) error {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if gpio.collection.record_changes {
+		return gpio.SetFutureState(state)
+	} else {
+		return gpio.SetStateNow(state)
+	}
+}
@@ -12,7 +12,7 @@ import (
 func checkSysfsVersusMMapGPIO(gpionum uint, t *testing.T) {
 	chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(gpionum)
 	t.Logf("Testing sysfs:gpio/gpio%d chip:gpio%d[%d]", gpionum, chipid, gpioid)
-	fg := NewMMappedGPIO(gpionum, OUT)
+	fg := NewMMappedGPIOOrPanic(gpionum, OUT)
 	sg := NewSysfsGPIOOrPanic(gpionum, OUT)
 	defer sg.Close()
 	defer fg.Close()
@@ -79,7 +79,7 @@ func Test_FastGPIO(t *testing.T) {
 		t.Logf("test only works on BeagleBone")
 		return
 	}
-	// fg := NewMMappedGPIO(67, OUT)
+	// fg := NewMMappedGPIOOrPanic(67, OUT)
 	// sg := NewSysfsGPIOOrPanic(67, OUT)
 	checkSysfsVersusMMapGPIO(2, t)
 	checkSysfsVersusMMapGPIO(3, t)
@@ -98,7 +98,7 @@ func Test_FastGPIO(t *testing.T) {
 func checkSysfsVersusActiveLowMMapGPIO(gpionum uint, t *testing.T) {
 	chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(gpionum)
 	t.Logf("Testing sysfs:gpio/gpio%d chip:gpio%d[%d]", gpionum, chipid, gpioid)
-	fg := NewMMappedGPIO(gpionum, OUT)
+	fg := NewMMappedGPIOOrPanic(gpionum, OUT)
 	sg := NewSysfsGPIOOrPanic(gpionum, OUT)
 	defer sg.Close()
 	defer fg.Close()
@@ -164,13 +164,93 @@ func Test_SysfsGPIOwCable(t *testing.T) {
 	//Step(outg, 20, time.Duration(200)*time.Millisecond, nil)
 }
 
+func Test_SysfsGPIOwCableEdges(t *testing.T) {
+	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
+		t.Logf("test only works on BeagleBone")
+		return
+	}
+	outg := NewSysfsGPIOOrPanic(67, OUT) //P8_8
+	ing := NewSysfsGPIOOrPanic(66, IN)   //P8_7
+	defer outg.Close()
+	defer ing.Close()
+	outg.SetState(false)
+	events, err := ing.WatchEdges(EDGE_BOTH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outg.SetState(true)
+	select {
+	case ev := <-events:
+		if ev.Edge != EDGE_RISING {
+			t.Error("1: expected rising edge")
+		}
+	case <-time.After(time.Second):
+		fmt.Println("For this test, please connect Pin P8_7 to P8_8")
+		t.Error("1: no edge event")
+	}
+	outg.SetState(false)
+	select {
+	case ev := <-events:
+		if ev.Edge != EDGE_FALLING {
+			t.Error("2: expected falling edge")
+		}
+	case <-time.After(time.Second):
+		t.Error("2: no edge event")
+	}
+	ing.StopWatchingEdges()
+	if _, stillopen := <-events; stillopen {
+		t.Error("StopWatchingEdges did not close channel")
+	}
+}
+
+func Test_PadConf(t *testing.T) {
+	for _, conf := range []PadConf{
+		{MuxMode: PAD_MUXMODE_GPIO, ReceiverEnable: true, Pull: BIAS_PULLUP},
+		{MuxMode: 0, ReceiverEnable: false, Pull: BIAS_PULLDOWN, SlowSlew: true},
+		{MuxMode: 3, ReceiverEnable: true, Pull: BIAS_DISABLE},
+	} {
+		value, err := conf.encode()
+		if err != nil {
+			t.Error(err)
+		}
+		if decodePadConf(value) != conf {
+			t.Errorf("decodePadConf(0x%x) = %+v, want %+v", value, decodePadConf(value), conf)
+		}
+	}
+	if value, _ := (PadConf{MuxMode: 7, ReceiverEnable: true, Pull: BIAS_PULLUP}).encode(); value != 0x37 {
+		t.Errorf("encoded 0x%x, want 0x37", value)
+	}
+	if _, err := (PadConf{MuxMode: 8}).encode(); err == nil {
+		t.Error("MuxMode 8 should not be accepted")
+	}
+	if _, err := (PadConf{Pull: BIAS_AS_IS}).encode(); err == nil {
+		t.Error("BIAS_AS_IS should not be accepted")
+	}
+	if offset, err := findPadOffsetByGPIO(66); err != nil || offset != 0x090 {
+		t.Errorf("findPadOffsetByGPIO(66) = 0x%x, %v", offset, err)
+	}
+	if _, err := findPadOffsetByPin("P9_1"); err == nil {
+		t.Error("P9_1 is GND and has no pad")
+	}
+
+	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
+		t.Logf("test only works on BeagleBone")
+		return
+	}
+	conf, err := GetPadConf("P8_7")
+	if err != nil {
+		t.Error(err)
+	}
+	t.Logf("P8_7: %+v", conf)
+}
+
 func Test_MmappedGPIOwCable(t *testing.T) {
 	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
 		t.Logf("test only works on BeagleBone")
 		return
 	}
-	outg := NewMMappedGPIO(67, OUT) //P8_8
-	ing := NewMMappedGPIO(66, IN)   //P8_7
+	outg := NewMMappedGPIOOrPanic(67, OUT) //P8_8
+	ing := NewMMappedGPIOOrPanic(66, IN)   //P8_7
 
 	// Test Direction
 	d1, err1 := outg.CheckDirection()
@@ -184,8 +264,8 @@ func Test_MmappedGPIOwCable(t *testing.T) {
 	if err2 != nil {
 		t.Error(err2.Error())
 	}
-	if d2 != IN {
-		t.Error("ing.CheckDirection != IN")
+	if d2 != IN && d2 != IN_PULLUP && d2 != IN_PULLDOWN {
+		t.Error("ing.CheckDirection is not an input")
 	}
 
 	outg.SetState(false)
@@ -201,14 +281,120 @@ func Test_MmappedGPIOwCable(t *testing.T) {
 	//Step(outg, 20, time.Duration(200)*time.Millisecond, nil)
 }
 
+func Test_MmappedGPIOFakeRegisters(t *testing.T) {
+	mmapreg := useFakeGPIORegisters(t, "AM335x")
+	outg := NewMMappedGPIOOrPanic(67, OUT) //P8_8
+	ing := NewMMappedGPIOOrPanic(66, IN)   //P8_7
+
+	if CheckDirectionOrPanic(outg) != OUT {
+		t.Error("outg.CheckDirection != OUT")
+	}
+	if CheckDirectionOrPanic(ing) != IN {
+		t.Error("ing.CheckDirection != IN")
+	}
+	if mmapreg.read32(2, intgpio_output_enabled_)&(3<<2) != 1<<2 {
+		t.Errorf("OE register of gpio2 is 0x%x", mmapreg.read32(2, intgpio_output_enabled_))
+	}
+
+	outg.SetState(true)
+	if mmapreg.read32(2, intgpio_dataout_) != 1<<3 {
+		t.Errorf("SETDATAOUT did not set DATAOUT: 0x%x", mmapreg.read32(2, intgpio_dataout_))
+	}
+	if GetStateOrPanic(outg) != true {
+		t.Error("outg.GetState() != outg.SetState()")
+	}
+	// keeps the logical state and thus inverts the physical output
+	outg.SetActiveLow(true)
+	if GetStateOrPanic(outg) != true || mmapreg.read32(2, intgpio_dataout_) != 0 {
+		t.Error("CLEARDATAOUT did not clear DATAOUT")
+	}
+
+	if GetStateOrPanic(ing) != false {
+		t.Error("ing should be low")
+	}
+	mmapreg.setFakeDataIn(66, true)
+	if GetStateOrPanic(ing) != true {
+		t.Error("ing does not follow DATAIN")
+	}
+
+	if err := ing.SetDebounce(true); err != nil {
+		t.Error(err)
+	}
+	if mmapreg.read32(2, intgpio_debounceenable_) != 1<<2 {
+		t.Errorf("DEBOUNCENABLE is 0x%x", mmapreg.read32(2, intgpio_debounceenable_))
+	}
+	ing.SetDebounce(false)
+	if mmapreg.read32(2, intgpio_debounceenable_) != 0 {
+		t.Error("debounce was not disabled")
+	}
+	if err := outg.SetDebounce(true); err == nil {
+		t.Error("debouncing an output should fail")
+	}
+}
+
+func Test_MmappedGPIODebounceTime(t *testing.T) {
+	mmapreg := useFakeGPIORegisters(t, "AM335x")
+	in1 := NewMMappedGPIOOrPanic(66, IN) //gpio2[2]
+	in2 := NewMMappedGPIOOrPanic(67, IN) //gpio2[3]
+	in3 := NewMMappedGPIOOrPanic(2, IN)  //gpio0[2]
+	out := NewMMappedGPIOOrPanic(68, OUT)
+
+	if err := in1.SetDebounceTime(310 * time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if mmapreg.read32(2, intgpio_debouncetime_) != 9 {
+		t.Errorf("DEBOUNCINGTIME is %d, want 9", mmapreg.read32(2, intgpio_debouncetime_))
+	}
+	if mmapreg.read32(2, intgpio_debounceenable_) != 1<<2 {
+		t.Error("SetDebounceTime did not enable debouncing")
+	}
+	if d, err := in1.GetDebounceTime(); err != nil || d != 310*time.Microsecond {
+		t.Errorf("GetDebounceTime = %v, %v", d, err)
+	}
+	// rounded to 31µs
+	if err := in2.SetDebounceTime(300 * time.Microsecond); err != nil {
+		t.Error(err)
+	}
+	if err := in2.SetDebounceTime(time.Millisecond); err == nil {
+		t.Error("conflicting debounce time on the same bank was accepted")
+	}
+	// other banks are independent
+	if err := in3.SetDebounceTime(time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if d, _ := in1.GetDebounceTime(); d != 310*time.Microsecond {
+		t.Errorf("debounce time of gpio2 changed to %v", d)
+	}
+	// once the other input releases its claim, the time may change
+	in1.SetDebounce(false)
+	if err := in2.SetDebounceTime(time.Millisecond); err != nil {
+		t.Error(err)
+	}
+	if d, _ := in1.GetDebounceTime(); d != 992*time.Microsecond {
+		t.Errorf("GetDebounceTime = %v, want 992µs", d)
+	}
+
+	for _, d := range []time.Duration{0, 30 * time.Microsecond, 7937 * time.Microsecond, time.Second} {
+		if err := in3.SetDebounceTime(d); err == nil {
+			t.Errorf("out of range debounce time %v was accepted", d)
+		}
+	}
+	if err := in3.SetDebounceTime(7936 * time.Microsecond); err != nil || mmapreg.read32(0, intgpio_debouncetime_) != 255 {
+		t.Errorf("maximum debounce time: %v, DEBOUNCINGTIME=%d", err, mmapreg.read32(0, intgpio_debouncetime_))
+	}
+	if err := out.SetDebounceTime(time.Millisecond); err == nil {
+		t.Error("debouncing an output should fail")
+	}
+}
+
 func Test_MmappedGPIOwCableInGoroutines(t *testing.T) {
 	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
 		t.Logf("test only works on BeagleBone")
 		return
 	}
-	outg := NewMMappedGPIO(67, OUT) //P8_8
+	outg := NewMMappedGPIOOrPanic(67, OUT) //P8_8
 	outslow := NewSysfsGPIOOrPanic(67, OUT)
-	ing := NewMMappedGPIO(66, IN) //P8_7
+	ing := NewMMappedGPIOOrPanic(66, IN) //P8_7
 
 	go outg.SetState(false)
 	time.Sleep(10 * time.Millisecond)
@@ -260,3 +446,41 @@ func Test_FakeGPIO(t *testing.T) {
 		t.Error("Fake connection to f2 did not work")
 	}
 }
+
+func Test_FakeGPIOEdges(t *testing.T) {
+	f1 := NewFakeGPIO(1, OUT)
+	f2 := NewFakeGPIO(2, IN)
+	f1.ConnectTo(f2)
+	events, err := f2.WatchEdges(EDGE_RISING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2.WatchEdges(EDGE_BOTH); err == nil {
+		t.Error("watching twice should fail")
+	}
+	f1.SetState(true)
+	f1.SetState(true)
+	f1.SetState(false)
+	f2.FakeInput(true)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 rising edges, got %d events", len(events))
+	}
+	for ev := range events {
+		if ev.Edge != EDGE_RISING {
+			t.Error("expected only rising edges")
+		}
+		if len(events) == 0 {
+			break
+		}
+	}
+	f2.StopWatchingEdges()
+	if _, stillopen := <-events; stillopen {
+		t.Error("StopWatchingEdges did not close channel")
+	}
+	events, _ = f2.WatchEdges(EDGE_BOTH)
+	f2.FakeInput(false)
+	if ev := <-events; ev.Edge != EDGE_FALLING {
+		t.Error("expected falling edge")
+	}
+	f2.Close()
+}
@@ -0,0 +1,316 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Industrial I/O devices in /sys/bus/iio/devices: SoC ADCs and I2C/SPI sensors with a kernel driver, e.g. ADS1015, BME280, ADXL345
+
+var iio_sysfs_bus_dir_ = "/sys/bus/iio/devices"
+
+var iio_device_dir_regex_ = regexp.MustCompile(`^iio:device(\d+)$`)
+
+// e.g. in_voltage0_raw, in_voltage0-voltage1_raw, in_accel_x_raw, in_temp_input, in_temp_object_raw
+var iio_channel_attr_regex_ = regexp.MustCompile(`^(in|out)_([a-z]+[^_]*(?:_[a-z]+)?)_(raw|input)$`)
+
+type IIODeviceInfo struct {
+	Device   int      // N in iio:deviceN
+	Path     string   // the iio:deviceN directory
+	Name     string   // name of the driver or chip, e.g. TI-am335x-adc.0.auto or ads1015
+	DTNode   string   // path of the device-tree node, empty without device tree
+	Labels   []string // labels of the device-tree node from __symbols__ and the label property
+	Channels []IIOChannelInfo
+}
+
+// Values are in IIO units after scaling: voltage mV, current mA, temp m°C, accel m/s², anglvel rad/s, pressure kPa, humidityrelative m%
+type IIOChannelInfo struct {
+	Name     string  // e.g. voltage0, voltage0-voltage1, accel_x, temp
+	Type     string  // e.g. voltage, accel, temp, pressure, humidityrelative
+	Output   bool    // out_ instead of in_
+	Scale    float64 // units per count from _scale, 1 without
+	Offset   float64 // counts added before scaling from _offset
+	HasRaw   bool    // _raw exists
+	HasInput bool    // _input exists, already scaled by the driver
+	devdir   string
+}
+
+// Lists all IIO devices, ordered by device number
+func ListIIODevices() (devices []IIODeviceInfo, err error) {
+	entries, err := os.ReadDir(rootedPath(iio_sysfs_bus_dir_))
+	if err != nil {
+		return nil, err
+	}
+	symbols := readDeviceTreeSymbols()
+	for _, entry := range entries {
+		m := iio_device_dir_regex_.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		number, _ := strconv.Atoi(m[1])
+		var device IIODeviceInfo
+		if device, err = readIIODeviceInfo(number, symbols); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Device < devices[j].Device })
+	return
+}
+
+// Finds an IIO device by name, iio:deviceN, device-tree path or label.
+// The kernel appends the instance to some names, so "TI-am335x-adc" finds TI-am335x-adc.0.auto.
+// Example: FindIIODevice("ads1015"), FindIIODevice("iio:device1")
+func FindIIODevice(name string) (device IIODeviceInfo, err error) {
+	devices, err := ListIIODevices()
+	if err != nil {
+		return
+	}
+	for _, device = range devices {
+		if device.Name == name || strings.HasPrefix(device.Name, name+".") || filepath.Base(device.Path) == name || (device.DTNode != "" && device.DTNode == name) {
+			return
+		}
+		for _, label := range device.Labels {
+			if label == name {
+				return
+			}
+		}
+	}
+	return IIODeviceInfo{}, fmt.Errorf("IIO device %s Not Found", name)
+}
+
+func readIIODeviceInfo(number int, symbols map[string][]string) (device IIODeviceInfo, err error) {
+	device.Device = number
+	device.Path = filepath.Join(rootedPath(iio_sysfs_bus_dir_), fmt.Sprintf("iio:device%d", number))
+	if device.Name, err = readSysfsAttr(filepath.Join(device.Path, "name")); err != nil {
+		return
+	}
+	if node, linkerr := filepath.EvalSymlinks(filepath.Join(device.Path, "of_node")); linkerr == nil {
+		device.DTNode = deviceTreePath(node)
+		device.Labels = append(device.Labels, symbols[device.DTNode]...)
+		device.Labels = append(device.Labels, readDeviceTreeStrings(filepath.Join(node, "label"))...)
+	}
+	entries, err := os.ReadDir(device.Path)
+	if err != nil {
+		return
+	}
+	channels := make(map[string]*IIOChannelInfo)
+	var order []string
+	for _, entry := range entries {
+		m := iio_channel_attr_regex_.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		prefixed := m[1] + "_" + m[2]
+		channel := channels[prefixed]
+		if channel == nil {
+			channel = &IIOChannelInfo{Name: m[2], Type: iioChannelType(m[2]), Output: m[1] == "out", devdir: device.Path}
+			channel.Scale = readIIOChannelAttrFloat(device.Path, prefixed, "scale", 1)
+			channel.Offset = readIIOChannelAttrFloat(device.Path, prefixed, "offset", 0)
+			channels[prefixed] = channel
+			order = append(order, prefixed)
+		}
+		if m[3] == "raw" {
+			channel.HasRaw = true
+		} else {
+			channel.HasInput = true
+		}
+	}
+	for _, prefixed := range order {
+		device.Channels = append(device.Channels, *channels[prefixed])
+	}
+	return device, nil
+}
+
+// the leading letters: voltage0 is a voltage, accel_x an accel
+func iioChannelType(name string) string {
+	return name[:len(name)-len(strings.TrimLeft(name, "abcdefghijklmnopqrstuvwxyz"))]
+}
+
+// IIO attributes exist per channel like in_voltage0_scale or shared by the type like in_voltage_scale
+func readIIOChannelAttrFloat(devdir, prefixed, attr string, dflt float64) float64 {
+	direction, name, _ := strings.Cut(prefixed, "_")
+	for _, channel := range []string{prefixed, direction + "_" + iioChannelType(name)} {
+		if content, err := readSysfsAttr(filepath.Join(devdir, channel+"_"+attr)); err == nil {
+			if value, err := strconv.ParseFloat(content, 64); err == nil {
+				return value
+			}
+		}
+	}
+	return dflt
+}
+
+// Finds a channel by name, e.g. voltage0 or accel_x. Prefers inputs, use out_voltage0 for an output.
+func (device IIODeviceInfo) Channel(name string) (channel IIOChannelInfo, err error) {
+	for _, output := range []bool{false, true} {
+		for _, channel = range device.Channels {
+			if channel.Output == output && (channel.Name == name || channel.prefixed() == name) {
+				return
+			}
+		}
+	}
+	return IIOChannelInfo{}, fmt.Errorf("IIO device %s has no channel %s", device.Name, name)
+}
+
+func (channel IIOChannelInfo) prefixed() string {
+	if channel.Output {
+		return "out_" + channel.Name
+	}
+	return "in_" + channel.Name
+}
+
+// path of _raw or _input
+func (channel IIOChannelInfo) valuePath() string {
+	if channel.HasRaw {
+		return filepath.Join(channel.devdir, channel.prefixed()+"_raw")
+	}
+	return filepath.Join(channel.devdir, channel.prefixed()+"_input")
+}
+
+func (channel IIOChannelInfo) ReadRaw() (raw int64, err error) {
+	if !channel.HasRaw {
+		return 0, fmt.Errorf("channel %s has no raw value", channel.Name)
+	}
+	var content string
+	if content, err = readSysfsAttr(channel.valuePath()); err != nil {
+		return
+	}
+	return strconv.ParseInt(content, 10, 64)
+}
+
+// Value in IIO units, from _input if the driver scales it, otherwise (raw + offset) * scale
+func (channel IIOChannelInfo) Read() (value float64, err error) {
+	if channel.HasRaw {
+		var raw int64
+		if raw, err = channel.ReadRaw(); err != nil {
+			return
+		}
+		return channel.rawToValue(raw), nil
+	}
+	var content string
+	if content, err = readSysfsAttr(channel.valuePath()); err != nil {
+		return
+	}
+	return strconv.ParseFloat(content, 64)
+}
+
+func (channel IIOChannelInfo) rawToValue(raw int64) float64 {
+	return (float64(raw) + channel.Offset) * channel.Scale
+}
+
+/// ---------- IIOADC ---------------
+
+// One input channel of any IIO device as ADC. ReadValue returns whole IIO units, e.g. mV for voltage channels.
+type IIOADC struct {
+	Channel IIOChannelInfo
+	fd      *os.File
+	err     error
+}
+
+// Opens channel of the IIO device found by FindIIODevice.
+// Example: adc, err := NewIIOADC("ads1015", "voltage0")
+func NewIIOADC(device, channel string) (adc *IIOADC, err error) {
+	dev, err := FindIIODevice(device)
+	if err != nil {
+		return
+	}
+	adc = new(IIOADC)
+	if adc.Channel, err = dev.Channel(channel); err != nil {
+		return nil, err
+	}
+	if adc.Channel.Output {
+		return nil, fmt.Errorf("IIO channel %s is an output", channel)
+	}
+	if adc.fd, err = os.OpenFile(adc.Channel.valuePath(), os.O_RDONLY|os.O_SYNC, 0666); err != nil {
+		return nil, err
+	}
+	return adc, nil
+}
+
+// Wrapper around NewIIOADC. Does not return an error but panics instead.
+func NewIIOADCOrPanic(device, channel string) *IIOADC {
+	adc, err := NewIIOADC(device, channel)
+	if err != nil {
+		panic(err)
+	}
+	return adc
+}
+
+// value in IIO units, truncated, 0 if negative
+func (adc *IIOADC) ReadValue() (value uint16) {
+	var v float64
+	v, adc.err = adc.Read()
+	if adc.err == nil && v > 0 {
+		value = uint16(v)
+	}
+	return
+}
+
+func (adc *IIOADC) CheckErrorOccurred() error {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	return adc.err
+}
+
+func (adc *IIOADC) ReadValueCheckError() (value uint16, err error) {
+	value = adc.ReadValue()
+	err = adc.CheckErrorOccurred()
+	return
+}
+
+func (adc *IIOADC) ReadRaw() (int64, error) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	if !adc.Channel.HasRaw {
+		return 0, fmt.Errorf("channel %s has no raw value", adc.Channel.Name)
+	}
+	return readSysfsInt(adc.fd)
+}
+
+// Value in IIO units, e.g. mV or m°C
+func (adc *IIOADC) Read() (value float64, err error) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	if adc.Channel.HasRaw {
+		var raw int64
+		if raw, err = readSysfsInt(adc.fd); err != nil {
+			return
+		}
+		return adc.Channel.rawToValue(raw), nil
+	}
+	buf := make([]byte, 32)
+	numread, err := adc.fd.ReadAt(buf, 0)
+	if err != nil && numread == 0 {
+		return
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(buf[:numread])), 64)
+}
+
+// Only for voltage channels
+func (adc *IIOADC) ReadVolts() (volts float64, err error) {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	if adc.Channel.Type != "voltage" {
+		return 0, fmt.Errorf("channel %s is not a voltage", adc.Channel.Name)
+	}
+	if volts, err = adc.Read(); err != nil {
+		return
+	}
+	return volts / 1000, nil
+}
+
+func (adc *IIOADC) Close() error {
+	if adc == nil {
+		panic("adc == nil")
+	}
+	return adc.fd.Close()
+}
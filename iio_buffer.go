@@ -0,0 +1,494 @@
+package bbhw
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/// ---------- IIO buffered capture ---------------
+/// The kernel samples the enabled scan_elements of an IIO device into a buffer at its own pace,
+/// /dev/iio:deviceN then returns whole scans, one sample of every enabled channel each.
+/// see Documentation/ABI/testing/sysfs-bus-iio and Documentation/driver-api/iio/buffers.rst
+
+// directory of the iio:deviceN character devices
+var iio_dev_dir_ string = "/dev"
+
+// blocks waiting for the reader of Blocks(), further blocks are dropped
+var iio_buffer_queue_len_ = 16
+
+const (
+	iio_buffer_default_blocksize_ = 64
+	iio_timestamp_element_        = "in_timestamp"
+)
+
+// e.g. le:u12/16>>0 or be:s14/16X2>>2
+var iio_scan_type_regex_ = regexp.MustCompile(`^(le|be):([su])(\d+)/(\d+)(?:X(\d+))?>>(\d+)$`)
+
+type IIOBufferConfig struct {
+	// scan elements to capture, e.g. "voltage0", in the order the samples are delivered
+	Channels []string
+	// in Hz, 0 keeps the current setting. Not every driver has one, e.g. the AM335x ADC samples as fast as its step delays allow
+	SamplingFrequency float64
+	// scans per IIOSampleBlock, 64 if 0
+	BlockSize int
+	// scans the kernel buffers until we read them, 4*BlockSize if 0
+	BufferLength int
+}
+
+// Scans of the enabled channels. Samples[c][i] is the raw value of Channels[c] in scan i, taken at Timestamps[i].
+type IIOSampleBlock struct {
+	Samples    [][]int64
+	Timestamps []time.Time
+	// blocks dropped right before this one as Blocks() was not read fast enough
+	Dropped int
+	// the kernel buffer was full when reading this block, samples before it were probably lost
+	Overrun bool
+}
+
+type iioScanElement struct {
+	name        string
+	index       int
+	bigendian   bool
+	signed      bool
+	realbits    uint
+	storagebits uint
+	shift       uint
+	offset      int // within a scan
+}
+
+type IIOBuffer struct {
+	Channels  []string
+	sysfsdir  string
+	dev       *os.File
+	elements  []*iioScanElement // in the order of Channels
+	timestamp *iioScanElement   // nil if the device has none
+	scansize  int
+	period    time.Duration // between scans to estimate timestamps without a timestamp channel, 0 if unknown
+	blocksize int
+	buflen    int
+	blocks    chan IIOSampleBlock
+	done      chan struct{}
+	lock      sync.Mutex
+	err       error
+	dropped   uint64
+	overruns  uint64
+	closed    bool
+}
+
+func readSysfsAttr(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	return strings.TrimSpace(string(content)), err
+}
+
+// sysfs attributes can't be created, so unlike ioutil.WriteFile this fails if path does not exist
+func writeSysfsAttr(path, value string) error {
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write([]byte(value + "\n"))
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func parseIIOScanType(name, scantype string) (el *iioScanElement, err error) {
+	m := iio_scan_type_regex_.FindStringSubmatch(scantype)
+	if m == nil {
+		return nil, fmt.Errorf("%s has unknown scan type %q", name, scantype)
+	}
+	if m[5] != "" && m[5] != "1" {
+		return nil, fmt.Errorf("%s has repeated samples (%q), not supported", name, scantype)
+	}
+	el = &iioScanElement{name: name, bigendian: m[1] == "be", signed: m[2] == "s"}
+	realbits, _ := strconv.ParseUint(m[3], 10, 8)
+	storagebits, _ := strconv.ParseUint(m[4], 10, 8)
+	shift, _ := strconv.ParseUint(m[6], 10, 8)
+	el.realbits, el.storagebits, el.shift = uint(realbits), uint(storagebits), uint(shift)
+	switch el.storagebits {
+	case 8, 16, 32, 64:
+	default:
+		return nil, fmt.Errorf("%s has unsupported storage size %d bits", name, el.storagebits)
+	}
+	if el.realbits == 0 || el.realbits+el.shift > el.storagebits {
+		return nil, fmt.Errorf("%s has inconsistent scan type %q", name, scantype)
+	}
+	return el, nil
+}
+
+// value of the element in one scan, shifted, masked and sign extended
+func (el *iioScanElement) decode(scan []byte) int64 {
+	var order binary.ByteOrder = binary.LittleEndian
+	if el.bigendian {
+		order = binary.BigEndian
+	}
+	var raw uint64
+	data := scan[el.offset:]
+	switch el.storagebits {
+	case 8:
+		raw = uint64(data[0])
+	case 16:
+		raw = uint64(order.Uint16(data))
+	case 32:
+		raw = uint64(order.Uint32(data))
+	case 64:
+		raw = order.Uint64(data)
+	}
+	raw >>= el.shift
+	if el.realbits < 64 {
+		raw &= 1<<el.realbits - 1
+		if el.signed && raw&(1<<(el.realbits-1)) != 0 {
+			raw |= ^uint64(0) << el.realbits
+		}
+	}
+	return int64(raw)
+}
+
+// Buffered capture of the AM335x ADC inputs ain at the sampling frequency freq_hz, use 0 as the ADC has no setting for it
+func NewSysfsADCBuffer(ains []uint, freq_hz float64) (buf *IIOBuffer, err error) {
+	var adc_dir string
+	if adc_dir, err = findTSCADCDir(); err != nil {
+		return
+	}
+	config := IIOBufferConfig{SamplingFrequency: freq_hz}
+	for _, ain := range ains {
+		config.Channels = append(config.Channels, fmt.Sprintf("voltage%d", ain))
+	}
+	return NewIIOBuffer(adc_dir, config)
+}
+
+// Wrapper around NewSysfsADCBuffer. Does not return an error but panics instead.
+func NewSysfsADCBufferOrPanic(ains []uint, freq_hz float64) *IIOBuffer {
+	buf, err := NewSysfsADCBuffer(ains, freq_hz)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// Starts buffered capture on the IIO device with the sysfs directory devdir, e.g. /sys/bus/iio/devices/iio:device0.
+// Enables the timestamp channel too if the device has one, otherwise the timestamps are estimated from the sampling frequency.
+// Only one buffer per device can be open at a time.
+func NewIIOBuffer(devdir string, config IIOBufferConfig) (buf *IIOBuffer, err error) {
+	if len(config.Channels) == 0 {
+		return nil, fmt.Errorf("no channels to capture")
+	}
+	buf = &IIOBuffer{Channels: config.Channels, sysfsdir: devdir, blocksize: config.BlockSize, buflen: config.BufferLength}
+	if buf.blocksize <= 0 {
+		buf.blocksize = iio_buffer_default_blocksize_
+	}
+	if buf.buflen <= 0 {
+		buf.buflen = 4 * buf.blocksize
+	}
+	if buf.buflen < buf.blocksize {
+		return nil, fmt.Errorf("buffer length %d is shorter than a block of %d", buf.buflen, buf.blocksize)
+	}
+	// may still be running if a previous program did not clean up
+	if err = writeSysfsAttr(filepath.Join(devdir, "buffer/enable"), "0"); err != nil {
+		return nil, fmt.Errorf("%s has no buffer: %v", devdir, err)
+	}
+	if err = buf.enableScanElements(); err != nil {
+		buf.disableScanElements()
+		return nil, err
+	}
+	if err = buf.configure(config.SamplingFrequency); err != nil {
+		buf.disableScanElements()
+		return nil, err
+	}
+	buf.dev, err = os.OpenFile(filepath.Join(iio_dev_dir_, filepath.Base(devdir)), os.O_RDONLY, 0)
+	if err != nil {
+		buf.disableScanElements()
+		return nil, err
+	}
+	if err = writeSysfsAttr(filepath.Join(devdir, "buffer/enable"), "1"); err != nil {
+		buf.dev.Close()
+		buf.disableScanElements()
+		return nil, err
+	}
+	buf.blocks = make(chan IIOSampleBlock, iio_buffer_queue_len_)
+	buf.done = make(chan struct{})
+	go buf.capture()
+	return buf, nil
+}
+
+// Wrapper around NewIIOBuffer. Does not return an error but panics instead.
+func NewIIOBufferOrPanic(devdir string, config IIOBufferConfig) *IIOBuffer {
+	buf, err := NewIIOBuffer(devdir, config)
+	if err != nil {
+		panic(err)
+	}
+	return buf
+}
+
+// enables exactly the requested scan elements plus the timestamp and computes the layout of a scan
+func (buf *IIOBuffer) enableScanElements() (err error) {
+	scandir := filepath.Join(buf.sysfsdir, "scan_elements")
+	enfiles, _ := filepath.Glob(filepath.Join(scandir, "*_en"))
+	if len(enfiles) == 0 {
+		return fmt.Errorf("%s has no scan_elements", buf.sysfsdir)
+	}
+	available := make(map[string]bool)
+	for _, enfile := range enfiles {
+		name := strings.TrimSuffix(filepath.Base(enfile), "_en")
+		available[name] = true
+		if err = writeSysfsAttr(enfile, "0"); err != nil {
+			return
+		}
+	}
+	wanted := make([]string, 0, len(buf.Channels)+1)
+	for _, channel := range buf.Channels {
+		name := channel
+		if !strings.HasPrefix(name, "in_") {
+			name = "in_" + name
+		}
+		if !available[name] {
+			return fmt.Errorf("%s has no scan element %s", buf.sysfsdir, name)
+		}
+		wanted = append(wanted, name)
+	}
+	if available[iio_timestamp_element_] {
+		wanted = append(wanted, iio_timestamp_element_)
+	}
+	var inscan []*iioScanElement
+	for i, name := range wanted {
+		var el *iioScanElement
+		if el, err = buf.readScanElement(scandir, name); err != nil {
+			return
+		}
+		if i < len(buf.Channels) {
+			for _, other := range buf.elements {
+				if other.name == name {
+					return fmt.Errorf("channel %s requested twice", name)
+				}
+			}
+			buf.elements = append(buf.elements, el)
+		} else {
+			buf.timestamp = el
+		}
+		inscan = append(inscan, el)
+		if err = writeSysfsAttr(filepath.Join(scandir, name+"_en"), "1"); err != nil {
+			return
+		}
+	}
+	// the kernel orders the scan by index and aligns each element to its own size
+	sort.Slice(inscan, func(i, j int) bool { return inscan[i].index < inscan[j].index })
+	align := 1
+	for _, el := range inscan {
+		size := int(el.storagebits / 8)
+		buf.scansize = (buf.scansize + size - 1) / size * size
+		el.offset = buf.scansize
+		buf.scansize += size
+		if size > align {
+			align = size
+		}
+	}
+	buf.scansize = (buf.scansize + align - 1) / align * align
+	return nil
+}
+
+func (buf *IIOBuffer) readScanElement(scandir, name string) (el *iioScanElement, err error) {
+	var index, scantype string
+	if index, err = readSysfsAttr(filepath.Join(scandir, name+"_index")); err != nil {
+		return
+	}
+	if scantype, err = readSysfsAttr(filepath.Join(scandir, name+"_type")); err != nil {
+		return
+	}
+	if el, err = parseIIOScanType(name, scantype); err != nil {
+		return
+	}
+	el.index, err = strconv.Atoi(index)
+	return
+}
+
+func (buf *IIOBuffer) disableScanElements() {
+	enfiles, _ := filepath.Glob(filepath.Join(buf.sysfsdir, "scan_elements/*_en"))
+	for _, enfile := range enfiles {
+		writeSysfsAttr(enfile, "0")
+	}
+}
+
+func (buf *IIOBuffer) configure(freq_hz float64) (err error) {
+	freqfile := filepath.Join(buf.sysfsdir, "sampling_frequency")
+	if freq_hz > 0 {
+		if !doesPathExist(freqfile) {
+			return fmt.Errorf("%s has no adjustable sampling frequency", buf.sysfsdir)
+		}
+		if err = writeSysfsAttr(freqfile, strconv.FormatFloat(freq_hz, 'f', -1, 64)); err != nil {
+			return fmt.Errorf("sampling frequency %g Hz rejected: %v", freq_hz, err)
+		}
+	}
+	// the driver may have rounded it
+	if current, rerr := readSysfsAttr(freqfile); rerr == nil {
+		if f, perr := strconv.ParseFloat(current, 64); perr == nil && f > 0 {
+			buf.period = time.Duration(float64(time.Second) / f)
+		}
+	}
+	if err = writeSysfsAttr(filepath.Join(buf.sysfsdir, "buffer/length"), strconv.Itoa(buf.buflen)); err != nil {
+		return
+	}
+	// older kernels have no watermark and wake the reader for every scan
+	if watermark := filepath.Join(buf.sysfsdir, "buffer/watermark"); doesPathExist(watermark) {
+		err = writeSysfsAttr(watermark, strconv.Itoa(buf.blocksize))
+	}
+	return
+}
+
+func (buf *IIOBuffer) capture() {
+	defer close(buf.done)
+	defer close(buf.blocks)
+	chunk := make([]byte, buf.buflen*buf.scansize)
+	var pending []byte
+	block := buf.newBlock()
+	dropped := 0
+	deliver := func() {
+		if len(block.Timestamps) == 0 {
+			return
+		}
+		block.Dropped = dropped
+		select {
+		case buf.blocks <- block:
+			dropped = 0
+		default:
+			dropped++
+			buf.lock.Lock()
+			buf.dropped++
+			buf.lock.Unlock()
+		}
+		block = buf.newBlock()
+	}
+	for {
+		n, err := buf.dev.Read(chunk)
+		now := time.Now()
+		if n > 0 {
+			// a read returning the whole kernel buffer means it ran full
+			if n == len(chunk) {
+				block.Overrun = true
+				buf.lock.Lock()
+				buf.overruns++
+				buf.lock.Unlock()
+			}
+			pending = append(pending, chunk[:n]...)
+			nscans := len(pending) / buf.scansize
+			for i := 0; i < nscans; i++ {
+				scan := pending[i*buf.scansize : (i+1)*buf.scansize]
+				for c, el := range buf.elements {
+					block.Samples[c] = append(block.Samples[c], el.decode(scan))
+				}
+				if buf.timestamp != nil {
+					block.Timestamps = append(block.Timestamps, time.Unix(0, buf.timestamp.decode(scan)))
+				} else {
+					block.Timestamps = append(block.Timestamps, now.Add(-time.Duration(nscans-1-i)*buf.period))
+				}
+				if len(block.Timestamps) == buf.blocksize {
+					deliver()
+				}
+			}
+			pending = append(pending[:0], pending[nscans*buf.scansize:]...)
+		}
+		if err != nil {
+			// a recorded capture ends, Close closes the device
+			if err != io.EOF && !errors.Is(err, os.ErrClosed) {
+				buf.lock.Lock()
+				buf.err = err
+				buf.lock.Unlock()
+			}
+			deliver()
+			return
+		}
+	}
+}
+
+func (buf *IIOBuffer) newBlock() IIOSampleBlock {
+	block := IIOSampleBlock{Samples: make([][]int64, len(buf.elements)), Timestamps: make([]time.Time, 0, buf.blocksize)}
+	for c := range block.Samples {
+		block.Samples[c] = make([]int64, 0, buf.blocksize)
+	}
+	return block
+}
+
+// Receives the captured blocks, closed once the capture ended. The last block may be shorter.
+func (buf *IIOBuffer) Blocks() <-chan IIOSampleBlock {
+	if buf == nil {
+		panic("buf == nil")
+	}
+	return buf.blocks
+}
+
+// Waits for the next block. Returns io.EOF after the capture ended without error.
+func (buf *IIOBuffer) Next() (IIOSampleBlock, error) {
+	if buf == nil {
+		panic("buf == nil")
+	}
+	block, ok := <-buf.blocks
+	if !ok {
+		if err := buf.CheckErrorOccurred(); err != nil {
+			return block, err
+		}
+		return block, io.EOF
+	}
+	return block, nil
+}
+
+// Blocks dropped so far because Blocks() was not read fast enough
+func (buf *IIOBuffer) Dropped() uint64 {
+	if buf == nil {
+		panic("buf == nil")
+	}
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	return buf.dropped
+}
+
+// How often the kernel buffer was found full so far. Increase BufferLength or read faster.
+func (buf *IIOBuffer) Overruns() uint64 {
+	if buf == nil {
+		panic("buf == nil")
+	}
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	return buf.overruns
+}
+
+// The error that ended the capture
+func (buf *IIOBuffer) CheckErrorOccurred() error {
+	if buf == nil {
+		panic("buf == nil")
+	}
+	buf.lock.Lock()
+	defer buf.lock.Unlock()
+	return buf.err
+}
+
+// Stops the capture, disables the buffer and the scan elements. Blocks captured until then can still be received.
+func (buf *IIOBuffer) Close() (err error) {
+	if buf == nil {
+		panic("buf == nil")
+	}
+	buf.lock.Lock()
+	if buf.closed {
+		buf.lock.Unlock()
+		return nil
+	}
+	buf.closed = true
+	buf.lock.Unlock()
+	err = buf.dev.Close()
+	<-buf.done
+	if derr := writeSysfsAttr(filepath.Join(buf.sysfsdir, "buffer/enable"), "0"); err == nil {
+		err = derr
+	}
+	buf.disableScanElements()
+	return
+}
@@ -0,0 +1,177 @@
+package bbhw
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+var fake_iio_adc_ = map[string]string{
+	"/sys/bus/iio/devices/iio:device0/name":                             "TI-am335x-adc.0.auto\n",
+	"/sys/bus/iio/devices/iio:device0/in_voltage0_raw":                  "0\n",
+	"/sys/bus/iio/devices/iio:device0/sampling_frequency":               "1000\n",
+	"/sys/bus/iio/devices/iio:device0/buffer/enable":                    "1\n",
+	"/sys/bus/iio/devices/iio:device0/buffer/length":                    "256\n",
+	"/sys/bus/iio/devices/iio:device0/buffer/watermark":                 "1\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage0_en":     "1\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage0_index":  "0\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage0_type":   "le:u12/16>>0\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage1_en":     "1\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage1_index":  "1\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage1_type":   "le:u12/16>>0\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage3_en":     "0\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage3_index":  "3\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_voltage3_type":   "be:s12/16>>4\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_timestamp_en":    "0\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_timestamp_index": "8\n",
+	"/sys/bus/iio/devices/iio:device0/scan_elements/in_timestamp_type":  "le:s64/64>>0\n",
+}
+
+// fake /dev/iio:device0 as a named pipe, the returned channel takes recordings to write into it. Close it to end the capture.
+func useFakeIIODevice(t *testing.T) chan<- []byte {
+	orig := iio_dev_dir_
+	t.Cleanup(func() { iio_dev_dir_ = orig })
+	iio_dev_dir_ = t.TempDir()
+	fifo := filepath.Join(iio_dev_dir_, "iio:device0")
+	if err := syscall.Mkfifo(fifo, 0666); err != nil {
+		t.Skip("no named pipes:", err)
+	}
+	recordings := make(chan []byte)
+	go func() {
+		// blocks until NewIIOBuffer opens the other end
+		writer, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer writer.Close()
+		for rec := range recordings {
+			writer.Write(rec)
+		}
+	}()
+	return recordings
+}
+
+// scans of voltage0, voltage3 and the timestamp as the kernel lays them out, 16 bytes each
+func fakeIIOScans(first, n int) []byte {
+	rec := make([]byte, 0, 16*n)
+	for i := first; i < first+n; i++ {
+		scan := make([]byte, 16)
+		binary.LittleEndian.PutUint16(scan[0:], uint16(i))
+		binary.BigEndian.PutUint16(scan[2:], uint16(-i)<<4)
+		binary.LittleEndian.PutUint64(scan[8:], uint64(i)*uint64(time.Millisecond))
+		rec = append(rec, scan...)
+	}
+	return rec
+}
+
+func Test_IIOBuffer(t *testing.T) {
+	root := useFakeFilesystemRoot(t, fake_iio_adc_)
+	recordings := useFakeIIODevice(t)
+	if _, err := NewSysfsADCBuffer([]uint{0, 7}, 0); err == nil {
+		t.Fatal("ain7 has no scan element")
+	}
+	buf, err := NewIIOBuffer(rootedPath("/sys/bus/iio/devices/iio:device0"), IIOBufferConfig{
+		Channels: []string{"voltage3", "voltage0"}, SamplingFrequency: 2000, BlockSize: 4, BufferLength: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{"in_voltage0_en": "1\n", "in_voltage1_en": "0\n", "in_voltage3_en": "1\n",
+		"in_timestamp_en": "1\n", "../buffer/enable": "1\n", "../buffer/length": "8\n", "../buffer/watermark": "4\n", "../sampling_frequency": "2000\n"} {
+		if got := readFakeFile(t, root, "/sys/bus/iio/devices/iio:device0/scan_elements/"+file); got != want {
+			t.Errorf("%s is %q instead of %q", file, got, want)
+		}
+	}
+
+	// a full kernel buffer, then the rest. The end of the recording delivers the last, short block.
+	recordings <- fakeIIOScans(1, 8)
+	recordings <- fakeIIOScans(9, 2)
+	close(recordings)
+	for b, size := range []int{4, 4, 2} {
+		block, err := buf.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(block.Timestamps) != size || block.Overrun != (b == 0) {
+			t.Fatalf("block %d has %d scans, overrun %v", b, len(block.Timestamps), block.Overrun)
+		}
+		for i := range block.Timestamps {
+			scan := int64(4*b + i + 1)
+			if block.Samples[0][i] != -scan || block.Samples[1][i] != scan || block.Timestamps[i].UnixNano() != scan*int64(time.Millisecond) {
+				t.Errorf("scan %d is %d, %d at %v", scan, block.Samples[0][i], block.Samples[1][i], block.Timestamps[i])
+			}
+		}
+	}
+	if buf.Overruns() != 1 {
+		t.Errorf("%d overruns", buf.Overruns())
+	}
+	if err := buf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"buffer/enable", "scan_elements/in_voltage0_en", "scan_elements/in_voltage3_en", "scan_elements/in_timestamp_en"} {
+		if got := readFakeFile(t, root, "/sys/bus/iio/devices/iio:device0/"+file); got != "0\n" {
+			t.Errorf("%s is %q after Close", file, got)
+		}
+	}
+	if _, err := buf.Next(); err != io.EOF {
+		t.Errorf("Next after the end returned %v", err)
+	}
+}
+
+func Test_IIOBufferDropped(t *testing.T) {
+	useFakeFilesystemRoot(t, fake_iio_adc_)
+	recordings := useFakeIIODevice(t)
+	buf, err := NewIIOBuffer(rootedPath("/sys/bus/iio/devices/iio:device0"), IIOBufferConfig{Channels: []string{"voltage0"}, BlockSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Close()
+	// nobody reads while the recording plays
+	for scan := 0; scan < 2*(iio_buffer_queue_len_+3); scan += 2 {
+		recordings <- fakeIIOScans(scan, 2)
+	}
+	close(recordings)
+	waitFor(t, "end of recording", func() bool { return buf.Dropped() == 3 })
+	blocks := 0
+	for range buf.Blocks() {
+		blocks++
+	}
+	if blocks != iio_buffer_queue_len_ || buf.CheckErrorOccurred() != nil {
+		t.Errorf("received %d blocks, error %v", blocks, buf.CheckErrorOccurred())
+	}
+}
+
+func Test_IIOBufferClose(t *testing.T) {
+	useFakeFilesystemRoot(t, fake_iio_adc_)
+	recordings := useFakeIIODevice(t)
+	defer close(recordings)
+	buf := NewIIOBufferOrPanic(rootedPath("/sys/bus/iio/devices/iio:device0"), IIOBufferConfig{Channels: []string{"voltage1"}})
+	// the capture waits for data and Close has to wake it up
+	if err := buf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := buf.Next(); err != io.EOF || buf.CheckErrorOccurred() != nil {
+		t.Errorf("Next after Close returned %v", err)
+	}
+}
+
+func Test_IIOScanType(t *testing.T) {
+	for scantype, want := range map[string]int64{"le:u12/16>>0": 0x0123, "le:s12/16>>4": -0x0EE, "be:u10/16>>2": 0x0FC} {
+		el, err := parseIIOScanType("in_voltage0", scantype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := el.decode([]byte{0x23, 0xF1}); got != want {
+			t.Errorf("%s decodes to %d instead of %d", scantype, got, want)
+		}
+	}
+	for _, scantype := range []string{"le:u12/12>>0", "le:u12/16X2>>0", "le:u16/16>>4", "u12/16"} {
+		if _, err := parseIIOScanType("in_voltage0", scantype); err == nil {
+			t.Errorf("%s should be refused", scantype)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package bbhw
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// the AM335x ADC, an ADS1015 with a device-tree label and a BME280 with processed values
+func makeFakeIIOSysfs(t *testing.T) {
+	root := useFakeFilesystemRoot(t, map[string]string{
+		"/sys/bus/iio/devices/iio:device0/name":                        "TI-am335x-adc.0.auto\n",
+		"/sys/bus/iio/devices/iio:device0/in_voltage0_raw":             "4095\n",
+		"/sys/bus/iio/devices/iio:device0/in_voltage1_raw":             "0\n",
+		"/sys/bus/iio/devices/iio:device1/name":                        "ads1015\n",
+		"/sys/bus/iio/devices/iio:device1/in_voltage0_raw":             "1000\n",
+		"/sys/bus/iio/devices/iio:device1/in_voltage0_scale":           "3\n",
+		"/sys/bus/iio/devices/iio:device1/in_voltage0_scale_available": "3 2 1 0.5\n",
+		"/sys/bus/iio/devices/iio:device1/in_voltage0-voltage1_raw":    "-200\n",
+		"/sys/bus/iio/devices/iio:device1/in_voltage0-voltage1_scale":  "2\n",
+		"/sys/bus/iio/devices/iio:device1/sampling_frequency":          "1600\n",
+		"/sys/bus/iio/devices/iio:device3/name":                        "bme280\n",
+		"/sys/bus/iio/devices/iio:device3/in_temp_input":               "23450\n",
+		"/sys/bus/iio/devices/iio:device3/in_pressure_input":           "101.325\n",
+		"/sys/bus/iio/devices/iio:device3/in_humidityrelative_input":   "45123\n",
+		"/sys/bus/iio/devices/iio:device4/name":                        "adxl345\n",
+		"/sys/bus/iio/devices/iio:device4/in_accel_x_raw":              "-12\n",
+		"/sys/bus/iio/devices/iio:device4/in_accel_x_calibbias":        "0\n",
+		"/sys/bus/iio/devices/iio:device4/in_accel_scale":              "0.038\n",
+		"/sys/bus/iio/devices/trigger0/name":                           "sysfstrig0\n",
+		"/sys/firmware/devicetree/base/ocp/i2c@4819c000/adc@48/label":  "battery\x00",
+		"/sys/firmware/devicetree/base/__symbols__/ads1015":            "/ocp/i2c@4819c000/adc@48\x00",
+	})
+	if err := os.Symlink(filepath.Join(root, "/sys/firmware/devicetree/base/ocp/i2c@4819c000/adc@48"), filepath.Join(root, "/sys/bus/iio/devices/iio:device1/of_node")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_ListIIODevices(t *testing.T) {
+	makeFakeIIOSysfs(t)
+	devices, err := ListIIODevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 4 || devices[1].Device != 1 || devices[2].Name != "bme280" {
+		t.Fatalf("expected iio:device0, 1, 3 and 4, got %+v", devices)
+	}
+	ads := devices[1]
+	if ads.DTNode != "/ocp/i2c@4819c000/adc@48" || len(ads.Labels) != 2 || len(ads.Channels) != 2 {
+		t.Errorf("wrong info for the ADS1015: %+v", ads)
+	}
+	for _, name := range []string{"TI-am335x-adc", "iio:device1", "battery", "/ocp/i2c@4819c000/adc@48", "bme280"} {
+		if _, err := FindIIODevice(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := FindIIODevice("TI-am335x"); err == nil {
+		t.Error("a name has to match up to the instance suffix")
+	}
+
+	for _, c := range []struct {
+		device, channel, typ string
+		value                float64
+	}{
+		{"ads1015", "voltage0", "voltage", 3000},
+		{"ads1015", "voltage0-voltage1", "voltage", -400},
+		{"bme280", "temp", "temp", 23450},
+		{"bme280", "pressure", "pressure", 101.325},
+		{"bme280", "humidityrelative", "humidityrelative", 45123},
+		{"adxl345", "accel_x", "accel", -0.456},
+	} {
+		device, _ := FindIIODevice(c.device)
+		channel, err := device.Channel(c.channel)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if value, err := channel.Read(); err != nil || !closeTo(value, c.value) || channel.Type != c.typ {
+			t.Errorf("%s of %s is a %s reading %g, %v", c.channel, c.device, channel.Type, value, err)
+		}
+	}
+}
+
+func Test_IIOADC(t *testing.T) {
+	makeFakeIIOSysfs(t)
+	adc, err := NewIIOADC("ads1015", "voltage0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adc.Close()
+	if value, err := adc.ReadValueCheckError(); err != nil || value != 3000 {
+		t.Errorf("ADS1015 reads %dmV, %v", value, err)
+	}
+	if volts, _ := adc.ReadVolts(); volts != 3 {
+		t.Errorf("ADS1015 reads %gV", volts)
+	}
+	temp := NewIIOADCOrPanic("bme280", "temp")
+	defer temp.Close()
+	if _, err := temp.ReadVolts(); err == nil {
+		t.Error("temperature has no volts")
+	}
+	if _, err := temp.ReadRaw(); err == nil {
+		t.Error("bme280 has no raw temperature")
+	}
+	if value, err := temp.Read(); err != nil || value != 23450 {
+		t.Errorf("bme280 reads %gm°C, %v", value, err)
+	}
+
+	// SysfsADC finds the AM335x ADC through the IIO layer
+	if sysfs, err := NewSysfsADC(0); err != nil || sysfs.ReadValue() != 1799 {
+		t.Errorf("SysfsADC of iio:device0 failed: %v", err)
+	}
+	var _ ScaledADC = adc
+}
@@ -0,0 +1,160 @@
+package bbhw
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Description of the GPIO registers of a SoC, used by MMappedGPIO and MMappedGPIOCollectionFactory
+//
+// All register offsets are byte offsets of 32bit registers relative to the start of a bank.
+// Since all supported SoCs have 32 GPIOs per bank, the linux GPIO number n is bit n%32 of bank n/32.
+type mmapSoC struct {
+	name           string
+	compatible     []string // prefixes of the entries in /proc/device-tree/compatible
+	cpuinfo        []string // substrings of the Hardware line in /proc/cpuinfo, for kernels without device-tree
+	memdev         string
+	pagesize       int
+	banks          []mmapGPIOBank
+	setdataout     uint
+	cleardataout   uint
+	datain         uint
+	dataout        uint
+	debounceenable uint // 0 if the SoC can't debounce inputs
+	debouncetime   uint
+	pinmux         bool // pull resistors can be read from the AM335x control module, see pinmux_mmap.go
+	isInput        func(mmapreg *mappedRegisters, chipid int, gpioid uint) bool
+	setInput       func(mmapreg *mappedRegisters, chipid int, gpioid uint, input bool)
+}
+
+type mmapGPIOBank struct {
+	base      int64 // physical address, i.e. offset into memdev
+	regoffset uint  // offset of the registers of this bank relative to base
+}
+
+var devicetree_compatible_path_ = "/proc/device-tree/compatible"
+
+// TI OMAP4 style GPIO modules (AM335x, AM57xx), one 4KiB page per bank
+func omap4SoC(name string, compatible, cpuinfo []string, bankaddrs ...int64) *mmapSoC {
+	soc := &mmapSoC{
+		name:           name,
+		compatible:     compatible,
+		cpuinfo:        cpuinfo,
+		memdev:         "/dev/mem",
+		pagesize:       gpio_pagesize_,
+		setdataout:     intgpio_setdataout_,
+		cleardataout:   intgpio_cleardataout_,
+		datain:         intgpio_datain_,
+		dataout:        intgpio_dataout_,
+		debounceenable: intgpio_debounceenable_,
+		debouncetime:   intgpio_debouncetime_,
+		isInput:        omap4IsInput,
+		setInput:       omap4SetInput,
+	}
+	for _, addr := range bankaddrs {
+		soc.banks = append(soc.banks, mmapGPIOBank{base: addr})
+	}
+	return soc
+}
+
+// a set bit in the OE register disables the output
+func omap4IsInput(mmapreg *mappedRegisters, chipid int, gpioid uint) bool {
+	return mmapreg.read32(chipid, intgpio_output_enabled_)&(1<<gpioid) > 0
+}
+
+func omap4SetInput(mmapreg *mappedRegisters, chipid int, gpioid uint, input bool) {
+	oe := mmapreg.read32(chipid, intgpio_output_enabled_)
+	if input {
+		oe |= 1 << gpioid
+	} else {
+		oe &= ^uint32(1 << gpioid)
+	}
+	mmapreg.write32(chipid, intgpio_output_enabled_, oe)
+}
+
+// Broadcom BCM2835 and successors (Raspberry Pi).
+// /dev/gpiomem maps the GPIO page without requiring root,
+// the registers of the second bank follow the ones of the first bank at +4 bytes.
+func bcm2835SoC(name string, compatible, cpuinfo []string) *mmapSoC {
+	return &mmapSoC{
+		name:         name,
+		compatible:   compatible,
+		cpuinfo:      cpuinfo,
+		memdev:       "/dev/gpiomem",
+		pagesize:     gpio_pagesize_,
+		banks:        []mmapGPIOBank{{0, 0}, {0, 4}},
+		setdataout:   bcm2835_gpset_,
+		cleardataout: bcm2835_gpclr_,
+		datain:       bcm2835_gplev_,
+		dataout:      bcm2835_gplev_, // the level register also reflects outputs
+		isInput:      bcm2835IsInput,
+		setInput:     bcm2835SetInput,
+	}
+}
+
+// the function of each pin is given by 3 bits in the GPFSEL registers, 0 is input, 1 is output
+func bcm2835IsInput(mmapreg *mappedRegisters, chipid int, gpioid uint) bool {
+	pin := uint(chipid)*32 + gpioid
+	return (mmapreg.read32(0, bcm2835_gpfsel_+pin/10*BYTES_IN_UINT32)>>((pin%10)*3))&0x7 == 0
+}
+
+func bcm2835SetInput(mmapreg *mappedRegisters, chipid int, gpioid uint, input bool) {
+	pin := uint(chipid)*32 + gpioid
+	register := bcm2835_gpfsel_ + pin/10*BYTES_IN_UINT32
+	fsel := mmapreg.read32(0, register) &^ (0x7 << ((pin % 10) * 3))
+	if !input {
+		fsel |= 1 << ((pin % 10) * 3)
+	}
+	mmapreg.write32(0, register, fsel)
+}
+
+const (
+	bcm2835_gpfsel_ = 0x00
+	bcm2835_gpset_  = 0x1C
+	bcm2835_gpclr_  = 0x28
+	bcm2835_gplev_  = 0x34
+)
+
+func am335xSoC() *mmapSoC {
+	soc := omap4SoC("AM335x", []string{"ti,am33xx"}, []string{"AM33XX"},
+		omap4_gpio0_offset_, omap4_gpio1_offset_, omap4_gpio2_offset_, omap4_gpio3_offset_)
+	soc.pinmux = true
+	return soc
+}
+
+var mmap_socs_ = []*mmapSoC{
+	am335xSoC(),
+	omap4SoC("AM57xx", []string{"ti,am57", "ti,dra7"}, []string{"DRA7", "AM57"},
+		0x4AE10000, 0x48055000, 0x48057000, 0x48059000, 0x4805B000, 0x4805D000, 0x48051000, 0x48053000),
+	bcm2835SoC("BCM2835", []string{"brcm,bcm2835", "brcm,bcm2708"}, []string{"BCM2835", "BCM2708"}),
+	bcm2835SoC("BCM2836", []string{"brcm,bcm2836", "brcm,bcm2837", "brcm,bcm2709", "brcm,bcm2710"}, []string{"BCM2836", "BCM2837", "BCM2709", "BCM2710"}),
+	bcm2835SoC("BCM2711", []string{"brcm,bcm2711"}, []string{"BCM2711"}),
+}
+
+// find the SoC we are running on using the device-tree or, failing that, /proc/cpuinfo
+func detectMMapSoC() (*mmapSoC, error) {
+	if compatible, err := ioutil.ReadFile(rootedPath(devicetree_compatible_path_)); err == nil {
+		for _, entry := range strings.Split(string(compatible), "\x00") {
+			for _, soc := range mmap_socs_ {
+				for _, prefix := range soc.compatible {
+					if entry != "" && strings.HasPrefix(entry, prefix) {
+						return soc, nil
+					}
+				}
+			}
+		}
+	}
+	if cpuinfo, err := GetCPUInfos(); err == nil {
+		for _, hardware := range cpuinfo["Hardware"] {
+			for _, soc := range mmap_socs_ {
+				for _, substr := range soc.cpuinfo {
+					if strings.Contains(strings.ToUpper(hardware), substr) {
+						return soc, nil
+					}
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("Looks like we aren't on a supported SoC (AM335x, AM57xx, BCM2835/6/7, BCM2711)! Please check your Datasheet and update the code (github) or stick to the SysFSGPIOs")
+}
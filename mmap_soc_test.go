@@ -0,0 +1,126 @@
+package bbhw
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func Test_DetectMMapSoC(t *testing.T) {
+	orig := devicetree_compatible_path_
+	defer func() { devicetree_compatible_path_ = orig }()
+	devicetree_compatible_path_ = filepath.Join(t.TempDir(), "compatible")
+
+	for compatible, want := range map[string]string{
+		"ti,am335x-bone-black\x00ti,am335x-bone\x00ti,am33xx\x00":  "AM335x",
+		"beagle,am5729-beagleboneai\x00ti,am5728\x00ti,dra742\x00": "AM57xx",
+		"raspberrypi,model-b-plus\x00brcm,bcm2835\x00":             "BCM2835",
+		"raspberrypi,3-model-b\x00brcm,bcm2837\x00":                "BCM2836",
+		"raspberrypi,4-model-b\x00brcm,bcm2711\x00":                "BCM2711",
+	} {
+		if err := ioutil.WriteFile(devicetree_compatible_path_, []byte(compatible), 0644); err != nil {
+			t.Fatal(err)
+		}
+		soc, err := detectMMapSoC()
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if soc.name != want {
+			t.Errorf("detected %s instead of %s", soc.name, want)
+		}
+	}
+}
+
+func findMMapSoC(t *testing.T, name string) *mmapSoC {
+	for _, soc := range mmap_socs_ {
+		if soc.name == name {
+			return soc
+		}
+	}
+	t.Fatalf("no SoC %s", name)
+	return nil
+}
+
+// replaces the GPIO registers with fake ones for the duration of the test
+func useFakeGPIORegisters(t *testing.T, socname string) *mappedRegisters {
+	mmapreg, err := newFakeGPIORegisters(findMMapSoC(t, socname), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := mmapped_gpio_register_
+	mmapped_gpio_register_ = mmapreg
+	t.Cleanup(func() { mmapped_gpio_register_ = orig })
+	return mmapreg
+}
+
+func Test_MMapSoCDirection(t *testing.T) {
+	mmapreg := useFakeGPIORegisters(t, "BCM2835")
+	soc := mmapreg.soc
+	page := mmapreg.memgpiochipreg32[0]
+	// GPIO 17 and GPIO 47 as output
+	soc.setInput(mmapreg, 0, 17, false)
+	soc.setInput(mmapreg, 1, 15, false)
+	if page[1] != 1<<(7*3) || page[4] != 1<<(7*3) {
+		t.Errorf("GPFSEL1 = 0x%x, GPFSEL4 = 0x%x", page[1], page[4])
+	}
+	for number, input := range map[uint]bool{17: false, 18: true, 47: false, 4: true} {
+		chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(number)
+		if soc.isInput(mmapreg, chipid, gpioid) != input {
+			t.Errorf("GPIO %d isInput != %v", number, input)
+		}
+	}
+	// the second bank's set register is GPSET1 which sets bits in GPLEV1
+	mmapreg.write32(1, soc.setdataout, 1<<15)
+	if page[(bcm2835_gplev_+4)/BYTES_IN_UINT32] != 1<<15 {
+		t.Error("bank 1 does not map to GPSET1")
+	}
+	gpio := NewMMappedGPIOOrPanic(47, OUT)
+	if !GetStateOrPanic(gpio) {
+		t.Error("GPIO 47 should be high")
+	}
+	gpio.SetState(false)
+	if page[(bcm2835_gplev_+4)/BYTES_IN_UINT32] != 0 {
+		t.Error("GPCLR1 did not clear GPIO 47")
+	}
+	if err := NewMMappedGPIOOrPanic(4, IN).SetDebounce(true); err == nil {
+		t.Error("BCM2835 can not debounce")
+	}
+}
+
+func Test_InitMMappedGPIO(t *testing.T) {
+	useFakeGPIORegisters(t, "AM335x")
+	done := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() { done <- InitMMappedGPIO() }()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := NewMMappedGPIO(200, OUT); err == nil {
+		t.Error("AM335x has no GPIO 200")
+	}
+	if _, err := NewMMappedGPIOCollectionFactoryOrPanic().NewMMappedGPIO(128, OUT); err == nil {
+		t.Error("AM335x has no GPIO 128")
+	}
+
+	// on a computer without a supported SoC, we get an error instead of a panic
+	if cpuinfo, _ := GetCPUInfos(); len(cpuinfo["Hardware"]) > 0 {
+		return
+	}
+	orig := devicetree_compatible_path_
+	defer func() { devicetree_compatible_path_ = orig }()
+	devicetree_compatible_path_ = filepath.Join(t.TempDir(), "compatible")
+	mmapped_gpio_register_ = nil
+	if err := InitMMappedGPIO(); err == nil {
+		t.Error("InitMMappedGPIO should fail without a supported SoC")
+	}
+	if _, err := NewMMappedGPIO(2, OUT); err == nil {
+		t.Error("NewMMappedGPIO should fail without a supported SoC")
+	}
+	if _, err := NewMMappedGPIOCollectionFactory(); err == nil {
+		t.Error("NewMMappedGPIOCollectionFactory should fail without a supported SoC")
+	}
+}
@@ -6,19 +6,27 @@ import (
 	"reflect"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 )
 
-/// This ONLY works on the BeagleBone or similar AM335xx devices !!!
+/// This works on the BeagleBone (AM335x), AM57xx boards and the Raspberry Pi (BCM2835 and successors)
+/// see mmap_soc.go
 
 type mappedRegisters struct {
+	soc              *mmapSoC
 	memfd            *os.File
+	mappings         [][]byte
 	memgpiochipreg   [][]byte
 	memgpiochipreg32 [][]uint32
 	reglock          sync.Mutex
+	fake             bool                                        // registers are in memory, see mmapregs_fake.go
+	writehook        func(chipid int, offset uint, value uint32) // models the hardware for fake registers
+	debounceclaims   []uint32                                    // per gpiochip: gpios which set the current debounce time
 }
 
 var mmapped_gpio_register_ *mappedRegisters
+var mmapped_gpio_lock_ sync.Mutex
 
 const ( // AM335x Memory Addresses
 	omap4_gpio0_offset_          = 0x44E07000
@@ -46,8 +54,13 @@ const ( // AM335x Memory Addresses
 	BYTES_IN_UINT32              = 4 // bytes
 )
 
+const ( // AM335x debounce time is (DEBOUNCINGTIME + 1) * 31µs
+	debounce_time_unit_      = 31 * time.Microsecond
+	debounce_time_max_units_ = 256
+)
+
 func verifyAddrIsTIOmap4(addr uint) bool {
-	filename := fmt.Sprintf("/proc/device-tree/ocp/gpio@%x/compatible", addr)
+	filename := rootedPath(fmt.Sprintf("/proc/device-tree/ocp/gpio@%x/compatible", addr))
 	pf, err := os.OpenFile(filename, os.O_RDONLY, 0666)
 	if err != nil {
 		return false
@@ -80,48 +93,33 @@ func castByteSliceToUint32Slice(raw []byte) []uint32 {
 	return *(*[]uint32)(unsafe.Pointer(&header))
 }
 
-func newGPIORegMMap() (mmapreg *mappedRegisters, err error) {
-	//Verify our memory addresses are actually correct
-	if !(verifyAddrIsTIOmap4(omap4_gpio0_offset_) && verifyAddrIsTIOmap4(omap4_gpio1_offset_) && verifyAddrIsTIOmap4(omap4_gpio2_offset_) && verifyAddrIsTIOmap4(omap4_gpio3_offset_)) {
-		return nil, fmt.Errorf("Looks like we aren't on a AM33xx CPU! Please check your Datasheet and update the code (github) or stick to the SysFSGPIOs")
-	}
+func newGPIORegMMap(soc *mmapSoC) (mmapreg *mappedRegisters, err error) {
 	mmapreg = new(mappedRegisters)
-	mmapreg.memgpiochipreg = make([][]byte, 4)
-	mmapreg.memgpiochipreg32 = make([][]uint32, 4)
+	mmapreg.soc = soc
+	mmapreg.memgpiochipreg = make([][]byte, len(soc.banks))
+	mmapreg.memgpiochipreg32 = make([][]uint32, len(soc.banks))
 	//Now MemoryMap
-	mmapreg.memfd, err = os.OpenFile("/dev/mem", os.O_RDWR, 0666)
-	if err != nil {
-		return nil, err
-	}
-
-	mmapreg.memgpiochipreg[0], err = syscall.Mmap(int(mmapreg.memfd.Fd()), omap4_gpio0_offset_, gpio_pagesize_, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
-	if err != nil {
-		mmapreg.close()
-		return nil, err
-	}
-	mmapreg.memgpiochipreg32[0] = castByteSliceToUint32Slice(mmapreg.memgpiochipreg[0])
-
-	mmapreg.memgpiochipreg[1], err = syscall.Mmap(int(mmapreg.memfd.Fd()), omap4_gpio1_offset_, gpio_pagesize_, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
-	if err != nil {
-		mmapreg.close()
-		return nil, err
-	}
-	mmapreg.memgpiochipreg32[1] = castByteSliceToUint32Slice(mmapreg.memgpiochipreg[1])
-
-	mmapreg.memgpiochipreg[2], err = syscall.Mmap(int(mmapreg.memfd.Fd()), omap4_gpio2_offset_, gpio_pagesize_, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
+	mmapreg.memfd, err = os.OpenFile(soc.memdev, os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
-		mmapreg.close()
 		return nil, err
 	}
-	mmapreg.memgpiochipreg32[2] = castByteSliceToUint32Slice(mmapreg.memgpiochipreg[2])
 
-	mmapreg.memgpiochipreg[3], err = syscall.Mmap(int(mmapreg.memfd.Fd()), omap4_gpio3_offset_, gpio_pagesize_, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
-	if err != nil {
-		mmapreg.close()
-		return nil, err
+	// banks may share a page, map each page only once
+	mapped := make(map[int64][]byte)
+	for i, bank := range soc.banks {
+		page, inmap := mapped[bank.base]
+		if !inmap {
+			page, err = syscall.Mmap(int(mmapreg.memfd.Fd()), bank.base, soc.pagesize, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
+			if err != nil {
+				mmapreg.close()
+				return nil, err
+			}
+			mapped[bank.base] = page
+			mmapreg.mappings = append(mmapreg.mappings, page)
+		}
+		mmapreg.memgpiochipreg[i] = page[bank.regoffset:]
+		mmapreg.memgpiochipreg32[i] = castByteSliceToUint32Slice(page)[bank.regoffset/BYTES_IN_UINT32:]
 	}
-	mmapreg.memgpiochipreg32[3] = castByteSliceToUint32Slice(mmapreg.memgpiochipreg[3])
-
 	return mmapreg, nil
 }
 
@@ -129,21 +127,16 @@ func (mmapreg *mappedRegisters) close() {
 	if mmapreg == nil {
 		return
 	}
-	for i := 0; i < len(mmapreg.memgpiochipreg); i++ {
-		if mmapreg.memgpiochipreg[i] == nil {
-			continue
-		}
-		//dh := (*reflect.SliceHeader)(unsafe.Pointer(mmapreg.memgpiochipreg[i]))
-		_, _, errno := syscall.Syscall(syscall.SYS_MUNMAP, *(*uintptr)(unsafe.Pointer(&mmapreg.memgpiochipreg[i])), unsafe.Sizeof(mmapreg.memgpiochipreg[i]), 0)
-		if errno != 0 {
-			panic(syscall.Errno(errno))
+	for _, page := range mmapreg.mappings {
+		if err := syscall.Munmap(page); err != nil {
+			panic(err)
 		}
 	}
+	mmapreg.mappings = nil
 	mmapreg.memfd.Close()
-	mmapreg = nil
 }
 
-func (mmapreg *mappedRegisters) setDebounceTime(gpiochip int, dbt byte) error {
+func (mmapreg *mappedRegisters) checkDebounce(gpiochip int) error {
 	if mmapreg == nil {
 		return fmt.Errorf("mmapreg object does not exist")
 	}
@@ -153,27 +146,143 @@ func (mmapreg *mappedRegisters) setDebounceTime(gpiochip int, dbt byte) error {
 	if mmapreg.memgpiochipreg[gpiochip] == nil {
 		return fmt.Errorf("memgpiochipreg[%d] == nil", gpiochip)
 	}
-	mmapreg.memgpiochipreg[gpiochip][intgpio_debouncetime_] = dbt
+	if mmapreg.soc.debouncetime == 0 {
+		return fmt.Errorf("%s can not debounce GPIOs", mmapreg.soc.name)
+	}
 	return nil
 }
 
-func getgpiommap() *mappedRegisters {
+// enables or disables debouncing of one gpio,
+// disabling also releases its claim on the debounce time of the gpiochip
+func (mmapreg *mappedRegisters) setDebounce(gpiochip int, gpioid uint, enable bool) error {
+	if err := mmapreg.checkDebounce(gpiochip); err != nil {
+		return err
+	}
+	mmapreg.reglock.Lock()
+	defer mmapreg.reglock.Unlock()
+	mmapreg.setDebounceEnableLocked(gpiochip, gpioid, enable)
+	return nil
+}
+
+func (mmapreg *mappedRegisters) setDebounceEnableLocked(gpiochip int, gpioid uint, enable bool) {
+	debounce_state := mmapreg.read32(gpiochip, mmapreg.soc.debounceenable)
+	if enable {
+		debounce_state |= 1 << gpioid
+	} else {
+		debounce_state &= ^uint32(1 << gpioid)
+		if mmapreg.debounceclaims != nil {
+			mmapreg.debounceclaims[gpiochip] &= ^uint32(1 << gpioid)
+		}
+	}
+	mmapreg.write32(gpiochip, mmapreg.soc.debounceenable, debounce_state)
+}
+
+// The debounce time is shared by all gpios of a gpiochip.
+// Each gpio which sets a debounce time claims it until it disables debouncing,
+// a different time is refused while another gpio of the same gpiochip holds a claim.
+func (mmapreg *mappedRegisters) setDebounceTime(gpiochip int, gpioid uint, debounce time.Duration) error {
+	if err := mmapreg.checkDebounce(gpiochip); err != nil {
+		return err
+	}
+	if debounce < debounce_time_unit_ || debounce > debounce_time_unit_*debounce_time_max_units_ {
+		return fmt.Errorf("debounce time %v is out of range [%v,%v]", debounce, debounce_time_unit_, debounce_time_unit_*debounce_time_max_units_)
+	}
+	units := (debounce + debounce_time_unit_/2) / debounce_time_unit_
+	dbt := uint32(units - 1)
+	mmapreg.reglock.Lock()
+	defer mmapreg.reglock.Unlock()
+	if mmapreg.debounceclaims == nil {
+		mmapreg.debounceclaims = make([]uint32, len(mmapreg.memgpiochipreg32))
+	}
+	others := mmapreg.debounceclaims[gpiochip] &^ (1 << gpioid)
+	if current := mmapreg.read32(gpiochip, mmapreg.soc.debouncetime) & 0xFF; others != 0 && current != dbt {
+		return fmt.Errorf("gpiochip %d already debounces with %v for gpios 0x%08x, can not change it to %v", gpiochip, (time.Duration(current)+1)*debounce_time_unit_, others, units*debounce_time_unit_)
+	}
+	mmapreg.write32(gpiochip, mmapreg.soc.debouncetime, dbt)
+	mmapreg.debounceclaims[gpiochip] |= 1 << gpioid
+	mmapreg.setDebounceEnableLocked(gpiochip, gpioid, true)
+	return nil
+}
+
+func (mmapreg *mappedRegisters) getDebounceTime(gpiochip int) (time.Duration, error) {
+	if err := mmapreg.checkDebounce(gpiochip); err != nil {
+		return 0, err
+	}
+	return time.Duration(mmapreg.read32(gpiochip, mmapreg.soc.debouncetime)&0xFF+1) * debounce_time_unit_, nil
+}
+
+// offset is the byte offset of the 32bit register
+func (mmapreg *mappedRegisters) read32(chipid int, offset uint) uint32 {
+	return mmapreg.memgpiochipreg32[chipid][offset/BYTES_IN_UINT32]
+}
+
+// offset is the byte offset of the 32bit register
+func (mmapreg *mappedRegisters) write32(chipid int, offset uint, value uint32) {
+	if mmapreg.writehook != nil {
+		mmapreg.writehook(chipid, offset, value)
+		return
+	}
+	mmapreg.memgpiochipreg32[chipid][offset/BYTES_IN_UINT32] = value
+}
+
+// Set direction and export GPIO via sysfs.
+// Note that at least one gpio of each gpiochip has to be exported in sysfs,
+// otherwise the clock of the gpiochip remains gated and we receive SIGBUS when accessing its registers.
+// Fake registers have no clock, so we just set the direction in the registers.
+func (mmapreg *mappedRegisters) prepareGPIO(number uint, direction int) error {
+	chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(number)
+	if chipid >= len(mmapreg.memgpiochipreg32) {
+		return fmt.Errorf("GPIO %d does not exist on %s", number, mmapreg.soc.name)
+	}
+	if !mmapreg.fake {
+		gpio, err := NewSysfsGPIO(number, direction)
+		if err != nil {
+			return err
+		}
+		gpio.Close()
+		return nil
+	}
+	mmapreg.reglock.Lock()
+	defer mmapreg.reglock.Unlock()
+	mmapreg.soc.setInput(mmapreg, chipid, gpioid, direction != OUT)
+	return nil
+}
+
+// Detects the SoC and maps its GPIO registers.
+// NewMMappedGPIO and NewMMappedGPIOCollectionFactory call this for you,
+// call it yourself to find out early if MMappedGPIOs will work on this system.
+// Safe to call repeatedly and from several goroutines.
+func InitMMappedGPIO() error {
+	_, err := getgpiommap()
+	return err
+}
+
+func getgpiommap() (*mappedRegisters, error) {
+	mmapped_gpio_lock_.Lock()
+	defer mmapped_gpio_lock_.Unlock()
 	if mmapped_gpio_register_ == nil {
-		var err error
-		mmapped_gpio_register_, err = newGPIORegMMap()
+		soc, err := detectMMapSoC()
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
+		mmapreg, err := newGPIORegMMap(soc)
+		if err != nil {
+			return nil, err
+		}
+		mmapped_gpio_register_ = mmapreg
 	}
-	return mmapped_gpio_register_
+	return mmapped_gpio_register_, nil
 }
 
 //careful with this function! never call it
 //if there's a chance some routine might still be using fast gpios
 //If in Doubt: Never Call It
 func MMappedGPIOCleanup() {
+	mmapped_gpio_lock_.Lock()
+	defer mmapped_gpio_lock_.Unlock()
 	if mmapped_gpio_register_ != nil {
 		mmapped_gpio_register_.close()
+		mmapped_gpio_register_ = nil
 	}
 }
 
@@ -182,6 +291,3 @@ func calcGPIOAddrFromLinuxGPIONum(number uint) (chipid int, gpioid uint) {
 	gpioid = number % 32
 	return
 }
-
-//Todo: Try Set PinMux by mmapping pinmux_controlmodule_offset_
-// http://rampic.com/beagleboneblack/
@@ -0,0 +1,88 @@
+package bbhw
+
+import "fmt"
+
+// In-memory stand-in for the GPIO registers, so MMappedGPIO and MMappedGPIOCollectionFactory can be tested on any computer.
+//
+// mem is the backing store for the registers of all banks, i.e. soc.pagesize bytes for every distinct bank base address.
+// Pass nil to allocate it, or e.g. a mmapped tmpfile to look at the registers from outside.
+//
+// Writes to SETDATAOUT and CLEARDATAOUT set and clear bits in DATAOUT just like the hardware does.
+// DATAIN follows DATAOUT for outputs, use setFakeDataIn to drive inputs.
+func newFakeGPIORegisters(soc *mmapSoC, mem []byte) (mmapreg *mappedRegisters, err error) {
+	var pages []int64
+	for _, bank := range soc.banks {
+		if len(pages) == 0 || pages[len(pages)-1] != bank.base {
+			pages = append(pages, bank.base)
+		}
+	}
+	if mem == nil {
+		mem = make([]byte, len(pages)*soc.pagesize)
+	}
+	if len(mem) < len(pages)*soc.pagesize {
+		return nil, fmt.Errorf("fake registers of %s need %d bytes, got %d", soc.name, len(pages)*soc.pagesize, len(mem))
+	}
+	mmapreg = &mappedRegisters{soc: soc, fake: true}
+	mmapreg.memgpiochipreg = make([][]byte, len(soc.banks))
+	mmapreg.memgpiochipreg32 = make([][]uint32, len(soc.banks))
+	page := -1
+	for i, bank := range soc.banks {
+		if i == 0 || soc.banks[i-1].base != bank.base {
+			page++
+		}
+		raw := mem[page*soc.pagesize : (page+1)*soc.pagesize]
+		mmapreg.memgpiochipreg[i] = raw[bank.regoffset:]
+		mmapreg.memgpiochipreg32[i] = castByteSliceToUint32Slice(raw)[bank.regoffset/BYTES_IN_UINT32:]
+	}
+	// after reset all GPIOs are inputs
+	for chipid := range soc.banks {
+		for gpioid := uint(0); gpioid < 32; gpioid++ {
+			soc.setInput(mmapreg, chipid, gpioid, true)
+		}
+	}
+	mmapreg.writehook = mmapreg.fakeWrite
+	return mmapreg, nil
+}
+
+func (mmapreg *mappedRegisters) fakeWrite(chipid int, offset uint, value uint32) {
+	regs := mmapreg.memgpiochipreg32[chipid]
+	soc := mmapreg.soc
+	switch offset {
+	case soc.setdataout:
+		regs[soc.dataout/BYTES_IN_UINT32] |= value
+	case soc.cleardataout:
+		regs[soc.dataout/BYTES_IN_UINT32] &= ^value
+	default:
+		regs[offset/BYTES_IN_UINT32] = value
+	}
+	mmapreg.fakeOutputsToDataIn()
+}
+
+// the input buffer of an output pin sees the level we drive
+func (mmapreg *mappedRegisters) fakeOutputsToDataIn() {
+	soc := mmapreg.soc
+	if soc.datain == soc.dataout {
+		return
+	}
+	for chipid, regs := range mmapreg.memgpiochipreg32 {
+		var outputs uint32
+		for gpioid := uint(0); gpioid < 32; gpioid++ {
+			if !soc.isInput(mmapreg, chipid, gpioid) {
+				outputs |= 1 << gpioid
+			}
+		}
+		datain := regs[soc.datain/BYTES_IN_UINT32]
+		regs[soc.datain/BYTES_IN_UINT32] = datain&^outputs | regs[soc.dataout/BYTES_IN_UINT32]&outputs
+	}
+}
+
+// drive the fake input given by its linux GPIO number
+func (mmapreg *mappedRegisters) setFakeDataIn(number uint, state bool) {
+	chipid, gpioid := calcGPIOAddrFromLinuxGPIONum(number)
+	register := &mmapreg.memgpiochipreg32[chipid][mmapreg.soc.datain/BYTES_IN_UINT32]
+	if state {
+		*register |= 1 << gpioid
+	} else {
+		*register &= ^uint32(1 << gpioid)
+	}
+}
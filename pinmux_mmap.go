@@ -0,0 +1,218 @@
+/// Author: Bernhard Tittelbach, btittelbach@github  (c) 2014
+
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+/// This ONLY works on the BeagleBone or similar AM335xx devices !!!
+/// see the AM335x Technical Reference Manual, chapter 9.2.2 and 9.3.1.50 conf_<module>_<pin>
+
+// Configuration of a pad as found in its conf register in the AM335x control module.
+//
+// Pull is one of BIAS_DISABLE, BIAS_PULLUP or BIAS_PULLDOWN.
+// MuxMode 7 is GPIO on all header pins.
+type PadConf struct {
+	MuxMode        uint
+	ReceiverEnable bool
+	Pull           int
+	SlowSlew       bool
+}
+
+const (
+	pad_conf_offset_           = 0x800
+	pad_conf_mmode_mask_       = 0x7
+	pad_conf_pull_disable_     = 1 << 3
+	pad_conf_pull_up_          = 1 << 4
+	pad_conf_receiver_enable_  = 1 << 5
+	pad_conf_slow_slew_        = 1 << 6
+	pinmux_controlmodule_size_ = 0x1000 //4KiB, all conf registers are in the first page
+	PAD_MUXMODE_GPIO           = 7
+)
+
+type mappedControlModule struct {
+	memfd   *os.File
+	reg     []byte
+	reg32   []uint32
+	reglock sync.Mutex
+}
+
+var mmapped_controlmodule_register_ *mappedControlModule
+var mmapped_controlmodule_lock_ sync.Mutex
+
+func newControlModuleMMap() (cm *mappedControlModule, err error) {
+	if !verifyAddrIsTIOmap4(omap4_gpio0_offset_) {
+		return nil, fmt.Errorf("Looks like we aren't on a AM33xx CPU! Can't access the pinmux control module")
+	}
+	cm = new(mappedControlModule)
+	cm.memfd, err = os.OpenFile("/dev/mem", os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	cm.reg, err = syscall.Mmap(int(cm.memfd.Fd()), pinmux_controlmodule_offset_, pinmux_controlmodule_size_, syscall.PROT_WRITE|syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		cm.memfd.Close()
+		return nil, err
+	}
+	cm.reg32 = castByteSliceToUint32Slice(cm.reg)
+	return cm, nil
+}
+
+func getcontrolmodulemmap() (*mappedControlModule, error) {
+	mmapped_controlmodule_lock_.Lock()
+	defer mmapped_controlmodule_lock_.Unlock()
+	if mmapped_controlmodule_register_ == nil {
+		cm, err := newControlModuleMMap()
+		if err != nil {
+			return nil, err
+		}
+		mmapped_controlmodule_register_ = cm
+	}
+	return mmapped_controlmodule_register_, nil
+}
+
+func (cm *mappedControlModule) close() {
+	if cm == nil {
+		return
+	}
+	syscall.Munmap(cm.reg)
+	cm.memfd.Close()
+}
+
+/// ---------- PadConf ---------------
+
+func decodePadConf(value uint32) (conf PadConf) {
+	conf.MuxMode = uint(value & pad_conf_mmode_mask_)
+	conf.ReceiverEnable = value&pad_conf_receiver_enable_ > 0
+	conf.SlowSlew = value&pad_conf_slow_slew_ > 0
+	if value&pad_conf_pull_disable_ > 0 {
+		conf.Pull = BIAS_DISABLE
+	} else if value&pad_conf_pull_up_ > 0 {
+		conf.Pull = BIAS_PULLUP
+	} else {
+		conf.Pull = BIAS_PULLDOWN
+	}
+	return
+}
+
+func (conf PadConf) encode() (value uint32, err error) {
+	if conf.MuxMode > pad_conf_mmode_mask_ {
+		return 0, fmt.Errorf("MuxMode %d is out of range [0,7]", conf.MuxMode)
+	}
+	value = uint32(conf.MuxMode)
+	switch conf.Pull {
+	case BIAS_DISABLE:
+		value |= pad_conf_pull_disable_
+	case BIAS_PULLUP:
+		value |= pad_conf_pull_up_
+	case BIAS_PULLDOWN:
+	default:
+		return 0, fmt.Errorf("Invalid Pull %d, use BIAS_DISABLE, BIAS_PULLUP or BIAS_PULLDOWN", conf.Pull)
+	}
+	if conf.ReceiverEnable {
+		value |= pad_conf_receiver_enable_
+	}
+	if conf.SlowSlew {
+		value |= pad_conf_slow_slew_
+	}
+	return value, nil
+}
+
+func findPadOffsetByPin(name string) (offset uint, err error) {
+	var pin *HeaderPin
+	if pin, err = LookupHeaderPin(name); err != nil {
+		return
+	}
+	if pin.PadOffset < 0 {
+		return 0, fmt.Errorf("Pin %s has no pad conf register", pin.Name)
+	}
+	return uint(pin.PadOffset), nil
+}
+
+func findPadOffsetByGPIO(number uint) (offset uint, err error) {
+	var pin *HeaderPin
+	if pin, err = LookupHeaderPinByGPIO(number); err != nil {
+		return
+	}
+	return uint(pin.PadOffset), nil
+}
+
+func getPadConfAtOffset(offset uint) (conf PadConf, err error) {
+	cm, err := getcontrolmodulemmap()
+	if err != nil {
+		return
+	}
+	return decodePadConf(cm.reg32[(pad_conf_offset_+offset)/BYTES_IN_UINT32]), nil
+}
+
+// Read the pad conf register of a header pin, e.g. "P9_12"
+func GetPadConf(pin string) (conf PadConf, err error) {
+	offset, err := findPadOffsetByPin(pin)
+	if err != nil {
+		return
+	}
+	return getPadConfAtOffset(offset)
+}
+
+// Write the pad conf register of a header pin, e.g. "P9_12"
+//
+// Note that the AM335x only accepts writes to the control module in privileged mode.
+// Depending on your kernel, writes from userspace may be silently ignored,
+// so we read the register back and return an error if the change did not stick.
+// In that case use a DeviceTreeOverlay (see dtslots.go) instead.
+func SetPadConf(pin string, conf PadConf) error {
+	offset, err := findPadOffsetByPin(pin)
+	if err != nil {
+		return err
+	}
+	value, err := conf.encode()
+	if err != nil {
+		return err
+	}
+	cm, err := getcontrolmodulemmap()
+	if err != nil {
+		return err
+	}
+	idx := (pad_conf_offset_ + offset) / BYTES_IN_UINT32
+	cm.reglock.Lock()
+	defer cm.reglock.Unlock()
+	cm.reg32[idx] = value
+	if readback := cm.reg32[idx]; readback&0x7F != value {
+		return fmt.Errorf("Write to pad conf register of %s was ignored (wrote 0x%x, read 0x%x). Use a DeviceTreeOverlay instead", pin, value, readback)
+	}
+	return nil
+}
+
+// Convenience wrapper around GetPadConf and SetPadConf that only changes the mux mode
+func SetPadMuxMode(pin string, muxmode uint) error {
+	conf, err := GetPadConf(pin)
+	if err != nil {
+		return err
+	}
+	conf.MuxMode = muxmode
+	return SetPadConf(pin, conf)
+}
+
+// Convenience wrapper around GetPadConf and SetPadConf that only changes the pull resistor
+// Use BIAS_DISABLE, BIAS_PULLUP or BIAS_PULLDOWN
+func SetPadPull(pin string, pull int) error {
+	conf, err := GetPadConf(pin)
+	if err != nil {
+		return err
+	}
+	conf.Pull = pull
+	return SetPadConf(pin, conf)
+}
+
+// careful with this function! never call it
+// if there's a chance some routine might still be using the pinmux functions
+func PinmuxCleanup() {
+	mmapped_controlmodule_lock_.Lock()
+	defer mmapped_controlmodule_lock_.Unlock()
+	mmapped_controlmodule_register_.close()
+	mmapped_controlmodule_register_ = nil
+}
@@ -0,0 +1,277 @@
+/// Author: Bernhard Tittelbach, btittelbach@github  (c) 2014
+
+package bbhw
+
+import (
+	"fmt"
+	"strings"
+)
+
+// One pin of the P8 or P9 header of the BeagleBone (Black).
+//
+// PadOffset is the offset of the conf register of the pad relative to the start of the pad conf registers (0x800) of the AM335x control module.
+// Modes lists the function of the pin in each of the 8 mux modes as named in the AM335x datasheet, mode 7 is always GPIO.
+// ClaimedBy is "hdmi", "hdmi-audio" or "emmc" if the pin is used on the BeagleBone Black unless the respective cape is disabled.
+type HeaderPin struct {
+	Name      string
+	GPIO      int // linux GPIO number, -1 if the pin is not connected to a GPIO
+	GPIOChip  int // GPIO bank, -1 if none
+	GPIOBit   int // bit within GPIO bank, -1 if none
+	PadOffset int // -1 if the pin has no pad (power, ADC)
+	Modes     [8]string
+	ADC       int // AIN number, -1 if the pin is not an analog input
+	ClaimedBy string
+}
+
+// All pins of the P8 and P9 header.
+//
+// P9_41 and P9_42 are each connected to two pads.
+// The second pad is listed as P9_41B and P9_42B. Never drive both pads of the same pin.
+var BeagleBoneHeaderPins = []HeaderPin{
+	powerPin("P8_1", "GND"),
+	powerPin("P8_2", "GND"),
+	headerPin("P8_3", 0x018, "emmc", "gpmc_ad6", "mmc1_dat6", "", "", "", "", "", "gpio1_6"),
+	headerPin("P8_4", 0x01C, "emmc", "gpmc_ad7", "mmc1_dat7", "", "", "", "", "", "gpio1_7"),
+	headerPin("P8_5", 0x008, "emmc", "gpmc_ad2", "mmc1_dat2", "", "", "", "", "", "gpio1_2"),
+	headerPin("P8_6", 0x00C, "emmc", "gpmc_ad3", "mmc1_dat3", "", "", "", "", "", "gpio1_3"),
+	headerPin("P8_7", 0x090, "", "gpmc_advn_ale", "", "timer4", "", "", "", "", "gpio2_2"),
+	headerPin("P8_8", 0x094, "", "gpmc_oen_ren", "", "timer7", "", "", "", "", "gpio2_3"),
+	headerPin("P8_9", 0x09C, "", "gpmc_be0n_cle", "", "timer5", "", "", "", "", "gpio2_5"),
+	headerPin("P8_10", 0x098, "", "gpmc_wen", "", "timer6", "", "", "", "", "gpio2_4"),
+	headerPin("P8_11", 0x034, "", "gpmc_ad13", "lcd_data18", "mmc1_dat5", "mmc2_dat1", "eqep2b_in", "pr1_mii0_txd1", "pr1_pru0_pru_r30_15", "gpio1_13"),
+	headerPin("P8_12", 0x030, "", "gpmc_ad12", "lcd_data19", "mmc1_dat4", "mmc2_dat0", "eqep2a_in", "pr1_mii0_txd2", "pr1_pru0_pru_r30_14", "gpio1_12"),
+	headerPin("P8_13", 0x024, "", "gpmc_ad9", "lcd_data22", "mmc1_dat1", "mmc2_dat5", "ehrpwm2b", "pr1_mii0_col", "", "gpio0_23"),
+	headerPin("P8_14", 0x028, "", "gpmc_ad10", "lcd_data21", "mmc1_dat2", "mmc2_dat6", "ehrpwm2_tripzone_input", "pr1_mii0_txen", "", "gpio0_26"),
+	headerPin("P8_15", 0x03C, "", "gpmc_ad15", "lcd_data16", "mmc1_dat7", "mmc2_dat3", "eqep2_strobe", "pr1_ecap0_ecap_capin_apwm_o", "pr1_pru0_pru_r31_15", "gpio1_15"),
+	headerPin("P8_16", 0x038, "", "gpmc_ad14", "lcd_data17", "mmc1_dat6", "mmc2_dat2", "eqep2_index", "pr1_mii0_txd0", "pr1_pru0_pru_r31_14", "gpio1_14"),
+	headerPin("P8_17", 0x02C, "", "gpmc_ad11", "lcd_data20", "mmc1_dat3", "mmc2_dat7", "ehrpwm0_synco", "pr1_mii0_txd3", "", "gpio0_27"),
+	headerPin("P8_18", 0x08C, "", "gpmc_clk", "lcd_memory_clk", "gpmc_wait1", "mmc2_clk", "pr1_mii1_crs", "pr1_mdio_mdclk", "mcasp0_fsr", "gpio2_1"),
+	headerPin("P8_19", 0x020, "", "gpmc_ad8", "lcd_data23", "mmc1_dat0", "mmc2_dat4", "ehrpwm2a", "pr1_mii_mt0_clk", "", "gpio0_22"),
+	headerPin("P8_20", 0x084, "emmc", "gpmc_csn2", "gpmc_be1n", "mmc1_cmd", "pr1_edio_data_in7", "pr1_edio_data_out7", "pr1_pru1_pru_r30_13", "pr1_pru1_pru_r31_13", "gpio1_31"),
+	headerPin("P8_21", 0x080, "emmc", "gpmc_csn1", "gpmc_clk", "mmc1_clk", "pr1_edio_data_in6", "pr1_edio_data_out6", "pr1_pru1_pru_r30_12", "pr1_pru1_pru_r31_12", "gpio1_30"),
+	headerPin("P8_22", 0x014, "emmc", "gpmc_ad5", "mmc1_dat5", "", "", "", "", "", "gpio1_5"),
+	headerPin("P8_23", 0x010, "emmc", "gpmc_ad4", "mmc1_dat4", "", "", "", "", "", "gpio1_4"),
+	headerPin("P8_24", 0x004, "emmc", "gpmc_ad1", "mmc1_dat1", "", "", "", "", "", "gpio1_1"),
+	headerPin("P8_25", 0x000, "emmc", "gpmc_ad0", "mmc1_dat0", "", "", "", "", "", "gpio1_0"),
+	headerPin("P8_26", 0x07C, "", "gpmc_csn0", "", "", "", "", "", "", "gpio1_29"),
+	headerPin("P8_27", 0x0E0, "hdmi", "lcd_vsync", "gpmc_a8", "gpmc_a1", "pr1_edio_data_in2", "pr1_edio_data_out2", "pr1_pru1_pru_r30_8", "pr1_pru1_pru_r31_8", "gpio2_22"),
+	headerPin("P8_28", 0x0E8, "hdmi", "lcd_pclk", "gpmc_a10", "pr1_mii0_crs", "pr1_edio_data_in4", "pr1_edio_data_out4", "pr1_pru1_pru_r30_10", "pr1_pru1_pru_r31_10", "gpio2_24"),
+	headerPin("P8_29", 0x0E4, "hdmi", "lcd_hsync", "gpmc_a9", "gpmc_a2", "pr1_edio_data_in3", "pr1_edio_data_out3", "pr1_pru1_pru_r30_9", "pr1_pru1_pru_r31_9", "gpio2_23"),
+	headerPin("P8_30", 0x0EC, "hdmi", "lcd_ac_bias_en", "gpmc_a11", "pr1_mii1_crs", "pr1_edio_data_in5", "pr1_edio_data_out5", "pr1_pru1_pru_r30_11", "pr1_pru1_pru_r31_11", "gpio2_25"),
+	headerPin("P8_31", 0x0D8, "hdmi", "lcd_data14", "gpmc_a18", "eqep1_index", "mcasp0_axr1", "uart5_rxd", "pr1_mii_mr0_clk", "uart5_ctsn", "gpio0_10"),
+	headerPin("P8_32", 0x0DC, "hdmi", "lcd_data15", "gpmc_a19", "eqep1_strobe", "mcasp0_ahclkx", "mcasp0_axr3", "pr1_mii0_rxdv", "uart5_rtsn", "gpio0_11"),
+	headerPin("P8_33", 0x0D4, "hdmi", "lcd_data13", "gpmc_a17", "eqep1b_in", "mcasp0_fsr", "mcasp0_axr3", "pr1_mii0_rxer", "uart4_rtsn", "gpio0_9"),
+	headerPin("P8_34", 0x0CC, "hdmi", "lcd_data11", "gpmc_a15", "ehrpwm1b", "mcasp0_ahclkr", "mcasp0_axr2", "pr1_mii0_rxd0", "uart3_rtsn", "gpio2_17"),
+	headerPin("P8_35", 0x0D0, "hdmi", "lcd_data12", "gpmc_a16", "eqep1a_in", "mcasp0_aclkr", "mcasp0_axr2", "pr1_mii0_rxlink", "uart4_ctsn", "gpio0_8"),
+	headerPin("P8_36", 0x0C8, "hdmi", "lcd_data10", "gpmc_a14", "ehrpwm1a", "mcasp0_axr0", "", "pr1_mii0_rxd1", "uart3_ctsn", "gpio2_16"),
+	headerPin("P8_37", 0x0C0, "hdmi", "lcd_data8", "gpmc_a12", "ehrpwm1_tripzone_input", "mcasp0_aclkx", "uart5_txd", "pr1_mii0_rxd3", "uart2_ctsn", "gpio2_14"),
+	headerPin("P8_38", 0x0C4, "hdmi", "lcd_data9", "gpmc_a13", "ehrpwm0_synco", "mcasp0_fsx", "uart5_rxd", "pr1_mii0_rxd2", "uart2_rtsn", "gpio2_15"),
+	headerPin("P8_39", 0x0B8, "hdmi", "lcd_data6", "gpmc_a6", "pr1_edio_data_in6", "eqep2_index", "pr1_edio_data_out6", "pr1_pru1_pru_r30_6", "pr1_pru1_pru_r31_6", "gpio2_12"),
+	headerPin("P8_40", 0x0BC, "hdmi", "lcd_data7", "gpmc_a7", "pr1_edio_data_in7", "eqep2_strobe", "pr1_edio_data_out7", "pr1_pru1_pru_r30_7", "pr1_pru1_pru_r31_7", "gpio2_13"),
+	headerPin("P8_41", 0x0B0, "hdmi", "lcd_data4", "gpmc_a4", "pr1_mii0_txd1", "eqep2a_in", "", "pr1_pru1_pru_r30_4", "pr1_pru1_pru_r31_4", "gpio2_10"),
+	headerPin("P8_42", 0x0B4, "hdmi", "lcd_data5", "gpmc_a5", "pr1_mii0_txd0", "eqep2b_in", "", "pr1_pru1_pru_r30_5", "pr1_pru1_pru_r31_5", "gpio2_11"),
+	headerPin("P8_43", 0x0A8, "hdmi", "lcd_data2", "gpmc_a2", "pr1_mii0_txd3", "ehrpwm2_tripzone_input", "", "pr1_pru1_pru_r30_2", "pr1_pru1_pru_r31_2", "gpio2_8"),
+	headerPin("P8_44", 0x0AC, "hdmi", "lcd_data3", "gpmc_a3", "pr1_mii0_txd2", "ehrpwm0_synco", "", "pr1_pru1_pru_r30_3", "pr1_pru1_pru_r31_3", "gpio2_9"),
+	headerPin("P8_45", 0x0A0, "hdmi", "lcd_data0", "gpmc_a0", "pr1_mii_mt0_clk", "ehrpwm2a", "", "pr1_pru1_pru_r30_0", "pr1_pru1_pru_r31_0", "gpio2_6"),
+	headerPin("P8_46", 0x0A4, "hdmi", "lcd_data1", "gpmc_a1", "pr1_mii0_txen", "ehrpwm2b", "", "pr1_pru1_pru_r30_1", "pr1_pru1_pru_r31_1", "gpio2_7"),
+
+	powerPin("P9_1", "GND"),
+	powerPin("P9_2", "GND"),
+	powerPin("P9_3", "DC_3.3V"),
+	powerPin("P9_4", "DC_3.3V"),
+	powerPin("P9_5", "VDD_5V"),
+	powerPin("P9_6", "VDD_5V"),
+	powerPin("P9_7", "SYS_5V"),
+	powerPin("P9_8", "SYS_5V"),
+	powerPin("P9_9", "PWR_BUT"),
+	powerPin("P9_10", "SYS_RESETn"),
+	headerPin("P9_11", 0x070, "", "gpmc_wait0", "gmii2_crs", "gpmc_csn4", "rmii2_crs_dv", "mmc1_sdcd", "pr1_mii1_col", "uart4_rxd", "gpio0_30"),
+	headerPin("P9_12", 0x078, "", "gpmc_be1n", "gmii2_col", "gpmc_csn6", "mmc2_dat3", "gpmc_dir", "pr1_mii1_rxlink", "mcasp0_aclkr", "gpio1_28"),
+	headerPin("P9_13", 0x074, "", "gpmc_wpn", "gmii2_rxerr", "gpmc_csn5", "rmii2_rxerr", "mmc2_sdcd", "pr1_mii1_txen", "uart4_txd", "gpio0_31"),
+	headerPin("P9_14", 0x048, "", "gpmc_a2", "gmii2_txd3", "rgmii2_td3", "mmc2_dat1", "gpmc_a18", "pr1_mii1_txd2", "ehrpwm1a", "gpio1_18"),
+	headerPin("P9_15", 0x040, "", "gpmc_a0", "gmii2_txen", "rgmii2_tctl", "rmii2_txen", "gpmc_a16", "pr1_mii_mt1_clk", "ehrpwm1_tripzone_input", "gpio1_16"),
+	headerPin("P9_16", 0x04C, "", "gpmc_a3", "gmii2_txd2", "rgmii2_td2", "mmc2_dat2", "gpmc_a19", "pr1_mii1_txd1", "ehrpwm1b", "gpio1_19"),
+	headerPin("P9_17", 0x15C, "", "spi0_cs0", "mmc2_sdwp", "i2c1_scl", "ehrpwm0_synci", "pr1_uart0_txd", "pr1_edio_data_in1", "pr1_edio_data_out1", "gpio0_5"),
+	headerPin("P9_18", 0x158, "", "spi0_d1", "mmc1_sdwp", "i2c1_sda", "ehrpwm0_tripzone_input", "pr1_uart0_rxd", "pr1_edio_data_in0", "pr1_edio_data_out0", "gpio0_4"),
+	headerPin("P9_19", 0x17C, "", "uart1_rtsn", "timer5", "dcan0_rx", "i2c2_scl", "spi1_cs1", "pr1_uart0_rts_n", "pr1_edc_latch1_in", "gpio0_13"),
+	headerPin("P9_20", 0x178, "", "uart1_ctsn", "timer6", "dcan0_tx", "i2c2_sda", "spi1_cs0", "pr1_uart0_cts_n", "pr1_edc_latch0_in", "gpio0_12"),
+	headerPin("P9_21", 0x154, "", "spi0_d0", "uart2_txd", "i2c2_scl", "ehrpwm0b", "pr1_uart0_rts_n", "pr1_edio_latch_in", "emu3", "gpio0_3"),
+	headerPin("P9_22", 0x150, "", "spi0_sclk", "uart2_rxd", "i2c2_sda", "ehrpwm0a", "pr1_uart0_cts_n", "pr1_edio_sof", "emu2", "gpio0_2"),
+	headerPin("P9_23", 0x044, "", "gpmc_a1", "gmii2_rxdv", "rgmii2_rctl", "mmc2_dat0", "gpmc_a17", "pr1_mii1_txd3", "ehrpwm0_synco", "gpio1_17"),
+	headerPin("P9_24", 0x184, "", "uart1_txd", "mmc2_sdwp", "dcan1_rx", "i2c1_scl", "", "pr1_uart0_txd", "pr1_pru0_pru_r31_16", "gpio0_15"),
+	headerPin("P9_25", 0x1AC, "hdmi-audio", "mcasp0_ahclkx", "eqep0_strobe", "mcasp0_axr3", "mcasp1_axr1", "emu4", "pr1_pru0_pru_r30_7", "pr1_pru0_pru_r31_7", "gpio3_21"),
+	headerPin("P9_26", 0x180, "", "uart1_rxd", "mmc1_sdwp", "dcan1_tx", "i2c1_sda", "", "pr1_uart0_rxd", "pr1_pru1_pru_r31_16", "gpio0_14"),
+	headerPin("P9_27", 0x1A4, "", "mcasp0_fsr", "eqep0b_in", "mcasp0_axr3", "mcasp1_fsx", "emu2", "pr1_pru0_pru_r30_5", "pr1_pru0_pru_r31_5", "gpio3_19"),
+	headerPin("P9_28", 0x19C, "hdmi-audio", "mcasp0_ahclkr", "ehrpwm0_synci", "mcasp0_axr2", "spi1_cs0", "ecap2_in_pwm2_out", "pr1_pru0_pru_r30_3", "pr1_pru0_pru_r31_3", "gpio3_17"),
+	headerPin("P9_29", 0x194, "hdmi-audio", "mcasp0_fsx", "ehrpwm0b", "", "spi1_d0", "mmc1_sdcd", "pr1_pru0_pru_r30_1", "pr1_pru0_pru_r31_1", "gpio3_15"),
+	headerPin("P9_30", 0x198, "", "mcasp0_axr0", "ehrpwm0_tripzone_input", "", "spi1_d1", "mmc2_sdcd", "pr1_pru0_pru_r30_2", "pr1_pru0_pru_r31_2", "gpio3_16"),
+	headerPin("P9_31", 0x190, "hdmi-audio", "mcasp0_aclkx", "ehrpwm0a", "", "spi1_sclk", "mmc0_sdcd", "pr1_pru0_pru_r30_0", "pr1_pru0_pru_r31_0", "gpio3_14"),
+	powerPin("P9_32", "VDD_ADC"),
+	adcPin("P9_33", 4),
+	powerPin("P9_34", "GNDA_ADC"),
+	adcPin("P9_35", 6),
+	adcPin("P9_36", 5),
+	adcPin("P9_37", 2),
+	adcPin("P9_38", 3),
+	adcPin("P9_39", 0),
+	adcPin("P9_40", 1),
+	headerPin("P9_41", 0x1B4, "", "xdma_event_intr1", "", "tclkin", "clkout2", "timer7", "pr1_pru0_pru_r31_16", "emu3", "gpio0_20"),
+	headerPin("P9_42", 0x164, "", "ecap0_in_pwm0_out", "uart3_txd", "spi1_cs1", "pr1_ecap0_ecap_capin_apwm_o", "spi1_sclk", "mmc0_sdwp", "xdma_event_intr2", "gpio0_7"),
+	powerPin("P9_43", "GND"),
+	powerPin("P9_44", "GND"),
+	powerPin("P9_45", "GND"),
+	powerPin("P9_46", "GND"),
+	headerPin("P9_41B", 0x1A8, "", "mcasp0_axr1", "eqep0_index", "", "mcasp1_axr0", "emu3", "pr1_pru0_pru_r30_6", "pr1_pru0_pru_r31_6", "gpio3_20"),
+	headerPin("P9_42B", 0x1A0, "", "mcasp0_aclkr", "eqep0a_in", "mcasp0_axr2", "mcasp1_aclkx", "mmc0_sdwp", "pr1_pru0_pru_r30_4", "pr1_pru0_pru_r31_4", "gpio3_18"),
+}
+
+// takes the pad functions of mux mode 0 to 7, the GPIO number is derived from mode 7
+func headerPin(name string, padoffset int, claimedby string, modes ...string) (pin HeaderPin) {
+	var chip, bit int
+	if _, err := fmt.Sscanf(modes[PAD_MUXMODE_GPIO], "gpio%d_%d", &chip, &bit); err != nil {
+		panic(fmt.Sprintf("pin %s: mode 7 is not a gpio", name))
+	}
+	pin = HeaderPin{Name: name, GPIO: chip*32 + bit, GPIOChip: chip, GPIOBit: bit, PadOffset: padoffset, ADC: -1, ClaimedBy: claimedby}
+	copy(pin.Modes[:], modes)
+	return
+}
+
+func powerPin(name, function string) HeaderPin {
+	return HeaderPin{Name: name, GPIO: -1, GPIOChip: -1, GPIOBit: -1, PadOffset: -1, Modes: [8]string{function}, ADC: -1}
+}
+
+func adcPin(name string, ain int) HeaderPin {
+	return HeaderPin{Name: name, GPIO: -1, GPIOChip: -1, GPIOBit: -1, PadOffset: -1, Modes: [8]string{fmt.Sprintf("ain%d", ain)}, ADC: ain}
+}
+
+// "p8_07" -> "P8_7"
+func normalizeHeaderPinName(name string) string {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if i := strings.Index(name, "_"); i > 0 {
+		if num := strings.TrimLeft(name[i+1:], "0"); num != "" {
+			name = name[:i+1] + num
+		}
+	}
+	return name
+}
+
+/// ---------- Lookup ---------------
+
+// Find a header pin by name, e.g. "P8_12". Also accepts "P8_03" or "p8_3"
+func LookupHeaderPin(name string) (pin *HeaderPin, err error) {
+	name = normalizeHeaderPinName(name)
+	for i := range BeagleBoneHeaderPins {
+		if BeagleBoneHeaderPins[i].Name == name {
+			return &BeagleBoneHeaderPins[i], nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown header pin %s, use names like P9_12", name)
+}
+
+// Find the header pin connected to a linux GPIO number
+func LookupHeaderPinByGPIO(number uint) (pin *HeaderPin, err error) {
+	for i := range BeagleBoneHeaderPins {
+		if BeagleBoneHeaderPins[i].GPIO == int(number) {
+			return &BeagleBoneHeaderPins[i], nil
+		}
+	}
+	return nil, fmt.Errorf("GPIO %d is not connected to a header pin", number)
+}
+
+// Find all header pins which provide a function (e.g. "ehrpwm1a", "uart4_txd" or "ain0") in any mux mode
+func LookupHeaderPinsByFunction(function string) (pins []*HeaderPin) {
+	for i := range BeagleBoneHeaderPins {
+		if _, err := BeagleBoneHeaderPins[i].FindMode(function); err == nil {
+			pins = append(pins, &BeagleBoneHeaderPins[i])
+		}
+	}
+	return
+}
+
+// Returns the mux mode in which the pin provides function, e.g. "ehrpwm1a"
+func (pin *HeaderPin) FindMode(function string) (mode uint, err error) {
+	function = strings.ToLower(function)
+	for m, f := range pin.Modes {
+		if f != "" && f == function {
+			return uint(m), nil
+		}
+	}
+	return 0, fmt.Errorf("Pin %s does not provide %s", pin.Name, function)
+}
+
+// Returns the linux GPIO number of a header pin, e.g. "P8_12"
+func FindGPIONumberByPin(name string) (number uint, err error) {
+	var pin *HeaderPin
+	if pin, err = LookupHeaderPin(name); err != nil {
+		return
+	}
+	if pin.GPIO < 0 {
+		return 0, fmt.Errorf("Pin %s is not connected to a GPIO", pin.Name)
+	}
+	return uint(pin.GPIO), nil
+}
+
+/// ---------- Constructors by Pin ---------------
+
+// Instantinate a new GPIO by header pin name, e.g. NewGPIOByPin("P8_12", OUT)
+// using newgpio to create the GPIO, e.g. SysfsGPIOBackend, ChardevGPIOBackend, MMappedGPIOBackend or FakeGPIOBackend
+// If newgpio is nil, SysfsGPIOBackend is used.
+func NewGPIOByPin(name string, direction int, newgpio ...GPIOBackend) (gpio GPIOControllablePin, err error) {
+	var number uint
+	if number, err = FindGPIONumberByPin(name); err != nil {
+		return
+	}
+	if len(newgpio) == 0 || newgpio[0] == nil {
+		return SysfsGPIOBackend(number, direction)
+	}
+	return newgpio[0](number, direction)
+}
+
+// Wrapper around NewGPIOByPin. Does not return an error but panics instead.
+func NewGPIOByPinOrPanic(name string, direction int, newgpio ...GPIOBackend) GPIOControllablePin {
+	gpio, err := NewGPIOByPin(name, direction, newgpio...)
+	if err != nil {
+		panic(err)
+	}
+	return gpio
+}
+
+// Same as NewGPIOByPin but part of a GPIOCollectionFactory
+func NewGPIOInCollectionByPin(gpiocf GPIOCollectionFactory, name string, direction int) (gpio GPIOControllablePinInCollection, err error) {
+	var number uint
+	if number, err = FindGPIONumberByPin(name); err != nil {
+		return
+	}
+	return gpiocf.NewGPIO(number, direction), nil
+}
+
+// Creates a GPIO given its linux GPIO number
+type GPIOBackend func(number uint, direction int) (GPIOControllablePin, error)
+
+func SysfsGPIOBackend(number uint, direction int) (GPIOControllablePin, error) {
+	gpio, err := NewSysfsGPIO(number, direction)
+	if err != nil {
+		return nil, err
+	}
+	return gpio, nil
+}
+
+func ChardevGPIOBackend(number uint, direction int) (GPIOControllablePin, error) {
+	gpio, err := NewChardevGPIO(number, direction)
+	if err != nil {
+		return nil, err
+	}
+	return gpio, nil
+}
+
+func MMappedGPIOBackend(number uint, direction int) (GPIOControllablePin, error) {
+	gpio, err := NewMMappedGPIO(number, direction)
+	if err != nil {
+		return nil, err
+	}
+	return gpio, nil
+}
+
+func FakeGPIOBackend(number uint, direction int) (GPIOControllablePin, error) {
+	return NewFakeGPIO(number, direction), nil
+}
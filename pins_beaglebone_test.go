@@ -0,0 +1,102 @@
+package bbhw
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_BeagleBoneHeaderPins(t *testing.T) {
+	names := make(map[string]bool)
+	gpios := make(map[int]string)
+	pads := make(map[int]string)
+	for _, pin := range BeagleBoneHeaderPins {
+		if names[pin.Name] {
+			t.Errorf("pin %s listed twice", pin.Name)
+		}
+		names[pin.Name] = true
+		if pin.GPIO < 0 {
+			continue
+		}
+		if other, dup := gpios[pin.GPIO]; dup {
+			t.Errorf("GPIO %d on %s and %s", pin.GPIO, other, pin.Name)
+		}
+		gpios[pin.GPIO] = pin.Name
+		if other, dup := pads[pin.PadOffset]; dup {
+			t.Errorf("pad 0x%x on %s and %s", pin.PadOffset, other, pin.Name)
+		}
+		pads[pin.PadOffset] = pin.Name
+	}
+	if len(names) != 2*46+2 {
+		t.Errorf("%d pins, want 94", len(names))
+	}
+	if len(gpios) != 69 {
+		t.Errorf("%d gpios, want 69", len(gpios))
+	}
+
+	// every pin of the sysfs PWM map must provide a pwm in some mux mode
+	for name := range pin_to_pwmchip_map_ {
+		pin, err := LookupHeaderPin(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		haspwm := false
+		for _, f := range pin.Modes {
+			haspwm = haspwm || (strings.Contains(f, "pwm") && !strings.Contains(f, "tripzone") && !strings.Contains(f, "sync"))
+		}
+		if !haspwm {
+			t.Errorf("%s has no pwm mode", name)
+		}
+	}
+}
+
+func Test_LookupHeaderPin(t *testing.T) {
+	pin, err := LookupHeaderPin("P8_12")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pin.GPIO != 44 || pin.GPIOChip != 1 || pin.GPIOBit != 12 || pin.PadOffset != 0x030 {
+		t.Errorf("P8_12 = %+v", pin)
+	}
+	if mode, err := pin.FindMode("eQEP2A_in"); err != nil || mode != 4 {
+		t.Errorf("P8_12 FindMode(eqep2a_in) = %d, %v", mode, err)
+	}
+	if pin2, _ := LookupHeaderPin("p8_012"); pin2 != pin {
+		t.Error("LookupHeaderPin does not normalize names")
+	}
+	if pin, _ := LookupHeaderPin("P9_39"); pin == nil || pin.ADC != 0 || pin.GPIO != -1 {
+		t.Errorf("P9_39 = %+v", pin)
+	}
+	if pin, _ := LookupHeaderPinByGPIO(66); pin == nil || pin.Name != "P8_7" {
+		t.Errorf("LookupHeaderPinByGPIO(66) = %+v", pin)
+	}
+	if pin, _ := LookupHeaderPin("P8_28"); pin.ClaimedBy != "hdmi" {
+		t.Errorf("P8_28 should be claimed by hdmi")
+	}
+	if pins := LookupHeaderPinsByFunction("ehrpwm1a"); len(pins) != 2 {
+		t.Errorf("ehrpwm1a on %d pins, want 2 (P8_36, P9_14)", len(pins))
+	}
+	if _, err := FindGPIONumberByPin("P9_1"); err == nil {
+		t.Error("P9_1 is GND")
+	}
+	if _, err := LookupHeaderPin("P10_1"); err == nil {
+		t.Error("P10_1 does not exist")
+	}
+}
+
+func Test_NewGPIOByPin(t *testing.T) {
+	gpio, err := NewGPIOByPin("P8_12", OUT, FakeGPIOBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fg := gpio.(*FakeGPIO); fg.name != "FakeGPIO(44)" {
+		t.Errorf("NewGPIOByPin(P8_12) created %s", fg.name)
+	}
+	if _, err := NewGPIOByPin("P9_2", OUT, FakeGPIOBackend); err == nil {
+		t.Error("P9_2 is GND")
+	}
+	gpiocf := NewFakeGPIOCollectionFactory()
+	if _, err := NewGPIOInCollectionByPin(gpiocf, "P9_12", IN); err != nil {
+		t.Error(err)
+	}
+}
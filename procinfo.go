@@ -17,7 +17,7 @@ import (
 func GetCPUInfos() (map[string][]string, error) {
 	returninfos := make(map[string][]string)
 
-	cpuinfo, err := os.OpenFile("/proc/cpuinfo", os.O_RDONLY|os.O_SYNC, 0666)
+	cpuinfo, err := os.OpenFile(rootedPath("/proc/cpuinfo"), os.O_RDONLY|os.O_SYNC, 0666)
 	if err != nil {
 		return nil, fmt.Errorf("Could not open /proc/cpuinfo")
 	}
@@ -0,0 +1,164 @@
+package bbhw
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Generates pulses on a GPIO output.
+//
+// A pulse switches the output away from the state it had when Run was called for the high duration,
+// then back for the low duration. Edges are scheduled relative to the start of Run, so delays don't add up.
+// Run can be cancelled through its context, also in the middle of a pulse.
+type PulseTrain struct {
+	gpio          GPIOControllablePin
+	high          time.Duration
+	low           time.Duration
+	busywait      bool
+	clock         Clock
+	betweenpulses func() bool // used by Step, returns true to stop before the next pulse
+}
+
+type PulseTrainStats struct {
+	Pulses     uint64        // pulses started, including one cut short by cancellation
+	Duration   time.Duration // from start to the end of the last low phase
+	MeanJitter time.Duration // mean lateness of the edges relative to their schedule
+	MaxJitter  time.Duration
+}
+
+type PulseTrainResult struct {
+	Stats PulseTrainStats
+	Err   error
+}
+
+func NewPulseTrain(gpio GPIOControllablePin, high, low time.Duration) (pt *PulseTrain, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	if high < 0 || low < 0 {
+		return nil, fmt.Errorf("pulse durations must not be negative")
+	}
+	return &PulseTrain{gpio: gpio, high: high, low: low, clock: SystemClock}, nil
+}
+
+// Wrapper around NewPulseTrain. Does not return an error but panics instead.
+func NewPulseTrainOrPanic(gpio GPIOControllablePin, high, low time.Duration) (pt *PulseTrain) {
+	pt, err := NewPulseTrain(gpio, high, low)
+	if err != nil {
+		panic(err)
+	}
+	return pt
+}
+
+// Spin instead of sleeping until the next edge.
+// Burns a CPU core, but allows pulses of a few µs with e.g. MMappedGPIO. Only applies to SystemClock.
+func (pt *PulseTrain) SetBusyWait(busywait bool) {
+	pt.busywait = busywait
+}
+
+// Pass nil to use SystemClock
+func (pt *PulseTrain) SetClock(clock Clock) {
+	pt.clock = clockOrSystemClock(clock)
+}
+
+// Emit count pulses, or pulses until ctx is cancelled if count is 0.
+// On cancellation the output returns to its idle state right away and ctx.Err() is returned.
+func (pt *PulseTrain) Run(ctx context.Context, count uint64) (stats PulseTrainStats, err error) {
+	if pt == nil {
+		panic("pt == nil")
+	}
+	idle, err := pt.gpio.GetState()
+	if err != nil {
+		return
+	}
+	var jittersum time.Duration
+	var edges int64
+	active := false
+	start := pt.clock.Now()
+	deadline := start
+	edge := func(state bool) error {
+		if err := pt.gpio.SetState(state); err != nil {
+			return err
+		}
+		active = state != idle
+		jitter := pt.clock.Now().Sub(deadline)
+		jittersum += jitter
+		edges++
+		if jitter > stats.MaxJitter {
+			stats.MaxJitter = jitter
+		}
+		return nil
+	}
+	defer func() {
+		if active {
+			if seterr := pt.gpio.SetState(idle); err == nil {
+				err = seterr
+			}
+		}
+		stats.Duration = pt.clock.Now().Sub(start)
+		if edges > 0 {
+			stats.MeanJitter = jittersum / time.Duration(edges)
+		}
+	}()
+	for count == 0 || stats.Pulses < count {
+		if err = pt.waitUntil(ctx, deadline); err != nil {
+			return
+		}
+		if pt.betweenpulses != nil && stats.Pulses > 0 && pt.betweenpulses() {
+			return
+		}
+		if err = edge(!idle); err != nil {
+			return
+		}
+		stats.Pulses++
+		deadline = deadline.Add(pt.high)
+		if err = pt.waitUntil(ctx, deadline); err != nil {
+			return
+		}
+		if err = edge(idle); err != nil {
+			return
+		}
+		deadline = deadline.Add(pt.low)
+	}
+	err = pt.waitUntil(ctx, deadline)
+	return
+}
+
+// Same as Run, but in the background. The channel receives the result once Run returns.
+func (pt *PulseTrain) Start(ctx context.Context, count uint64) <-chan PulseTrainResult {
+	result := make(chan PulseTrainResult, 1)
+	go func() {
+		stats, err := pt.Run(ctx, count)
+		result <- PulseTrainResult{stats, err}
+	}()
+	return result
+}
+
+func (pt *PulseTrain) waitUntil(ctx context.Context, deadline time.Time) error {
+	if pt.busywait && pt.clock == SystemClock {
+		for time.Now().Before(deadline) {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+		}
+		return ctx.Err()
+	}
+	d := deadline.Sub(pt.clock.Now())
+	if d <= 0 {
+		return ctx.Err()
+	}
+	if ctx.Done() == nil {
+		// can't be cancelled
+		pt.clock.Sleep(d)
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-pt.clock.After(d):
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package bbhw
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// output which takes 3µs of virtual time to switch
+type slowTestOutput struct {
+	*FakeGPIO
+	clock   *FakeClock
+	changes []time.Time
+}
+
+func (pin *slowTestOutput) SetState(state bool) error {
+	pin.clock.Advance(3 * time.Microsecond)
+	pin.changes = append(pin.changes, pin.clock.Now())
+	return pin.FakeGPIO.SetState(state)
+}
+
+func Test_PulseTrain(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	pin := &slowTestOutput{FakeGPIO: NewFakeGPIO(1, OUT), clock: clock}
+	if _, err := NewPulseTrain(pin, -time.Microsecond, time.Microsecond); err == nil {
+		t.Error("negative duration should be refused")
+	}
+	pt := NewPulseTrainOrPanic(pin, 10*time.Microsecond, 20*time.Microsecond)
+	pt.SetClock(clock)
+	stats, err := pt.Run(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pulses != 5 || len(pin.changes) != 10 || GetStateOrPanic(pin) {
+		t.Errorf("%d pulses with %d changes, state %v", stats.Pulses, len(pin.changes), GetStateOrPanic(pin))
+	}
+	for i := 1; i < len(pin.changes); i++ {
+		want := 10 * time.Microsecond
+		if i%2 == 0 {
+			want = 20 * time.Microsecond
+		}
+		if d := pin.changes[i].Sub(pin.changes[i-1]); d != want {
+			t.Errorf("edge %d after %v instead of %v", i, d, want)
+		}
+	}
+	if stats.MeanJitter != 3*time.Microsecond || stats.MaxJitter != 3*time.Microsecond {
+		t.Errorf("jitter mean %v max %v, expected 3µs", stats.MeanJitter, stats.MaxJitter)
+	}
+	if stats.Duration != 150*time.Microsecond {
+		t.Errorf("took %v instead of 150µs", stats.Duration)
+	}
+}
+
+func Test_PulseTrainCancel(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	pin := NewFakeGPIO(1, OUT)
+	pt := NewPulseTrainOrPanic(pin, time.Millisecond, time.Millisecond)
+	pt.SetClock(clock)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := pt.Start(ctx, 0)
+	// in the middle of the second pulse
+	waitFor(t, "first pulse", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Millisecond)
+	waitFor(t, "first low phase", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(time.Millisecond)
+	waitFor(t, "second pulse", func() bool { return clock.Waiters() == 1 })
+	if !GetStateOrPanic(pin) {
+		t.Error("output should be high during the pulse")
+	}
+	cancel()
+	res := <-result
+	if res.Err != context.Canceled || res.Stats.Pulses != 2 {
+		t.Errorf("%d pulses, error %v", res.Stats.Pulses, res.Err)
+	}
+	if GetStateOrPanic(pin) {
+		t.Error("output not back to idle after cancel")
+	}
+}
+
+func Test_Step(t *testing.T) {
+	pin := NewFakeGPIO(1, OUT)
+	pin.SetState(true)
+	if c, err := Step(pin, 3, 0, nil); c != 3 || err != nil {
+		t.Errorf("Step returned %d, %v", c, err)
+	}
+	if !GetStateOrPanic(pin) {
+		t.Error("Step should return to the initial state")
+	}
+	calls := 0
+	if c, _ := Step(pin, 10, time.Microsecond, func() bool { calls++; return calls == 2 }); c != 2 {
+		t.Errorf("abortcheck should stop Step after 2 steps, not %d", c)
+	}
+}
@@ -1,9 +1,25 @@
 package bbhw
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
 
 // PWM Pin Interface
 
+// Implemented by BBPWMPin, FakePWMPin and SoftPWM. Every method reports whether it succeeded.
+type PWMControllablePin interface {
+	SetPolarity(p bool) error
+	SetPWM(period, duty time.Duration) error
+	GetPWM() (period, duty time.Duration, err error)
+	DisablePWM() error
+	Close() error
+}
+
+// The old PWM interface without errors. Use NewPWMPinAdapter to get one from a PWMControllablePin.
 type PWMPin interface {
 	SetPolarity(p bool)
 	SetPWM(time.Duration, time.Duration)
@@ -12,45 +28,219 @@ type PWMPin interface {
 	Close()
 }
 
+/// ---------- Errors ---------------
+
+// duty and period can't be set, e.g. because duty > period
+type PWMInvalidSettingError struct {
+	Period time.Duration
+	Duty   time.Duration
+}
+
+func (e *PWMInvalidSettingError) Error() string {
+	return fmt.Sprintf("Invalid PWM setting: period %v, duty %v", e.Period, e.Duty)
+}
+
+// the PWM device has disappeared, e.g. because it was unexported or its overlay removed
+type PWMDeviceGoneError struct {
+	Path string
+	Err  error
+}
+
+func (e *PWMDeviceGoneError) Error() string {
+	return fmt.Sprintf("PWM device %s is gone: %v", e.Path, e.Err)
+}
+
+func (e *PWMDeviceGoneError) Unwrap() error {
+	return e.Err
+}
+
+// the kernel refused a value with EINVAL, e.g. a duty larger than the period currently set
+type PWMRejectedError struct {
+	Path  string
+	Value string
+	Err   error
+}
+
+func (e *PWMRejectedError) Error() string {
+	return fmt.Sprintf("Kernel rejected %s for %s: %v", e.Value, e.Path, e.Err)
+}
+
+func (e *PWMRejectedError) Unwrap() error {
+	return e.Err
+}
+
+func checkPWMSetting(period, duty time.Duration) error {
+	if period < 0 || duty < 0 || duty > period {
+		return &PWMInvalidSettingError{Period: period, Duty: duty}
+	}
+	return nil
+}
+
+// turns errors of sysfs file operations into PWMDeviceGoneError or PWMRejectedError where they apply
+func classifyPWMError(fd *os.File, value string, err error) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, syscall.EINVAL):
+		return &PWMRejectedError{Path: fd.Name(), Value: value, Err: err}
+	case errors.Is(err, syscall.ENODEV), errors.Is(err, syscall.ENOENT), errors.Is(err, os.ErrClosed):
+		return &PWMDeviceGoneError{Path: fd.Name(), Err: err}
+	}
+	return err
+}
+
+/// ---------- Adapters ---------------
+
+// Implements PWMPin on a PWMControllablePin. Errors are stored, use CheckErrorOccurred to find out about them.
+type PWMPinAdapter struct {
+	pwm PWMControllablePin
+	err error
+}
+
+func NewPWMPinAdapter(pwm PWMControllablePin) *PWMPinAdapter {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	return &PWMPinAdapter{pwm: pwm}
+}
+
+func (a *PWMPinAdapter) record(err error) {
+	if err != nil {
+		a.err = err
+	}
+}
+
+func (a *PWMPinAdapter) SetPolarity(p bool) {
+	a.record(a.pwm.SetPolarity(p))
+}
+
+func (a *PWMPinAdapter) SetPWM(period, duty time.Duration) {
+	a.record(a.pwm.SetPWM(period, duty))
+}
+
+// returns zero durations if they could not be read
+func (a *PWMPinAdapter) GetPWM() (period, duty time.Duration) {
+	period, duty, err := a.pwm.GetPWM()
+	a.record(err)
+	return
+}
+
+func (a *PWMPinAdapter) DisablePWM() {
+	a.record(a.pwm.DisablePWM())
+}
+
+func (a *PWMPinAdapter) Close() {
+	a.record(a.pwm.Close())
+}
+
+// Returns the last error and clears it
+func (a *PWMPinAdapter) CheckErrorOccurred() error {
+	err := a.err
+	a.err = nil
+	return err
+}
+
+// Implements PWMControllablePin on an old PWMPin.
+// Errors are returned if the PWMPin has a CheckErrorOccurred method, like PWMPinAdapter.
+type pwmPinWithErrors struct {
+	pwm PWMPin
+}
+
+func NewPWMControllablePin(pwm PWMPin) PWMControllablePin {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	if a, ok := pwm.(*PWMPinAdapter); ok {
+		return a.pwm
+	}
+	return pwmPinWithErrors{pwm}
+}
+
+func (w pwmPinWithErrors) check() error {
+	if checker, ok := w.pwm.(interface{ CheckErrorOccurred() error }); ok {
+		return checker.CheckErrorOccurred()
+	}
+	return nil
+}
+
+func (w pwmPinWithErrors) SetPolarity(p bool) error {
+	w.pwm.SetPolarity(p)
+	return w.check()
+}
+
+func (w pwmPinWithErrors) SetPWM(period, duty time.Duration) error {
+	if err := checkPWMSetting(period, duty); err != nil {
+		return err
+	}
+	w.pwm.SetPWM(period, duty)
+	return w.check()
+}
+
+func (w pwmPinWithErrors) GetPWM() (period, duty time.Duration, err error) {
+	period, duty = w.pwm.GetPWM()
+	err = w.check()
+	return
+}
+
+func (w pwmPinWithErrors) DisablePWM() error {
+	w.pwm.DisablePWM()
+	return w.check()
+}
+
+func (w pwmPinWithErrors) Close() error {
+	w.pwm.Close()
+	return w.check()
+}
+
 /// --- Interface Functions
 
-func SetStepperRPM(pwm PWMPin, rpm, stepsperrot float64) {
-	SetPWMFreqDuty(pwm, rpm*stepsperrot/60.0, 0.1)
+func SetStepperRPM(pwm PWMControllablePin, rpm, stepsperrot float64) error {
+	return SetPWMFreqDuty(pwm, rpm*stepsperrot/60.0, 0.1)
 }
 
-func GetStepperRPM(pwm PWMPin, stepsperrot float64) float64 {
-	freq_hz, _ := GetPWMFreqDuty(pwm)
-	return freq_hz / stepsperrot * 60.0
+func GetStepperRPM(pwm PWMControllablePin, stepsperrot float64) (float64, error) {
+	freq_hz, _, err := GetPWMFreqDuty(pwm)
+	return freq_hz / stepsperrot * 60.0, err
 }
 
-func SetPWMFreq(pwm PWMPin, freq_hz float64) {
-	SetPWMFreqDuty(pwm, freq_hz, 0.5)
+func SetPWMFreq(pwm PWMControllablePin, freq_hz float64) error {
+	return SetPWMFreqDuty(pwm, freq_hz, 0.5)
 }
 
-func SetPWMFreqDuty(pwm PWMPin, freq_hz, fraction float64) {
+func SetPWMFreqDuty(pwm PWMControllablePin, freq_hz, fraction float64) error {
 	if fraction > 1.0 {
 		fraction = 1.0
 	} else if fraction < 0.0 {
 		fraction = 0.0
 	}
+	if freq_hz <= 0 {
+		return fmt.Errorf("Invalid PWM frequency %f Hz", freq_hz)
+	}
 	period := float64(time.Second) / freq_hz
-	pwm.SetPWM(time.Duration(period), time.Duration(period*fraction))
+	return pwm.SetPWM(time.Duration(period), time.Duration(period*fraction))
 }
 
-func GetPWMFreqDuty(pwm PWMPin) (freq_hz, fraction float64) {
-	period, duty := pwm.GetPWM()
+func GetPWMFreqDuty(pwm PWMControllablePin) (freq_hz, fraction float64, err error) {
+	period, duty, err := pwm.GetPWM()
+	if err != nil {
+		return
+	}
 	freq_hz = float64(time.Second) / float64(period)
 	fraction = float64(duty) / float64(period)
 	return
 }
 
 // set PWM duty to fraction between 0.0 and 1.0
-func SetDuty(pwm PWMPin, fraction float64) {
+func SetDuty(pwm PWMControllablePin, fraction float64) error {
 	if fraction > 1.0 {
 		fraction = 1.0
 	} else if fraction < 0.0 {
 		fraction = 0.0
 	}
-	period, _ := pwm.GetPWM()
-	pwm.SetPWM(time.Duration(period), time.Duration(float64(period.Nanoseconds())*fraction)*time.Nanosecond)
+	period, _, err := pwm.GetPWM()
+	if err != nil {
+		return err
+	}
+	return pwm.SetPWM(period, time.Duration(float64(period.Nanoseconds())*fraction)*time.Nanosecond)
 }
@@ -1,22 +1,27 @@
 package bbhw
 
-import "time"
+import (
+	"fmt"
+	"sync"
+	"time"
+)
 
-// Fake PWM for Testing
+// Fake PWM for Testing, safe to inspect while another goroutine drives it
 
 type FakePWMPin struct {
+	lock     sync.Mutex
 	name     string
 	period   time.Duration
 	duty     time.Duration
 	polarity bool
+	err      error
 }
 
 // Example: StepperPWM, err = NewBBBPWM("P9_16")
 func NewFakePWM(name string) (pwm *FakePWMPin, err error) {
 	pwm = new(FakePWMPin)
 	pwm.name = name
-	pwm.SetPolarity(false)
-	err = nil
+	err = pwm.SetPolarity(false)
 	return
 }
 
@@ -26,27 +31,56 @@ func NewFakePWMOrPanic(bbb_pin string) *FakePWMPin {
 	return pwm //no need to check error
 }
 
-func (pwm *FakePWMPin) SetPolarity(p bool) {
+func (pwm *FakePWMPin) SetPolarity(p bool) error {
+	pwm.lock.Lock()
+	defer pwm.lock.Unlock()
+	if pwm.err != nil {
+		return pwm.err
+	}
 	pwm.polarity = p
+	return nil
 }
 
-func (pwm *FakePWMPin) DisablePWM() {
+func (pwm *FakePWMPin) DisablePWM() error {
+	pwm.lock.Lock()
+	defer pwm.lock.Unlock()
+	if pwm.err != nil {
+		return pwm.err
+	}
 	pwm.duty = 0
 	pwm.polarity = false
+	return nil
 }
 
-func (pwm *FakePWMPin) SetPWM(period, duty time.Duration) {
-	if duty > period {
-		return
+func (pwm *FakePWMPin) SetPWM(period, duty time.Duration) error {
+	if err := checkPWMSetting(period, duty); err != nil {
+		return err
+	}
+	pwm.lock.Lock()
+	defer pwm.lock.Unlock()
+	if pwm.err != nil {
+		return pwm.err
 	}
 	pwm.duty = duty
 	pwm.period = period
+	return nil
+}
+
+func (pwm *FakePWMPin) GetPWM() (period, duty time.Duration, err error) {
+	pwm.lock.Lock()
+	defer pwm.lock.Unlock()
+	return pwm.period, pwm.duty, pwm.err
 }
 
-func (pwm *FakePWMPin) GetPWM() (period, duty time.Duration) {
-	return pwm.period, pwm.duty
+// All following calls return a PWMDeviceGoneError
+func (pwm *FakePWMPin) Close() error {
+	pwm.SimulateError(&PWMDeviceGoneError{Path: pwm.name, Err: fmt.Errorf("closed")})
+	return nil
 }
 
-func (pwm *FakePWMPin) Close() {
-	pwm = nil
+// All following calls return err, e.g. a PWMDeviceGoneError. nil makes the fake work again.
+func (pwm *FakePWMPin) SimulateError(err error) {
+	pwm.lock.Lock()
+	defer pwm.lock.Unlock()
+	pwm.err = err
 }
@@ -0,0 +1,265 @@
+package bbhw
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Software PWM on any GPIO output
+
+// Drives the edges of any number of SoftPWM channels from a single goroutine, which is locked to an OS thread.
+// With SystemClock the last part of every wait is spent spinning instead of sleeping,
+// which gives µs resolution with fast GPIOs like MMappedGPIO.
+type SoftPWMScheduler struct {
+	clock    Clock
+	spin     time.Duration
+	lock     sync.Mutex
+	channels []*SoftPWM
+	running  bool
+	wake     chan struct{}
+}
+
+// Implements PWMControllablePin by toggling a GPIO. Changes of period and duty take effect at the start of the next period.
+type SoftPWM struct {
+	sched         *SoftPWMScheduler
+	gpio          GPIOControllablePin
+	period        time.Duration // of the current cycle
+	duty          time.Duration
+	pendingperiod time.Duration // set by SetPWM
+	pendingduty   time.Duration
+	polarity      bool
+	cyclestart    time.Time
+	level         bool // physical state of the gpio
+	nextedge      time.Time
+	err           error
+}
+
+// time spent spinning before an edge, with SystemClock
+const softpwm_default_spin_ = 50 * time.Microsecond
+
+// Pass nil as clock to use SystemClock
+func NewSoftPWMScheduler(clock Clock) *SoftPWMScheduler {
+	return &SoftPWMScheduler{clock: clockOrSystemClock(clock), spin: softpwm_default_spin_, wake: make(chan struct{}, 1)}
+}
+
+// How long to spin before each edge instead of sleeping. 0 never spins, which saves CPU but adds the wakeup latency of the OS.
+func (sched *SoftPWMScheduler) SetSpinTime(spin time.Duration) {
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.spin = spin
+	sched.notify()
+}
+
+// Add a PWM channel on gpio, which starts out disabled
+func (sched *SoftPWMScheduler) NewSoftPWM(gpio GPIOControllablePin) (pwm *SoftPWM, err error) {
+	if gpio == nil {
+		panic("gpio == nil")
+	}
+	pwm = &SoftPWM{sched: sched, gpio: gpio}
+	if err = gpio.SetState(false); err != nil {
+		return nil, err
+	}
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	sched.channels = append(sched.channels, pwm)
+	if !sched.running {
+		sched.running = true
+		go sched.run()
+	}
+	return pwm, nil
+}
+
+// SoftPWM with a scheduler of its own. Use NewSoftPWMScheduler and SoftPWMScheduler.NewSoftPWM for many channels.
+func NewSoftPWM(gpio GPIOControllablePin, clock Clock) (pwm *SoftPWM, err error) {
+	return NewSoftPWMScheduler(clock).NewSoftPWM(gpio)
+}
+
+// Wrapper around NewSoftPWM. Does not return an error but panics instead.
+func NewSoftPWMOrPanic(gpio GPIOControllablePin, clock Clock) *SoftPWM {
+	pwm, err := NewSoftPWM(gpio, clock)
+	if err != nil {
+		panic(err)
+	}
+	return pwm
+}
+
+// must be called with sched.lock held
+func (sched *SoftPWMScheduler) notify() {
+	select {
+	case sched.wake <- struct{}{}:
+	default:
+	}
+}
+
+// runs until the last channel is closed
+func (sched *SoftPWMScheduler) run() {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	for {
+		sched.lock.Lock()
+		if len(sched.channels) == 0 {
+			sched.running = false
+			sched.lock.Unlock()
+			return
+		}
+		now := sched.clock.Now()
+		var next time.Time
+		for _, pwm := range sched.channels {
+			pwm.advance(now)
+			if !pwm.nextedge.IsZero() && (next.IsZero() || pwm.nextedge.Before(next)) {
+				next = pwm.nextedge
+			}
+		}
+		spin := sched.spin
+		sched.lock.Unlock()
+		sched.waitUntil(next, spin)
+	}
+}
+
+// wait for the next edge, or until something changed
+func (sched *SoftPWMScheduler) waitUntil(next time.Time, spin time.Duration) {
+	if next.IsZero() {
+		<-sched.wake
+		return
+	}
+	d := next.Sub(sched.clock.Now())
+	if spin <= 0 || sched.clock != SystemClock {
+		if d <= 0 {
+			return
+		}
+		sched.sleep(d)
+		return
+	}
+	if d > spin && !sched.sleep(d-spin) {
+		return
+	}
+	for time.Now().Before(next) {
+		select {
+		case <-sched.wake:
+			return
+		default:
+		}
+	}
+}
+
+// returns false if woken up early
+func (sched *SoftPWMScheduler) sleep(d time.Duration) bool {
+	timer := sched.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-sched.wake:
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+// apply the edges which are due and find the next one
+// must be called with sched.lock held
+func (pwm *SoftPWM) advance(now time.Time) {
+	if pwm.cyclestart.IsZero() || pwm.period == 0 {
+		pwm.startCycle(now)
+	}
+	if pwm.period == 0 {
+		pwm.setLevel(false)
+		pwm.nextedge = time.Time{}
+		return
+	}
+	for end := pwm.cyclestart.Add(pwm.period); !now.Before(end); end = pwm.cyclestart.Add(pwm.period) {
+		if now.Sub(end) >= pwm.period {
+			// we are late by more than a period, don't try to catch up
+			end = now
+		}
+		pwm.startCycle(end)
+		if pwm.period == 0 {
+			pwm.setLevel(false)
+			pwm.nextedge = time.Time{}
+			return
+		}
+	}
+	dutyend := pwm.cyclestart.Add(pwm.duty)
+	if now.Before(dutyend) {
+		pwm.setLevel(true)
+		pwm.nextedge = dutyend
+	} else {
+		pwm.setLevel(false)
+		pwm.nextedge = pwm.cyclestart.Add(pwm.period)
+	}
+}
+
+func (pwm *SoftPWM) startCycle(start time.Time) {
+	pwm.cyclestart = start
+	pwm.period, pwm.duty = pwm.pendingperiod, pwm.pendingduty
+}
+
+// active is the logical state, polarity inverts it
+func (pwm *SoftPWM) setLevel(active bool) {
+	level := active != pwm.polarity
+	if level == pwm.level {
+		return
+	}
+	if err := pwm.gpio.SetState(level); err != nil {
+		pwm.err = err
+		return
+	}
+	pwm.level = level
+}
+
+// p == true inverts the output
+func (pwm *SoftPWM) SetPolarity(p bool) error {
+	pwm.sched.lock.Lock()
+	defer pwm.sched.lock.Unlock()
+	active := pwm.level != pwm.polarity
+	pwm.polarity = p
+	pwm.setLevel(active)
+	return pwm.err
+}
+
+// Also returns the last error the scheduler got from the gpio
+func (pwm *SoftPWM) SetPWM(period, duty time.Duration) error {
+	if err := checkPWMSetting(period, duty); err != nil {
+		return err
+	}
+	pwm.sched.lock.Lock()
+	defer pwm.sched.lock.Unlock()
+	pwm.pendingperiod, pwm.pendingduty = period, duty
+	pwm.sched.notify()
+	return pwm.err
+}
+
+func (pwm *SoftPWM) GetPWM() (period, duty time.Duration, err error) {
+	pwm.sched.lock.Lock()
+	defer pwm.sched.lock.Unlock()
+	return pwm.pendingperiod, pwm.pendingduty, pwm.err
+}
+
+// Sets duty to 0 and polarity to normal, like the other PWMControllablePins
+func (pwm *SoftPWM) DisablePWM() error {
+	pwm.sched.lock.Lock()
+	pwm.pendingduty = 0
+	pwm.sched.lock.Unlock()
+	return pwm.SetPolarity(false)
+}
+
+// Returns the last error of the gpio
+func (pwm *SoftPWM) CheckErrorOccurred() error {
+	pwm.sched.lock.Lock()
+	defer pwm.sched.lock.Unlock()
+	return pwm.err
+}
+
+// Stops the channel and sets the gpio to false. Does not close the gpio.
+func (pwm *SoftPWM) Close() error {
+	sched := pwm.sched
+	sched.lock.Lock()
+	defer sched.lock.Unlock()
+	for i, c := range sched.channels {
+		if c == pwm {
+			sched.channels = append(sched.channels[:i], sched.channels[i+1:]...)
+			break
+		}
+	}
+	sched.notify()
+	return pwm.gpio.SetState(false)
+}
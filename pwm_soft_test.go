@@ -0,0 +1,111 @@
+package bbhw
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// output which records when it changed, safe to use from the scheduler goroutine
+type levelRecorder struct {
+	*FakeGPIO
+	clock   Clock
+	lock    sync.Mutex
+	changes []time.Duration // since start
+	start   time.Time
+}
+
+func newLevelRecorder(clock Clock) *levelRecorder {
+	return &levelRecorder{FakeGPIO: NewFakeGPIO(1, OUT), clock: clock, start: clock.Now()}
+}
+
+func (rec *levelRecorder) SetState(state bool) error {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if state != rec.FakeGPIO.value {
+		rec.changes = append(rec.changes, rec.clock.Now().Sub(rec.start))
+	}
+	return rec.FakeGPIO.SetState(state)
+}
+
+func (rec *levelRecorder) GetState() (bool, error) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return rec.FakeGPIO.GetState()
+}
+
+func (rec *levelRecorder) Changes() []time.Duration {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	return append([]time.Duration(nil), rec.changes...)
+}
+
+// advances the clock in steps, waiting for the scheduler to be idle before each
+func stepSoftPWMClock(t *testing.T, clock *FakeClock, step time.Duration, n int) {
+	for i := 0; i < n; i++ {
+		waitFor(t, "scheduler", func() bool { return clock.Waiters() == 1 })
+		clock.Advance(step)
+	}
+	waitFor(t, "scheduler", func() bool { return clock.Waiters() == 1 })
+}
+
+func checkChanges(t *testing.T, name string, got []time.Duration, want ...time.Duration) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s changed at %v, expected %v", name, got, want)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%s changed at %v, expected %v", name, got, want)
+			return
+		}
+	}
+}
+
+func Test_SoftPWM(t *testing.T) {
+	const us = time.Microsecond
+	clock := NewFakeClock(time.Now())
+	sched := NewSoftPWMScheduler(clock)
+	out1, out2 := newLevelRecorder(clock), newLevelRecorder(clock)
+	pwm1, err := sched.NewSoftPWM(out1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwm2, _ := sched.NewSoftPWM(out2)
+	var _ PWMControllablePin = pwm1
+	pwm1.SetPWM(1000*us, 300*us)
+	pwm2.SetPWM(500*us, 100*us)
+	stepSoftPWMClock(t, clock, 100*us, 20)
+	checkChanges(t, "pwm1", out1.Changes(), 0, 300*us, 1000*us, 1300*us, 2000*us)
+	checkChanges(t, "pwm2", out2.Changes(), 0, 100*us, 500*us, 600*us, 1000*us, 1100*us, 1500*us, 1600*us, 2000*us)
+	if period, duty, _ := pwm1.GetPWM(); period != 1000*us || duty != 300*us {
+		t.Errorf("GetPWM returned %v, %v", period, duty)
+	}
+
+	// takes effect at the start of the next period
+	pwm2.SetPWM(400*us, 200*us)
+	stepSoftPWMClock(t, clock, 100*us, 10)
+	checkChanges(t, "pwm2", out2.Changes()[9:], 2100*us, 2500*us, 2700*us, 2900*us)
+
+	// pwm1 is in its high phase at 3ms
+	pwm1.SetPolarity(true)
+	if GetStateOrPanic(out1) {
+		t.Error("SetPolarity did not invert the output")
+	}
+	pwm1.DisablePWM()
+	pwm2.Close()
+	stepSoftPWMClock(t, clock, 100*us, 10)
+	if GetStateOrPanic(out1) || GetStateOrPanic(out2) {
+		t.Error("outputs should be low after DisablePWM and Close")
+	}
+	n := len(out1.Changes())
+	stepSoftPWMClock(t, clock, 100*us, 10)
+	if len(out1.Changes()) != n {
+		t.Error("disabled PWM still toggles")
+	}
+	pwm1.Close()
+	if pwm1.CheckErrorOccurred() != nil {
+		t.Error(pwm1.CheckErrorOccurred())
+	}
+}
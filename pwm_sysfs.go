@@ -5,7 +5,6 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"time"
 )
 
@@ -15,6 +14,8 @@ type BBPWMPin struct {
 	fd_period   *os.File
 	fd_duty     *os.File
 	fd_polarity *os.File
+	// pwmchip channels take "normal"/"inversed" as polarity, the 3.8 pwm_test driver 0/1
+	polarity_names bool
 }
 
 type pwmchip struct {
@@ -67,7 +68,7 @@ func findPWMTestDir(bbb_pin string) (tdir string, err error) {
 }
 
 func findPWMChipDir(chipid int) (string, error) {
-	chipdir := fmt.Sprintf("/sys/class/pwm/pwmchip%d/", chipid)
+	chipdir := filepath.Join(rootedPath(pwm_sysfs_class_dir_), fmt.Sprintf("pwmchip%d", chipid))
 	if fst, err := os.Stat(chipdir); err == nil && fst != nil && fst.IsDir() {
 		return chipdir, nil
 	} else {
@@ -90,44 +91,61 @@ func exportPWMonPWMChip(pwmchip_path string, pwmid int) (err error) {
 	return nil
 }
 
+func unexportPWMonPWMChip(pwmchip_path string, pwmid int) (err error) {
+	var unexportfile *os.File
+	unexportfile, err = os.OpenFile(filepath.Join(pwmchip_path, "unexport"), os.O_WRONLY|os.O_SYNC, 0666)
+	if err != nil {
+		return
+	}
+	defer unexportfile.Close()
+	_, err = unexportfile.WriteString(fmt.Sprintf("%d\n", pwmid))
+	return
+}
+
 func NewPWMChipPWM(chipid, pwmid int) (pwm *BBPWMPin, err error) {
 	var pwmchip_path string
 	pwmchip_path, err = findPWMChipDir(chipid)
 	if err != nil {
 		return
 	}
-	pwm_path := filepath.Join(pwmchip_path, fmt.Sprintf("pwm%d", pwmid))
-	if !doesPathExist(pwm_path) {
+	pwm_path := findPWMChannelDir(pwmchip_path, chipid, pwmid)
+	if pwm_path == "" {
 		//export pwm only if pwm is not alreay exported
 		//otherwise kernel will return error
 		if err = exportPWMonPWMChip(pwmchip_path, pwmid); err != nil {
 			return
 		}
+		if pwm_path = findPWMChannelDir(pwmchip_path, chipid, pwmid); pwm_path == "" {
+			return nil, fmt.Errorf("PWM %d on %s did not appear after export", pwmid, pwmchip_path)
+		}
 	}
-	pwm = new(BBPWMPin)
+	pwm = &BBPWMPin{polarity_names: true}
 	var pwm_enable *os.File
 	pwm_enable, err = os.OpenFile(filepath.Join(pwm_path, "/enable"), os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer pwm_enable.Close()
 	pwm_enable.WriteString("1\n")
 	pwm.fd_period, err = os.OpenFile(filepath.Join(pwm_path, "/period"), os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
-		return
+		return nil, err
 	}
 	pwm.fd_duty, err = os.OpenFile(filepath.Join(pwm_path, "/duty_cycle"), os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
 		pwm.fd_period.Close()
-		return
+		return nil, err
 	}
 	pwm.fd_polarity, err = os.OpenFile(filepath.Join(pwm_path, "/polarity"), os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
 		pwm.fd_period.Close()
 		pwm.fd_duty.Close()
-		return
+		return nil, err
+	}
+	if err = pwm.SetPolarity(false); err != nil {
+		pwm.Close()
+		return nil, err
 	}
-	pwm.SetPolarity(false)
 	return
 }
 
@@ -144,26 +162,29 @@ func NewBBBPWM(bbb_pin string) (pwm *BBPWMPin, err error) {
 	var pwm_enable *os.File
 	pwm_enable, err = os.OpenFile(pwm_path+"/enable", os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer pwm_enable.Close()
 	pwm_enable.WriteString("1\n")
 	pwm.fd_period, err = os.OpenFile(pwm_path+"/period", os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
-		return
+		return nil, err
 	}
 	pwm.fd_duty, err = os.OpenFile(pwm_path+"/duty", os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
 		pwm.fd_period.Close()
-		return
+		return nil, err
 	}
 	pwm.fd_polarity, err = os.OpenFile(pwm_path+"/polarity", os.O_RDWR|os.O_SYNC, 0666)
 	if err != nil {
 		pwm.fd_period.Close()
 		pwm.fd_duty.Close()
-		return
+		return nil, err
+	}
+	if err = pwm.SetPolarity(false); err != nil {
+		pwm.Close()
+		return nil, err
 	}
-	pwm.SetPolarity(false)
 	return
 }
 
@@ -177,104 +198,116 @@ func NewBBBPWMOrPanic(bbb_pin string) *BBPWMPin {
 	return pwm
 }
 
-func (pwm *BBPWMPin) SetPolarity(p bool) {
-	var val byte = '0'
-	if p {
-		val = '1'
+func (pwm *BBPWMPin) SetPolarity(p bool) error {
+	if pwm == nil {
+		panic("pwm == nil")
 	}
-	pwm.fd_polarity.Truncate(0)
-	pwm.fd_polarity.Write([]byte{val, '\n'})
+	var val string
+	switch {
+	case pwm.polarity_names && p:
+		val = "inversed"
+	case pwm.polarity_names:
+		val = "normal"
+	case p:
+		val = "1"
+	default:
+		val = "0"
+	}
+	return classifyPWMError(pwm.fd_polarity, val, writeSysfsString(pwm.fd_polarity, val))
 }
 
-func (pwm *BBPWMPin) DisablePWM() {
-	pwm.fd_duty.Truncate(0)
-	pwm.fd_duty.Write([]byte{'0', '\n'})
-	pwm.SetPolarity(false)
+// Sets duty to 0 and polarity to normal
+func (pwm *BBPWMPin) DisablePWM() error {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	if err := pwm.writeDuration(pwm.fd_duty, 0); err != nil {
+		return err
+	}
+	return pwm.SetPolarity(false)
 }
 
-func (pwm *BBPWMPin) SetPWM(period, duty time.Duration) {
-	if duty > period {
-		return
-	}
-	var buffer []byte = make([]byte, 32)
-	pwm.fd_period.Seek(0, 0)
-	numread, err := pwm.fd_period.Read(buffer)
-	oldperiod, err := strconv.ParseInt(string(buffer[0:numread-1]), 10, 64)
+func (pwm *BBPWMPin) readDuration(fd *os.File) (time.Duration, error) {
+	value, err := readSysfsInt(fd)
 	if err != nil {
-		pwm.fd_duty.Truncate(0)
-		pwm.fd_duty.WriteString("0\n")
+		return 0, classifyPWMError(fd, "", err)
 	}
+	return time.Duration(value) * time.Nanosecond, nil
+}
 
-	pwm.fd_duty.Truncate(0)
-	pwm.fd_period.Truncate(0)
+func (pwm *BBPWMPin) writeDuration(fd *os.File, d time.Duration) error {
+	return classifyPWMError(fd, fmt.Sprint(d.Nanoseconds()), writeSysfsInt(fd, d.Nanoseconds()))
+}
 
-	if period.Nanoseconds() > oldperiod {
-		pwm.fd_period.WriteString(fmt.Sprintf("%d\n", period.Nanoseconds()))
-		pwm.fd_duty.WriteString(fmt.Sprintf("%d\n", duty.Nanoseconds()))
-	} else {
-		pwm.fd_duty.WriteString(fmt.Sprintf("%d\n", duty.Nanoseconds()))
-		pwm.fd_period.WriteString(fmt.Sprintf("%d\n", period.Nanoseconds()))
+// Returns a PWMInvalidSettingError if duty > period.
+// The kernel refuses a duty larger than the period, so the order of the writes depends on whether the period grows or shrinks.
+func (pwm *BBPWMPin) SetPWM(period, duty time.Duration) error {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	if err := checkPWMSetting(period, duty); err != nil {
+		return err
+	}
+	oldperiod, err := pwm.readDuration(pwm.fd_period)
+	if err != nil {
+		return err
+	}
+	if period > oldperiod {
+		if err = pwm.writeDuration(pwm.fd_period, period); err != nil {
+			return err
+		}
+		return pwm.writeDuration(pwm.fd_duty, duty)
 	}
+	if err = pwm.writeDuration(pwm.fd_duty, duty); err != nil {
+		return err
+	}
+	return pwm.writeDuration(pwm.fd_period, period)
 }
 
-func (pwm *BBPWMPin) GetPWM() (period, duty time.Duration) {
-	var buffer []byte = make([]byte, 32)
-	pwm.fd_period.Seek(0, 0)
-	numread, err := pwm.fd_period.Read(buffer)
-	oldperiod, err := strconv.ParseInt(string(buffer[0:numread-1]), 10, 64)
-	if err == nil {
-		period = time.Duration(oldperiod) * time.Nanosecond
-	}
-	pwm.fd_duty.Seek(0, 0)
-	numread, err = pwm.fd_duty.Read(buffer)
-	oldduty, err := strconv.ParseInt(string(buffer[0:numread-1]), 10, 64)
-	if err == nil {
-		duty = time.Duration(oldduty) * time.Nanosecond
+func (pwm *BBPWMPin) GetPWM() (period, duty time.Duration, err error) {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	if period, err = pwm.readDuration(pwm.fd_period); err != nil {
+		return
 	}
+	duty, err = pwm.readDuration(pwm.fd_duty)
 	return
 }
 
 // set PWM duty to fraction between 0.0 and 1.0
-func (pwm *BBPWMPin) SetDuty(fraction float64) {
-	if fraction > 1.0 {
-		fraction = 1.0
-	} else if fraction < 0.0 {
-		fraction = 0.0
-	}
-	var buffer []byte = make([]byte, 32)
-	pwm.fd_period.Seek(0, 0)
-	numread, err := pwm.fd_period.Read(buffer)
-	period, err := strconv.ParseInt(string(buffer[0:numread-1]), 10, 64)
-	if err != nil {
-		panic(err)
-	}
-	pwm.fd_duty.Truncate(0)
-	pwm.fd_duty.WriteString(fmt.Sprintf("%d\n", uint(float64(period)*fraction)))
+func (pwm *BBPWMPin) SetDuty(fraction float64) error {
+	return SetDuty(pwm, fraction)
 }
 
-func (pwm *BBPWMPin) Close() {
-	pwm.fd_duty.Close()
-	pwm.fd_period.Close()
-	pwm.fd_polarity.Close()
-	pwm = nil
+func (pwm *BBPWMPin) Close() (err error) {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	for _, fd := range []*os.File{pwm.fd_duty, pwm.fd_period, pwm.fd_polarity} {
+		if closeerr := fd.Close(); err == nil {
+			err = closeerr
+		}
+	}
+	return
 }
 
-func (pwm *BBPWMPin) SetPWMFreq(freq_hz float64) {
-	SetPWMFreq(pwm, freq_hz)
+func (pwm *BBPWMPin) SetPWMFreq(freq_hz float64) error {
+	return SetPWMFreq(pwm, freq_hz)
 }
 
-func (pwm *BBPWMPin) SetPWMFreqDuty(freq_hz, fraction float64) {
-	SetPWMFreqDuty(pwm, freq_hz, fraction)
+func (pwm *BBPWMPin) SetPWMFreqDuty(freq_hz, fraction float64) error {
+	return SetPWMFreqDuty(pwm, freq_hz, fraction)
 }
 
-func (pwm *BBPWMPin) GetPWMFreqDuty() (freq_hz, fraction float64) {
+func (pwm *BBPWMPin) GetPWMFreqDuty() (freq_hz, fraction float64, err error) {
 	return GetPWMFreqDuty(pwm)
 }
 
-func (pwm *BBPWMPin) SetStepperRPM(rpm, stepsperrot float64) {
-	SetStepperRPM(pwm, rpm*stepsperrot/60.0, 0.1)
+func (pwm *BBPWMPin) SetStepperRPM(rpm, stepsperrot float64) error {
+	return SetStepperRPM(pwm, rpm, stepsperrot)
 }
 
-func (pwm *BBPWMPin) GetStepperRPM(stepsperrot float64) float64 {
+func (pwm *BBPWMPin) GetStepperRPM(stepsperrot float64) (float64, error) {
 	return GetStepperRPM(pwm, stepsperrot)
 }
@@ -0,0 +1,178 @@
+package bbhw
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Discovery of the PWM chips in /sys/class/pwm, on any SoC with a device tree
+
+var pwm_sysfs_class_dir_ = "/sys/class/pwm"
+var devicetree_base_dir_ = "/sys/firmware/devicetree/base"
+
+var pwm_chip_dir_regex_ = regexp.MustCompile(`^pwmchip(\d+)$`)
+
+type PWMChipInfo struct {
+	Chip       int      // N in /sys/class/pwm/pwmchipN
+	Path       string   // the pwmchipN directory
+	NPWM       int      // number of channels
+	Device     string   // name of the device, e.g. 48300200.pwm
+	DTNode     string   // path of the device-tree node, e.g. /ocp/epwmss@48300000/pwm@48300200, empty without device tree
+	Compatible []string // compatible strings of the device-tree node
+	Labels     []string // labels of the device-tree node, e.g. ehrpwm0, from __symbols__ and the label property
+	Channels   []PWMChannelInfo
+}
+
+type PWMChannelInfo struct {
+	Channel  int
+	Exported bool
+	Enabled  bool
+}
+
+// Lists all PWM chips, ordered by chip number
+func ListPWMChips() (chips []PWMChipInfo, err error) {
+	entries, err := os.ReadDir(rootedPath(pwm_sysfs_class_dir_))
+	if err != nil {
+		return nil, err
+	}
+	symbols := readDeviceTreeSymbols()
+	for _, entry := range entries {
+		m := pwm_chip_dir_regex_.FindStringSubmatch(entry.Name())
+		if m == nil {
+			continue
+		}
+		chipid, _ := strconv.Atoi(m[1])
+		var chip PWMChipInfo
+		if chip, err = readPWMChipInfo(chipid, symbols); err != nil {
+			return nil, err
+		}
+		chips = append(chips, chip)
+	}
+	sort.Slice(chips, func(i, j int) bool { return chips[i].Chip < chips[j].Chip })
+	return
+}
+
+// Finds a PWM chip by device-tree path, label or device name.
+// Example: FindPWMChip("ehrpwm0"), FindPWMChip("/ocp/epwmss@48300000/pwm@48300200"), FindPWMChip("3f20c000.pwm")
+func FindPWMChip(name string) (chip PWMChipInfo, err error) {
+	chips, err := ListPWMChips()
+	if err != nil {
+		return
+	}
+	for _, chip = range chips {
+		if chip.Device == name || (chip.DTNode != "" && chip.DTNode == name) {
+			return
+		}
+		for _, label := range chip.Labels {
+			if label == name {
+				return
+			}
+		}
+	}
+	return PWMChipInfo{}, fmt.Errorf("PWMChip %s Not Found", name)
+}
+
+// Opens channel pwmid of the PWM chip found by FindPWMChip.
+// Example: pwm, err := NewPWMChipPWMByName("ehrpwm1", 0)
+func NewPWMChipPWMByName(name string, pwmid int) (pwm *BBPWMPin, err error) {
+	chip, err := FindPWMChip(name)
+	if err != nil {
+		return
+	}
+	if pwmid < 0 || pwmid >= chip.NPWM {
+		return nil, fmt.Errorf("PWMChip %s has no channel %d", name, pwmid)
+	}
+	return NewPWMChipPWM(chip.Chip, pwmid)
+}
+
+func readPWMChipInfo(chipid int, symbols map[string][]string) (chip PWMChipInfo, err error) {
+	chip.Chip = chipid
+	chip.Path = filepath.Join(rootedPath(pwm_sysfs_class_dir_), fmt.Sprintf("pwmchip%d", chipid))
+	npwm, err := os.ReadFile(filepath.Join(chip.Path, "npwm"))
+	if err != nil {
+		return
+	}
+	if chip.NPWM, err = strconv.Atoi(strings.TrimSpace(string(npwm))); err != nil {
+		return
+	}
+	if device, linkerr := filepath.EvalSymlinks(filepath.Join(chip.Path, "device")); linkerr == nil {
+		chip.Device = filepath.Base(device)
+	}
+	if node, linkerr := filepath.EvalSymlinks(filepath.Join(chip.Path, "device", "of_node")); linkerr == nil {
+		chip.DTNode = deviceTreePath(node)
+		chip.Compatible = readDeviceTreeStrings(filepath.Join(node, "compatible"))
+		chip.Labels = append(chip.Labels, symbols[chip.DTNode]...)
+		chip.Labels = append(chip.Labels, readDeviceTreeStrings(filepath.Join(node, "label"))...)
+	}
+	for c := 0; c < chip.NPWM; c++ {
+		channel := PWMChannelInfo{Channel: c}
+		if pwmdir := findPWMChannelDir(chip.Path, chipid, c); pwmdir != "" {
+			channel.Exported = true
+			enable, _ := os.ReadFile(filepath.Join(pwmdir, "enable"))
+			channel.Enabled = strings.TrimSpace(string(enable)) == "1"
+		}
+		chip.Channels = append(chip.Channels, channel)
+	}
+	return
+}
+
+// the directory of an exported channel, pwmM or pwm-N:M depending on kernel and udev rules. Empty if not exported.
+func findPWMChannelDir(pwmchip_path string, chipid, pwmid int) string {
+	for _, name := range []string{fmt.Sprintf("pwm%d", pwmid), fmt.Sprintf("pwm-%d:%d", chipid, pwmid)} {
+		if path := filepath.Join(pwmchip_path, name); doesPathExist(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// turns a directory below devicetree_base_dir_ into a device-tree path
+func deviceTreePath(node string) string {
+	base, err := filepath.EvalSymlinks(rootedPath(devicetree_base_dir_))
+	if err != nil {
+		base = rootedPath(devicetree_base_dir_)
+	}
+	rel, err := filepath.Rel(base, node)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return node
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// device-tree string lists are separated and terminated by NUL
+func readDeviceTreeStrings(path string) (strs []string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	for _, s := range strings.Split(string(content), "\x00") {
+		if s != "" {
+			strs = append(strs, s)
+		}
+	}
+	return
+}
+
+// maps device-tree paths to the labels in __symbols__, which only exists if the tree was compiled with -@
+func readDeviceTreeSymbols() map[string][]string {
+	symbols := make(map[string][]string)
+	symdir := filepath.Join(rootedPath(devicetree_base_dir_), "__symbols__")
+	entries, err := os.ReadDir(symdir)
+	if err != nil {
+		return symbols
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if paths := readDeviceTreeStrings(filepath.Join(symdir, entry.Name())); len(paths) > 0 && strings.HasPrefix(paths[0], "/") {
+			symbols[paths[0]] = append(symbols[paths[0]], entry.Name())
+		}
+	}
+	return symbols
+}
@@ -0,0 +1,92 @@
+package bbhw
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// builds a sysfs tree with a BeagleBone ehrpwm and a Raspberry Pi pwm below a fake filesystem root
+func makeFakePWMSysfs(t *testing.T) {
+	root := useFakeFilesystemRoot(t, nil)
+	write := func(path, content string) {
+		writeFakeFile(t, root, path, content)
+	}
+	link := func(target, path string) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, target), path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	makechip := func(chip, device, node, compatible string) {
+		devdir := "sys/devices/platform/" + device
+		write(devdir+"/uevent", "")
+		write("sys/firmware/devicetree/base"+node+"/compatible", compatible)
+		link("sys/firmware/devicetree/base"+node, devdir+"/of_node")
+		link(devdir, "sys/class/pwm/"+chip+"/device")
+	}
+
+	makechip("pwmchip0", "ocp/48300000.epwmss/48300200.pwm", "/ocp/epwmss@48300000/pwm@48300200", "ti,am3352-ehrpwm\x00ti,am33xx-ehrpwm\x00")
+	write("sys/class/pwm/pwmchip0/npwm", "2\n")
+	write("sys/firmware/devicetree/base/__symbols__/ehrpwm0", "/ocp/epwmss@48300000/pwm@48300200\x00")
+	for _, f := range []string{"enable", "period", "duty_cycle"} {
+		write("sys/class/pwm/pwmchip0/pwm0/"+f, "1\n")
+	}
+	write("sys/class/pwm/pwmchip0/pwm0/polarity", "normal\n")
+	makechip("pwmchip2", "soc/20c000.pwm", "/soc/pwm@7e20c000", "brcm,bcm2835-pwm\x00")
+	write("sys/class/pwm/pwmchip2/npwm", "2\n")
+	write("sys/class/pwm/pwmchip2/pwm-2:1/enable", "0\n")
+	write("sys/class/pwm/pwmchip2/export", "")
+}
+
+func Test_ListPWMChips(t *testing.T) {
+	makeFakePWMSysfs(t)
+	chips, err := ListPWMChips()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chips) != 2 || chips[0].Chip != 0 || chips[1].Chip != 2 {
+		t.Fatalf("expected pwmchip0 and pwmchip2, got %+v", chips)
+	}
+	bb, rpi := chips[0], chips[1]
+	if bb.NPWM != 2 || bb.Device != "48300200.pwm" || bb.DTNode != "/ocp/epwmss@48300000/pwm@48300200" {
+		t.Errorf("wrong info for pwmchip0: %+v", bb)
+	}
+	if len(bb.Compatible) != 2 || bb.Compatible[0] != "ti,am3352-ehrpwm" || len(bb.Labels) != 1 || bb.Labels[0] != "ehrpwm0" {
+		t.Errorf("compatible %q, labels %q", bb.Compatible, bb.Labels)
+	}
+	if !bb.Channels[0].Exported || !bb.Channels[0].Enabled || bb.Channels[1].Exported {
+		t.Errorf("wrong channel state for pwmchip0: %+v", bb.Channels)
+	}
+	if rpi.Channels[0].Exported || !rpi.Channels[1].Exported || rpi.Channels[1].Enabled || len(rpi.Labels) != 0 {
+		t.Errorf("wrong info for pwmchip2: %+v", rpi)
+	}
+}
+
+func Test_FindPWMChip(t *testing.T) {
+	makeFakePWMSysfs(t)
+	for name, chipid := range map[string]int{
+		"ehrpwm0":                           0,
+		"/ocp/epwmss@48300000/pwm@48300200": 0,
+		"20c000.pwm":                        2,
+		"/soc/pwm@7e20c000":                 2,
+	} {
+		if chip, err := FindPWMChip(name); err != nil || chip.Chip != chipid {
+			t.Errorf("FindPWMChip(%s) returned pwmchip%d, %v", name, chip.Chip, err)
+		}
+	}
+	if _, err := FindPWMChip("ehrpwm1"); err == nil {
+		t.Error("ehrpwm1 does not exist")
+	}
+	pwm, err := NewPWMChipPWMByName("ehrpwm0", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwm.Close()
+	if _, err := NewPWMChipPWMByName("ehrpwm0", 2); err == nil {
+		t.Error("pwmchip0 has no channel 2")
+	}
+}
@@ -0,0 +1,118 @@
+package bbhw
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// BBPWMPin on regular files in a temporary directory
+func newTempBBPWMPin(t *testing.T) *BBPWMPin {
+	dir := t.TempDir()
+	open := func(name, content string) *os.File {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+		fd, err := os.OpenFile(path, os.O_RDWR|os.O_SYNC, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+	return &BBPWMPin{fd_period: open("period", "0\n"), fd_duty: open("duty_cycle", "0\n"), fd_polarity: open("polarity", "normal\n"), polarity_names: true}
+}
+
+func Test_BBPWMPin(t *testing.T) {
+	pwm := newTempBBPWMPin(t)
+	var _ PWMControllablePin = pwm
+	if err := pwm.SetPWM(time.Millisecond, 250*time.Microsecond); err != nil {
+		t.Fatal(err)
+	}
+	if period, duty, err := pwm.GetPWM(); period != time.Millisecond || duty != 250*time.Microsecond || err != nil {
+		t.Errorf("GetPWM returned %v, %v, %v", period, duty, err)
+	}
+	var invalid *PWMInvalidSettingError
+	if err := pwm.SetPWM(time.Millisecond, 2*time.Millisecond); !errors.As(err, &invalid) {
+		t.Errorf("duty > period should return PWMInvalidSettingError, got %v", err)
+	}
+	if err := pwm.SetDuty(0.5); err != nil {
+		t.Fatal(err)
+	}
+	if freq, fraction, _ := pwm.GetPWMFreqDuty(); freq != 1000 || fraction != 0.5 {
+		t.Errorf("%f Hz with duty %f", freq, fraction)
+	}
+	if err := pwm.DisablePWM(); err != nil {
+		t.Fatal(err)
+	}
+	if _, duty, _ := pwm.GetPWM(); duty != 0 {
+		t.Error("DisablePWM did not set duty to 0")
+	}
+	if err := pwm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var gone *PWMDeviceGoneError
+	if err := pwm.SetPWM(time.Millisecond, 0); !errors.As(err, &gone) {
+		t.Errorf("closed PWM should return PWMDeviceGoneError, got %v", err)
+	}
+	var rejected *PWMRejectedError
+	einval := &os.PathError{Op: "write", Path: "duty_cycle", Err: syscall.EINVAL}
+	if err := classifyPWMError(pwm.fd_duty, "42", einval); !errors.As(err, &rejected) || rejected.Value != "42" {
+		t.Errorf("EINVAL should become PWMRejectedError, got %v", err)
+	}
+}
+
+func Test_BBPWMPinPolarity(t *testing.T) {
+	makeFakePWMSysfs(t)
+	pwm, err := NewPWMChipPWM(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pwm.Close()
+	polarity := func() string {
+		content, err := os.ReadFile(pwm.fd_polarity.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+	// a pwmchip rejects "0" and "1"
+	if p := polarity(); p != "normal\n" {
+		t.Errorf("pwmchip polarity set to %q instead of normal", p)
+	}
+	if err := pwm.SetPolarity(true); err != nil || polarity() != "inversed\n" {
+		t.Errorf("pwmchip polarity set to %q, %v", polarity(), err)
+	}
+	// the 3.8 pwm_test driver takes 0/1
+	pwm.polarity_names = false
+	if err := pwm.SetPolarity(true); err != nil || polarity() != "1\n" {
+		t.Errorf("pwm_test polarity set to %q, %v", polarity(), err)
+	}
+}
+
+func Test_PWMPinAdapter(t *testing.T) {
+	fake := NewFakePWMOrPanic("P9_14")
+	adapter := NewPWMPinAdapter(fake)
+	var _ PWMPin = adapter
+	adapter.SetPWM(time.Millisecond, 2*time.Millisecond)
+	if adapter.CheckErrorOccurred() == nil {
+		t.Error("adapter should store the error of SetPWM")
+	}
+	if adapter.CheckErrorOccurred() != nil {
+		t.Error("CheckErrorOccurred should clear the error")
+	}
+	adapter.SetPWM(time.Millisecond, time.Microsecond)
+	if period, duty := adapter.GetPWM(); period != time.Millisecond || duty != time.Microsecond {
+		t.Errorf("GetPWM returned %v, %v", period, duty)
+	}
+	if NewPWMControllablePin(adapter) != PWMControllablePin(fake) {
+		t.Error("wrapping an adapter should return the original pin")
+	}
+	adapter.Close()
+	if err := SetPWMFreq(fake, 50); err == nil {
+		t.Error("closed fake should return an error")
+	}
+}
@@ -0,0 +1,388 @@
+package bbhw
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Decodes an incremental encoder connected to two GPIO inputs A and B, plus an optional index input.
+//
+// Uses edge events if all inputs implement GPIOEdgeWatchablePin and polls them otherwise.
+// Position is counted in x1, x2 or x4 mode, i.e. once, twice or four times per A/B cycle.
+// A change of both A and B at once can't be decoded and is counted as illegal transition.
+type QuadratureEncoder struct {
+	a, b, index  GPIOControllablePin
+	mode         int
+	clock        Clock
+	pollinterval time.Duration
+	lock         sync.Mutex
+	state        uint8 // A<<1 | B
+	indexstate   bool
+	position     int64
+	direction    int
+	illegal      uint64
+	indexcount   uint64
+	resetonindex bool
+	lastcount    time.Time     // zero before the first count
+	lastinterval time.Duration // between the last two counts, 0 if unknown
+	err          error
+	watched      []GPIOEdgeWatchablePin
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// counting modes
+const (
+	QUADRATURE_X1 = 1
+	QUADRATURE_X2 = 2
+	QUADRATURE_X4 = 4
+)
+
+const (
+	quadrature_illegal_       = 2
+	quadrature_poll_interval_ = time.Millisecond
+)
+
+// step of the A<<1|B state, indexed by old<<2|new
+// A leading B (00 -> 10 -> 11 -> 01) counts up
+var quadrature_transitions_ = [16]int8{
+	0, -1, 1, quadrature_illegal_,
+	1, 0, quadrature_illegal_, -1,
+	-1, quadrature_illegal_, 0, 1,
+	quadrature_illegal_, 1, -1, 0,
+}
+
+// Start decoding. index may be nil, mode is one of QUADRATURE_X1, QUADRATURE_X2 or QUADRATURE_X4.
+// Pass nil as clock to use SystemClock, which is used for polling and velocity.
+func NewQuadratureEncoder(a, b, index GPIOControllablePin, mode int, clock Clock) (enc *QuadratureEncoder, err error) {
+	if a == nil || b == nil {
+		panic("a == nil || b == nil")
+	}
+	if mode != QUADRATURE_X1 && mode != QUADRATURE_X2 && mode != QUADRATURE_X4 {
+		return nil, fmt.Errorf("Invalid quadrature mode %d", mode)
+	}
+	enc = &QuadratureEncoder{a: a, b: b, index: index, mode: mode, clock: clockOrSystemClock(clock), pollinterval: quadrature_poll_interval_}
+	enc.state, enc.indexstate, err = enc.read()
+	if err != nil {
+		return nil, err
+	}
+	enc.stop = make(chan struct{})
+	enc.done = make(chan struct{})
+	if events, ok := enc.watchEdges(); ok {
+		go enc.decodeEvents(events)
+	} else {
+		go enc.poll()
+	}
+	return enc, nil
+}
+
+// Wrapper around NewQuadratureEncoder. Does not return an error but panics instead.
+func NewQuadratureEncoderOrPanic(a, b, index GPIOControllablePin, mode int, clock Clock) (enc *QuadratureEncoder) {
+	enc, err := NewQuadratureEncoder(a, b, index, mode, clock)
+	if err != nil {
+		panic(err)
+	}
+	return enc
+}
+
+func (enc *QuadratureEncoder) read() (state uint8, index bool, err error) {
+	var a, b bool
+	if a, err = enc.a.GetState(); err != nil {
+		return
+	}
+	if b, err = enc.b.GetState(); err != nil {
+		return
+	}
+	if a {
+		state |= 2
+	}
+	if b {
+		state |= 1
+	}
+	if enc.index != nil {
+		index, err = enc.index.GetState()
+	}
+	return
+}
+
+// watch edges of all inputs, returns false if one of them can't
+func (enc *QuadratureEncoder) watchEdges() (events []<-chan GPIOEdgeEvent, ok bool) {
+	pins := []GPIOControllablePin{enc.a, enc.b}
+	if enc.index != nil {
+		pins = append(pins, enc.index)
+	}
+	for _, pin := range pins {
+		watchable, isw := pin.(GPIOEdgeWatchablePin)
+		if !isw {
+			enc.stopWatchingEdges()
+			return nil, false
+		}
+		ch, err := watchable.WatchEdges(EDGE_BOTH)
+		if err != nil {
+			enc.stopWatchingEdges()
+			return nil, false
+		}
+		enc.watched = append(enc.watched, watchable)
+		events = append(events, ch)
+	}
+	return events, true
+}
+
+func (enc *QuadratureEncoder) stopWatchingEdges() {
+	enc.lock.Lock()
+	watched := enc.watched
+	enc.watched = nil
+	enc.lock.Unlock()
+	for _, watchable := range watched {
+		watchable.StopWatchingEdges()
+	}
+}
+
+type quadratureEdge struct {
+	pin int // 0 is A, 1 is B, 2 is index
+	ev  GPIOEdgeEvent
+}
+
+// Events of different inputs arrive on different channels.
+// Everything that is pending is collected and applied in the order of the timestamps.
+func (enc *QuadratureEncoder) decodeEvents(events []<-chan GPIOEdgeEvent) {
+	var chans [3]<-chan GPIOEdgeEvent
+	copy(chans[:], events)
+	for {
+		var pending []quadratureEdge
+		var ev GPIOEdgeEvent
+		ok := true
+		pin := 0
+		select {
+		case <-enc.stop:
+			enc.stopWatchingEdges()
+			close(enc.done)
+			return
+		case ev, ok = <-chans[0]:
+		case ev, ok = <-chans[1]:
+			pin = 1
+		case ev, ok = <-chans[2]:
+			pin = 2
+		}
+		if !ok {
+			// somebody else stopped watching our inputs, poll instead
+			enc.stopWatchingEdges()
+			enc.poll()
+			return
+		}
+		pending = append(pending, quadratureEdge{pin, ev})
+		for pin, ch := range chans {
+			pending = drainQuadratureEdges(ch, pin, pending)
+		}
+		sort.SliceStable(pending, func(i, j int) bool { return pending[i].ev.Timestamp.Before(pending[j].ev.Timestamp) })
+		enc.lock.Lock()
+		now := enc.clock.Now()
+		for _, edge := range pending {
+			rising := edge.ev.Edge == EDGE_RISING
+			switch edge.pin {
+			case 0:
+				enc.update(enc.state&^2|bitIf(rising, 2), now)
+			case 1:
+				enc.update(enc.state&^1|bitIf(rising, 1), now)
+			case 2:
+				enc.updateIndex(rising)
+			}
+		}
+		enc.lock.Unlock()
+	}
+}
+
+func drainQuadratureEdges(ch <-chan GPIOEdgeEvent, pin int, pending []quadratureEdge) []quadratureEdge {
+	for ch != nil {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return pending
+			}
+			pending = append(pending, quadratureEdge{pin, ev})
+		default:
+			return pending
+		}
+	}
+	return pending
+}
+
+func bitIf(cond bool, bit uint8) uint8 {
+	if cond {
+		return bit
+	}
+	return 0
+}
+
+func (enc *QuadratureEncoder) poll() {
+	defer close(enc.done)
+	for {
+		enc.lock.Lock()
+		interval := enc.pollinterval
+		enc.lock.Unlock()
+		timer := enc.clock.NewTimer(interval)
+		select {
+		case <-enc.stop:
+			timer.Stop()
+			return
+		case <-timer.C():
+			enc.Sample()
+		}
+	}
+}
+
+// Read the inputs and update the position.
+// Called periodically when polling, but can also be called by you, e.g. to sample the inputs in your own control loop.
+func (enc *QuadratureEncoder) Sample() error {
+	if enc == nil {
+		panic("enc == nil")
+	}
+	state, index, err := enc.read()
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	if err != nil {
+		enc.err = err
+		return err
+	}
+	enc.update(state, enc.clock.Now())
+	if index != enc.indexstate {
+		enc.updateIndex(index)
+	}
+	return nil
+}
+
+// apply the new A/B state
+// must be called with enc.lock held
+func (enc *QuadratureEncoder) update(state uint8, now time.Time) {
+	old := enc.state
+	enc.state = state
+	step := quadrature_transitions_[old<<2|state]
+	switch {
+	case step == 0:
+		return
+	case step == quadrature_illegal_:
+		enc.illegal++
+		return
+	}
+	enc.direction = int(step)
+	switch enc.mode {
+	case QUADRATURE_X1:
+		// only A changing while B is low
+		if old|state != 2 {
+			return
+		}
+	case QUADRATURE_X2:
+		if (old^state)&2 == 0 {
+			return
+		}
+	}
+	enc.position += int64(step)
+	if !enc.lastcount.IsZero() {
+		enc.lastinterval = now.Sub(enc.lastcount)
+	}
+	enc.lastcount = now
+}
+
+// must be called with enc.lock held
+func (enc *QuadratureEncoder) updateIndex(index bool) {
+	enc.indexstate = index
+	if !index {
+		return
+	}
+	enc.indexcount++
+	if enc.resetonindex {
+		enc.position = 0
+	}
+}
+
+// Position in counts of the selected mode
+func (enc *QuadratureEncoder) Position() int64 {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	return enc.position
+}
+
+func (enc *QuadratureEncoder) SetPosition(position int64) {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	enc.position = position
+}
+
+// 1 if the last transition was A leading B, -1 if B leading A, 0 if nothing has moved yet
+func (enc *QuadratureEncoder) Direction() int {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	return enc.direction
+}
+
+// Counts per second, from the time between the last two counts.
+// Decays towards 0 once the time since the last count is longer than that.
+func (enc *QuadratureEncoder) Velocity() float64 {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	if enc.lastinterval <= 0 {
+		return 0
+	}
+	interval := enc.lastinterval
+	if since := enc.clock.Now().Sub(enc.lastcount); since > interval {
+		interval = since
+	}
+	return float64(enc.direction) * float64(time.Second) / float64(interval)
+}
+
+// Number of times A and B changed at once, i.e. steps we missed
+func (enc *QuadratureEncoder) IllegalTransitionCount() uint64 {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	return enc.illegal
+}
+
+// Number of rising edges of the index input
+func (enc *QuadratureEncoder) IndexCount() uint64 {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	return enc.indexcount
+}
+
+// If set, a rising edge of the index input sets the position to 0
+func (enc *QuadratureEncoder) SetResetOnIndex(reset bool) {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	enc.resetonindex = reset
+}
+
+// Time between two samples if the inputs are polled
+func (enc *QuadratureEncoder) SetPollInterval(interval time.Duration) {
+	if interval <= 0 {
+		panic("interval <= 0")
+	}
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	enc.pollinterval = interval
+}
+
+// Returns true if edge events are used instead of polling
+func (enc *QuadratureEncoder) UsesEdgeEvents() bool {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	return enc.watched != nil
+}
+
+// Returns the last error reading the inputs
+func (enc *QuadratureEncoder) CheckErrorOccurred() error {
+	enc.lock.Lock()
+	defer enc.lock.Unlock()
+	return enc.err
+}
+
+// Stops decoding, does not close the inputs
+func (enc *QuadratureEncoder) Close() {
+	if enc == nil || enc.stop == nil {
+		return
+	}
+	close(enc.stop)
+	<-enc.done
+	enc.stop = nil
+}
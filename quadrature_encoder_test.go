@@ -0,0 +1,127 @@
+package bbhw
+
+import (
+	"testing"
+	"time"
+)
+
+// A/B levels, A leading B counts up
+var quadrature_gray_ = [4][2]bool{{false, false}, {true, false}, {true, true}, {false, true}}
+
+// moves the outputs by steps through the gray code, backwards if steps is negative
+func quadratureMove(a, b GPIOControllablePin, k *int, steps int) {
+	for ; steps != 0; steps -= sign(steps) {
+		*k = (*k + sign(steps) + 4) % 4
+		a.SetState(quadrature_gray_[*k][0])
+		b.SetState(quadrature_gray_[*k][1])
+	}
+}
+
+func sign(i int) int {
+	if i < 0 {
+		return -1
+	}
+	return 1
+}
+
+func Test_QuadratureEncoderFakeGPIO(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	outa, outb, outi := NewFakeGPIO(1, OUT), NewFakeGPIO(2, OUT), NewFakeGPIO(3, OUT)
+	ina, inb, ini := NewFakeGPIO(11, IN), NewFakeGPIO(12, IN), NewFakeGPIO(13, IN)
+	outa.ConnectTo(ina)
+	outb.ConnectTo(inb)
+	outi.ConnectTo(ini)
+	if _, err := NewQuadratureEncoder(ina, inb, nil, 3, clock); err == nil {
+		t.Error("mode 3 should be refused")
+	}
+	enc := NewQuadratureEncoderOrPanic(ina, inb, ini, QUADRATURE_X4, clock)
+	defer enc.Close()
+	if !enc.UsesEdgeEvents() {
+		t.Fatal("FakeGPIO has edge events")
+	}
+	k := 0
+	// the edges are queued faster than they are decoded
+	quadratureMove(outa, outb, &k, 8)
+	waitFor(t, "position 8", func() bool { return enc.Position() == 8 })
+	if enc.Direction() != 1 || enc.IllegalTransitionCount() != 0 {
+		t.Errorf("direction %d, %d illegal transitions", enc.Direction(), enc.IllegalTransitionCount())
+	}
+	quadratureMove(outa, outb, &k, -1)
+	waitFor(t, "position 7", func() bool { return enc.Position() == 7 })
+	if enc.Direction() != -1 {
+		t.Error("expected direction -1")
+	}
+
+	// one count every 2ms
+	for i := 0; i < 3; i++ {
+		quadratureMove(outa, outb, &k, 1)
+		waitFor(t, "count", func() bool { return enc.Position() == int64(8+i) })
+		clock.Advance(2 * time.Millisecond)
+	}
+	if v := enc.Velocity(); v != 500 {
+		t.Errorf("velocity %f instead of 500", v)
+	}
+	clock.Advance(8 * time.Millisecond)
+	if v := enc.Velocity(); v != 100 {
+		t.Errorf("velocity %f should decay to 100", v)
+	}
+
+	enc.SetResetOnIndex(true)
+	outi.SetState(true)
+	waitFor(t, "index", func() bool { return enc.IndexCount() == 1 })
+	if enc.Position() != 0 {
+		t.Error("index did not reset position")
+	}
+	outi.SetState(false)
+
+	enc.Close()
+	if _, err := ina.WatchEdges(EDGE_BOTH); err != nil {
+		t.Error("Close did not stop watching edges")
+	}
+}
+
+func Test_QuadratureEncoderPolling(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	a, b := &lockedTestInput{}, &lockedTestInput{}
+	for _, mode := range []int{QUADRATURE_X1, QUADRATURE_X2, QUADRATURE_X4} {
+		enc := NewQuadratureEncoderOrPanic(a, b, nil, mode, clock)
+		if enc.UsesEdgeEvents() {
+			t.Error("lockedTestInput has no edge events")
+		}
+		k := 0
+		for _, steps := range []int{1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1} {
+			quadratureMove(lockedTestOutput{a}, lockedTestOutput{b}, &k, steps)
+			enc.Sample()
+		}
+		if enc.Position() != int64(mode) {
+			t.Errorf("x%d: expected position %d, got %d", mode, mode, enc.Position())
+		}
+		enc.Close()
+	}
+	if clock.Waiters() != 0 {
+		t.Error("Close should stop the poll timer")
+	}
+
+	enc := NewQuadratureEncoderOrPanic(a, b, nil, QUADRATURE_X4, clock)
+	defer enc.Close()
+	a.set(true)
+	b.set(true)
+	enc.Sample()
+	if enc.IllegalTransitionCount() != 1 || enc.Position() != 0 {
+		t.Error("change of A and B at once should be illegal")
+	}
+	// polled by the encoder
+	a.set(false)
+	waitFor(t, "position 1", func() bool {
+		clock.Advance(quadrature_poll_interval_)
+		return enc.Position() == 1
+	})
+}
+
+// lets quadratureMove drive a lockedTestInput
+type lockedTestOutput struct{ *lockedTestInput }
+
+func (pin lockedTestOutput) SetState(state bool) error {
+	pin.set(state)
+	return nil
+}
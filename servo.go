@@ -0,0 +1,279 @@
+package bbhw
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Hobby or industrial servo driven by the pulse width of a PWM.
+//
+// The angle range maps linearly onto the pulse range. Without pulses (detached) the servo does not hold its position.
+type Servo struct {
+	pwm        PWMControllablePin
+	clock      Clock
+	lock       sync.Mutex
+	frame      time.Duration
+	minpulse   time.Duration
+	maxpulse   time.Duration
+	minangle   float64
+	maxangle   float64
+	pulse      time.Duration
+	attached   bool
+	movecancel context.CancelFunc
+	movedone   chan struct{}
+}
+
+const (
+	servo_default_frame_    = 20 * time.Millisecond
+	servo_default_minpulse_ = 1 * time.Millisecond
+	servo_default_maxpulse_ = 2 * time.Millisecond
+	servo_default_maxangle_ = 180.0
+)
+
+// Servo with a frame of 20ms and pulses from 1ms at 0° to 2ms at 180°. Starts detached.
+// Use NewPWMControllablePin to drive it from a PWMPin. Pass nil as clock to use SystemClock.
+func NewServo(pwm PWMControllablePin, clock Clock) (servo *Servo, err error) {
+	if pwm == nil {
+		panic("pwm == nil")
+	}
+	servo = &Servo{pwm: pwm, clock: clockOrSystemClock(clock), frame: servo_default_frame_,
+		minpulse: servo_default_minpulse_, maxpulse: servo_default_maxpulse_, maxangle: servo_default_maxangle_}
+	if err = pwm.SetPWM(servo.frame, 0); err != nil {
+		return nil, err
+	}
+	return servo, nil
+}
+
+// Wrapper around NewServo. Does not return an error but panics instead.
+func NewServoOrPanic(pwm PWMControllablePin, clock Clock) *Servo {
+	servo, err := NewServo(pwm, clock)
+	if err != nil {
+		panic(err)
+	}
+	return servo
+}
+
+// Period of the pulses, has to be longer than the longest pulse
+func (servo *Servo) SetFrame(frame time.Duration) error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	if frame <= servo.maxpulse {
+		return fmt.Errorf("frame %v must be longer than the longest pulse %v", frame, servo.maxpulse)
+	}
+	servo.frame = frame
+	return servo.output()
+}
+
+// Pulse widths at the ends of the angle range. Stops a running move and detaches if the current pulse is outside the new range.
+func (servo *Servo) SetPulseRange(minpulse, maxpulse time.Duration) error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	if minpulse <= 0 || maxpulse <= minpulse {
+		return fmt.Errorf("invalid pulse range [%v,%v]", minpulse, maxpulse)
+	}
+	servo.stopMove()
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	if maxpulse >= servo.frame {
+		return fmt.Errorf("pulse %v does not fit into frame %v", maxpulse, servo.frame)
+	}
+	servo.minpulse, servo.maxpulse = minpulse, maxpulse
+	if servo.pulse < minpulse || servo.pulse > maxpulse {
+		servo.attached = false
+	}
+	return servo.output()
+}
+
+// Angles at minpulse and maxpulse, e.g. -90 and 90. minangle may be larger than maxangle for a servo turning the other way.
+func (servo *Servo) SetAngleRange(minangle, maxangle float64) error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	if minangle == maxangle {
+		return fmt.Errorf("angle range must not be empty")
+	}
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	servo.minangle, servo.maxangle = minangle, maxangle
+	return nil
+}
+
+func (servo *Servo) angleToPulse(angle float64) (time.Duration, error) {
+	fraction := (angle - servo.minangle) / (servo.maxangle - servo.minangle)
+	if fraction < 0 || fraction > 1 {
+		return 0, fmt.Errorf("angle %f is out of range [%f,%f]", angle, servo.minangle, servo.maxangle)
+	}
+	return servo.minpulse + time.Duration(fraction*float64(servo.maxpulse-servo.minpulse)), nil
+}
+
+func (servo *Servo) pulseToAngle(pulse time.Duration) float64 {
+	fraction := float64(pulse-servo.minpulse) / float64(servo.maxpulse-servo.minpulse)
+	return servo.minangle + fraction*(servo.maxangle-servo.minangle)
+}
+
+// must be called with servo.lock held
+func (servo *Servo) output() error {
+	if !servo.attached {
+		return servo.pwm.SetPWM(servo.frame, 0)
+	}
+	return servo.pwm.SetPWM(servo.frame, servo.pulse)
+}
+
+// Stops a running move and sends pulses of the given width
+func (servo *Servo) SetPulse(pulse time.Duration) error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.stopMove()
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	if pulse < servo.minpulse || pulse > servo.maxpulse {
+		return fmt.Errorf("pulse %v is out of range [%v,%v]", pulse, servo.minpulse, servo.maxpulse)
+	}
+	servo.pulse, servo.attached = pulse, true
+	return servo.output()
+}
+
+// Stops a running move and turns to angle as fast as the servo can
+func (servo *Servo) SetAngle(angle float64) error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.lock.Lock()
+	pulse, err := servo.angleToPulse(angle)
+	servo.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return servo.SetPulse(pulse)
+}
+
+// Current pulse width, 0 if detached
+func (servo *Servo) Pulse() time.Duration {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	if !servo.attached {
+		return 0
+	}
+	return servo.pulse
+}
+
+// Angle of the current pulse width. Meaningless if detached.
+func (servo *Servo) Angle() float64 {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	return servo.pulseToAngle(servo.pulse)
+}
+
+func (servo *Servo) IsAttached() bool {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	return servo.attached
+}
+
+// Stops a running move and the pulses, the servo stops holding its position. SetAngle or SetPulse attach it again.
+func (servo *Servo) Detach() error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	servo.stopMove()
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	servo.attached = false
+	return servo.output()
+}
+
+// Turns to angle with at most speed degrees per second, changing the pulse once per frame.
+// Runs in the background, the channel receives nil once angle is reached, ctx.Err() if cancelled
+// or the error of the PWM. SetAngle, SetPulse, Detach and another MoveTo stop the move, the channel then receives context.Canceled.
+// The servo has to be attached, as its position is not known otherwise.
+func (servo *Servo) MoveTo(ctx context.Context, angle, speed float64) <-chan error {
+	if servo == nil {
+		panic("servo == nil")
+	}
+	result := make(chan error, 1)
+	servo.stopMove()
+	servo.lock.Lock()
+	defer servo.lock.Unlock()
+	target, err := servo.angleToPulse(angle)
+	if err == nil && !servo.attached {
+		err = fmt.Errorf("servo is detached, its position is unknown")
+	} else if err == nil && speed <= 0 {
+		err = fmt.Errorf("speed must be positive")
+	}
+	if err != nil {
+		result <- err
+		return result
+	}
+	// pulse change per frame
+	degrees := speed * servo.frame.Seconds()
+	step := time.Duration(degrees / math.Abs(servo.maxangle-servo.minangle) * float64(servo.maxpulse-servo.minpulse))
+	if step < 1 {
+		step = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	servo.movecancel, servo.movedone = cancel, done
+	go func() {
+		defer close(done)
+		defer cancel()
+		result <- servo.move(ctx, target, step)
+	}()
+	return result
+}
+
+func (servo *Servo) move(ctx context.Context, target, step time.Duration) error {
+	for {
+		servo.lock.Lock()
+		pulse := servo.pulse
+		if target-pulse > step {
+			pulse += step
+		} else if pulse-target > step {
+			pulse -= step
+		} else {
+			pulse = target
+		}
+		servo.pulse = pulse
+		err := servo.output()
+		frame := servo.frame
+		servo.lock.Unlock()
+		if err != nil || pulse == target {
+			return err
+		}
+		timer := servo.clock.NewTimer(frame)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// cancels a running move and waits for it to end
+func (servo *Servo) stopMove() {
+	servo.lock.Lock()
+	cancel, done := servo.movecancel, servo.movedone
+	servo.movecancel, servo.movedone = nil, nil
+	servo.lock.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+}
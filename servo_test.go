@@ -0,0 +1,85 @@
+package bbhw
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_Servo(t *testing.T) {
+	pwm := NewFakePWMOrPanic("P9_14")
+	servo := NewServoOrPanic(pwm, NewFakeClock(time.Now()))
+	if period, duty, _ := pwm.GetPWM(); period != 20*time.Millisecond || duty != 0 || servo.IsAttached() {
+		t.Errorf("new servo should be detached, PWM at %v, %v", period, duty)
+	}
+	if err := servo.SetAngle(90); err != nil {
+		t.Fatal(err)
+	}
+	if _, duty, _ := pwm.GetPWM(); duty != 1500*time.Microsecond || !servo.IsAttached() {
+		t.Errorf("90° should be 1.5ms, got %v", duty)
+	}
+	if err := servo.SetAngle(181); err == nil {
+		t.Error("181° is out of range")
+	}
+	servo.SetPulseRange(500*time.Microsecond, 2500*time.Microsecond)
+	servo.SetAngleRange(-90, 90)
+	servo.SetPulse(2500 * time.Microsecond)
+	if angle := servo.Angle(); angle != 90 {
+		t.Errorf("2.5ms should be 90°, got %f", angle)
+	}
+	servo.SetFrame(10 * time.Millisecond)
+	if period, duty, _ := pwm.GetPWM(); period != 10*time.Millisecond || duty != 2500*time.Microsecond {
+		t.Errorf("PWM at %v, %v after SetFrame", period, duty)
+	}
+	if err := servo.SetFrame(2 * time.Millisecond); err == nil {
+		t.Error("frame shorter than a pulse should be refused")
+	}
+	if err := servo.Detach(); err != nil {
+		t.Fatal(err)
+	}
+	if _, duty, _ := pwm.GetPWM(); duty != 0 || servo.IsAttached() || servo.Pulse() != 0 {
+		t.Error("detached servo should not get pulses")
+	}
+	if err := <-servo.MoveTo(context.Background(), 0, 100); err == nil {
+		t.Error("detached servo can't move")
+	}
+}
+
+func Test_ServoMoveTo(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	pwm := NewFakePWMOrPanic("P9_14")
+	servo := NewServoOrPanic(pwm, clock)
+	servo.SetAngle(0)
+	// 9° or 50µs per frame of 20ms
+	result := servo.MoveTo(context.Background(), 45, 450)
+	for i := 1; i < 5; i++ {
+		waitFor(t, "next frame", func() bool { return clock.Waiters() == 1 })
+		if _, duty, _ := pwm.GetPWM(); duty != time.Millisecond+time.Duration(i)*50*time.Microsecond {
+			t.Fatalf("frame %d: pulse %v", i, duty)
+		}
+		clock.Advance(20 * time.Millisecond)
+	}
+	if err := <-result; err != nil || servo.Angle() != 45 || servo.Pulse() != 1250*time.Microsecond {
+		t.Errorf("move ended at %f with %v", servo.Angle(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result = servo.MoveTo(ctx, 0, 450)
+	waitFor(t, "first frame", func() bool { return clock.Waiters() == 1 })
+	clock.Advance(20 * time.Millisecond)
+	waitFor(t, "second frame", func() bool { return clock.Waiters() == 1 })
+	cancel()
+	if err := <-result; err != context.Canceled || servo.Angle() != 27 {
+		t.Errorf("cancelled move ended at %f with %v", servo.Angle(), err)
+	}
+
+	result = servo.MoveTo(context.Background(), 180, 10)
+	waitFor(t, "move", func() bool { return clock.Waiters() == 1 })
+	servo.SetAngle(90)
+	if err := <-result; err != context.Canceled {
+		t.Errorf("SetAngle should stop the move, got %v", err)
+	}
+	if _, duty, _ := pwm.GetPWM(); duty != 1500*time.Microsecond || clock.Waiters() != 0 {
+		t.Errorf("pulse %v after SetAngle", duty)
+	}
+}
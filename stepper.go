@@ -0,0 +1,406 @@
+package bbhw
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Driver for stepper motor controllers with step, direction and optional enable input.
+//
+// Steps are generated with a GPIO, or with a PWM whose period is changed every step.
+// Speeds are given in steps per second, acceleration in steps per second².
+// Moves accelerate and decelerate according to the StepperProfile and can be cancelled through their context.
+type Stepper struct {
+	step        GPIOControllablePin
+	steppwm     PWMControllablePin
+	dir         GPIOControllablePin
+	enable      GPIOControllablePin
+	clock       Clock
+	lock        sync.Mutex
+	profile     StepperProfile
+	position    int64
+	limits      bool
+	minposition int64
+	maxposition int64
+	dirinverted bool
+	moving      bool
+}
+
+type StepperProfile struct {
+	Shape        int     // STEPPER_TRAPEZOIDAL or STEPPER_SCURVE
+	MaxSpeed     float64 // steps/s
+	Acceleration float64 // steps/s², 0 moves at MaxSpeed all the time. Peak acceleration for STEPPER_SCURVE
+	StartSpeed   float64 // steps/s of the first and last step, 0 means the speed of the first step accelerating from standstill
+}
+
+// Acceleration profiles.
+// STEPPER_TRAPEZOIDAL uses constant acceleration.
+// STEPPER_SCURVE raises and lowers the acceleration smoothly, which takes about twice the distance to reach MaxSpeed.
+const (
+	STEPPER_TRAPEZOIDAL = iota
+	STEPPER_SCURVE
+)
+
+var stepper_default_profile_ = StepperProfile{Shape: STEPPER_TRAPEZOIDAL, MaxSpeed: 200, Acceleration: 400}
+
+// Create a Stepper with a GPIO as step output. enable may be nil.
+// Pass nil as clock to use SystemClock.
+func NewStepper(step, dir, enable GPIOControllablePin, clock Clock) (stepper *Stepper, err error) {
+	if step == nil {
+		panic("step == nil")
+	}
+	return newStepper(step, nil, dir, enable, clock)
+}
+
+// Create a Stepper with a PWM as step output. enable may be nil.
+// The PWM runs with a period of one step at a time, so the number of steps depends on the precision of the clock.
+func NewPWMStepper(step PWMControllablePin, dir, enable GPIOControllablePin, clock Clock) (stepper *Stepper, err error) {
+	if step == nil {
+		panic("step == nil")
+	}
+	return newStepper(nil, step, dir, enable, clock)
+}
+
+func newStepper(step GPIOControllablePin, steppwm PWMControllablePin, dir, enable GPIOControllablePin, clock Clock) (stepper *Stepper, err error) {
+	if dir == nil {
+		panic("dir == nil")
+	}
+	stepper = &Stepper{step: step, steppwm: steppwm, dir: dir, enable: enable, clock: clockOrSystemClock(clock), profile: stepper_default_profile_}
+	if step != nil {
+		if err = step.SetState(false); err != nil {
+			return nil, err
+		}
+	}
+	return stepper, nil
+}
+
+// Wrapper around NewStepper. Does not return an error but panics instead.
+func NewStepperOrPanic(step, dir, enable GPIOControllablePin, clock Clock) (stepper *Stepper) {
+	stepper, err := NewStepper(step, dir, enable, clock)
+	if err != nil {
+		panic(err)
+	}
+	return stepper
+}
+
+func (p StepperProfile) check() error {
+	if p.Shape != STEPPER_TRAPEZOIDAL && p.Shape != STEPPER_SCURVE {
+		return fmt.Errorf("Invalid stepper profile shape %d", p.Shape)
+	}
+	if p.MaxSpeed <= 0 || p.Acceleration < 0 || p.StartSpeed < 0 || p.StartSpeed > p.MaxSpeed {
+		return fmt.Errorf("Invalid stepper profile %+v", p)
+	}
+	return nil
+}
+
+func (p StepperProfile) startSpeed() float64 {
+	if p.StartSpeed > 0 {
+		return p.StartSpeed
+	}
+	// a trapezoidal ramp from standstill takes sqrt(2/a) for the first step
+	return math.Min(math.Sqrt(p.Acceleration/2), p.MaxSpeed)
+}
+
+// speed after ramp steps of acceleration
+func (p StepperProfile) speedAt(ramp int64) float64 {
+	if p.Acceleration == 0 {
+		return p.MaxSpeed
+	}
+	v0 := p.startSpeed()
+	switch p.Shape {
+	case STEPPER_SCURVE:
+		distance := p.scurveDistance()
+		if distance <= 0 {
+			return p.MaxSpeed
+		}
+		x := math.Min(float64(ramp)/distance, 1)
+		return v0 + (p.MaxSpeed-v0)*x*x*(3-2*x)
+	default:
+		return math.Min(math.Sqrt(v0*v0+2*p.Acceleration*float64(ramp)), p.MaxSpeed)
+	}
+}
+
+// smoothstep s(x) over distance, the acceleration v*dv/ds = (v0 + dv*s(x))*dv*s'(x)/distance
+// stays below (1.5*v0 + 0.9903*dv)*dv/distance, which is what we make equal to Acceleration
+func (p StepperProfile) scurveDistance() float64 {
+	v0 := p.startSpeed()
+	dv := p.MaxSpeed - v0
+	return (1.5*v0 + 0.9903*dv) * dv / p.Acceleration
+}
+
+// number of steps of acceleration until MaxSpeed is reached
+func (p StepperProfile) rampSteps() int64 {
+	if p.Acceleration == 0 {
+		return 0
+	}
+	switch p.Shape {
+	case STEPPER_SCURVE:
+		return int64(math.Ceil(math.Max(p.scurveDistance(), 0)))
+	default:
+		v0 := p.startSpeed()
+		return int64(math.Ceil((p.MaxSpeed*p.MaxSpeed - v0*v0) / (2 * p.Acceleration)))
+	}
+}
+
+// Time between the start of step i and the next step of a move of n steps.
+// Deceleration mirrors acceleration, so short moves don't reach MaxSpeed.
+func (p StepperProfile) StepInterval(i, n int64) time.Duration {
+	ramp := i
+	if n-1-i < ramp {
+		ramp = n - 1 - i
+	}
+	if ramp < 0 {
+		ramp = 0
+	}
+	return time.Duration(float64(time.Second) / p.speedAt(ramp))
+}
+
+func (stepper *Stepper) SetProfile(profile StepperProfile) error {
+	if err := profile.check(); err != nil {
+		return err
+	}
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	stepper.profile = profile
+	return nil
+}
+
+func (stepper *Stepper) GetProfile() StepperProfile {
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	return stepper.profile
+}
+
+// Moves to positions outside of min and max are refused
+func (stepper *Stepper) SetSoftLimits(min, max int64) error {
+	if min > max {
+		return fmt.Errorf("soft limits %d > %d", min, max)
+	}
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	stepper.limits = true
+	stepper.minposition, stepper.maxposition = min, max
+	return nil
+}
+
+func (stepper *Stepper) ClearSoftLimits() {
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	stepper.limits = false
+}
+
+// Position in steps, updated with every step while moving
+func (stepper *Stepper) Position() int64 {
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	return stepper.position
+}
+
+func (stepper *Stepper) SetPosition(position int64) {
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	stepper.position = position
+}
+
+// Swap the meaning of the direction output
+func (stepper *Stepper) SetDirectionInverted(inverted bool) {
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	stepper.dirinverted = inverted
+}
+
+// Sets the enable output, if there is one. Moves enable the driver and leave it enabled to hold the position.
+func (stepper *Stepper) SetEnabled(enabled bool) error {
+	if stepper.enable == nil {
+		return nil
+	}
+	return stepper.enable.SetState(enabled)
+}
+
+func (stepper *Stepper) IsMoving() bool {
+	stepper.lock.Lock()
+	defer stepper.lock.Unlock()
+	return stepper.moving
+}
+
+// Move to an absolute position
+func (stepper *Stepper) MoveTo(ctx context.Context, position int64) error {
+	return stepper.move(ctx, func(current int64) int64 {
+		return position - current
+	})
+}
+
+// Move by steps, backwards if negative.
+// If ctx is cancelled, the stepper decelerates to a stop and ctx.Err() is returned.
+func (stepper *Stepper) Move(ctx context.Context, steps int64) error {
+	return stepper.move(ctx, func(current int64) int64 {
+		return steps
+	})
+}
+
+// steps turns the current position into the steps to move, checked against the soft limits
+func (stepper *Stepper) move(ctx context.Context, steps func(current int64) int64) error {
+	stepper.lock.Lock()
+	profile := stepper.profile
+	stepper.lock.Unlock()
+	return stepper.run(ctx, func(current int64) (int64, error) {
+		n := steps(current)
+		if stepper.limits && (current+n < stepper.minposition || current+n > stepper.maxposition) {
+			return 0, fmt.Errorf("Position %d is outside of the soft limits %d..%d", current+n, stepper.minposition, stepper.maxposition)
+		}
+		return n, nil
+	}, profile.rampSteps(), func(i, n int64) (time.Duration, bool) {
+		return profile.StepInterval(i, n), false
+	})
+}
+
+// Move towards the end-stop at constant speed until it reads true, then set the position to 0.
+// towards is 1 or -1. Soft limits are ignored. Returns an error if the end-stop is not reached within maxsteps.
+func (stepper *Stepper) Home(ctx context.Context, endstop GPIOControllablePin, towards int, speed float64, maxsteps int64) error {
+	if towards != 1 && towards != -1 {
+		return fmt.Errorf("towards must be 1 or -1")
+	}
+	if speed <= 0 || maxsteps <= 0 {
+		return fmt.Errorf("Invalid homing speed %f or maxsteps %d", speed, maxsteps)
+	}
+	interval := time.Duration(float64(time.Second) / speed)
+	var readerr error
+	// constant speed, so a cancel stops right away
+	err := stepper.run(ctx, func(int64) (int64, error) {
+		return int64(towards) * maxsteps, nil
+	}, 0, func(i, n int64) (time.Duration, bool) {
+		reached, err := endstop.GetState()
+		if err != nil {
+			readerr = err
+			return 0, true
+		}
+		return interval, reached
+	})
+	if err != nil {
+		return err
+	}
+	if readerr != nil {
+		return readerr
+	}
+	if reached, err := endstop.GetState(); err != nil {
+		return err
+	} else if !reached {
+		return fmt.Errorf("End-stop not reached within %d steps", maxsteps)
+	}
+	stepper.SetPosition(0)
+	return nil
+}
+
+// Issue steps, interval returns the time until the next step or true to stop before step i.
+// target returns the steps to move from the current position. It is called with the lock held,
+// so no SetPosition or other move comes in between.
+// maxramp is the number of steps it takes to accelerate to full speed, and so to decelerate from it when cancelled.
+func (stepper *Stepper) run(ctx context.Context, target func(current int64) (int64, error), maxramp int64, interval func(i, n int64) (time.Duration, bool)) (err error) {
+	stepper.lock.Lock()
+	if stepper.moving {
+		stepper.lock.Unlock()
+		return fmt.Errorf("Stepper is already moving")
+	}
+	steps, err := target(stepper.position)
+	if err != nil || steps == 0 {
+		stepper.lock.Unlock()
+		return err
+	}
+	stepper.moving = true
+	forward := steps > 0
+	dirstate := forward != stepper.dirinverted
+	stepper.lock.Unlock()
+	defer func() {
+		stepper.lock.Lock()
+		stepper.moving = false
+		stepper.lock.Unlock()
+	}()
+
+	if err = stepper.SetEnabled(true); err != nil {
+		return
+	}
+	if err = stepper.dir.SetState(dirstate); err != nil {
+		return
+	}
+	if stepper.steppwm != nil {
+		defer func() {
+			if disableerr := stepper.steppwm.DisablePWM(); err == nil {
+				err = disableerr
+			}
+		}()
+	}
+	n := steps
+	if n < 0 {
+		n = -n
+	}
+	var cancelled error
+	deadline := stepper.clock.Now()
+	for i := int64(0); i < n; i++ {
+		if cancelled == nil {
+			select {
+			case <-ctx.Done():
+				// decelerate over as many steps as it took to get to the current speed:
+				// i while accelerating, maxramp while cruising, what is left while already decelerating
+				cancelled = ctx.Err()
+				ramp := i
+				if maxramp < ramp {
+					ramp = maxramp
+				}
+				if n-i < ramp {
+					ramp = n - i
+				}
+				n = i + ramp
+				if i >= n {
+					// still at standstill
+					return cancelled
+				}
+			default:
+			}
+		}
+		d, stop := interval(i, n)
+		if stop {
+			break
+		}
+		if err = stepper.pulse(deadline, d); err != nil {
+			return
+		}
+		deadline = deadline.Add(d)
+		stepper.lock.Lock()
+		if forward {
+			stepper.position++
+		} else {
+			stepper.position--
+		}
+		stepper.lock.Unlock()
+	}
+	return cancelled
+}
+
+// one step starting at deadline, lasting d
+func (stepper *Stepper) pulse(start time.Time, d time.Duration) (err error) {
+	stepper.sleepUntil(start)
+	if stepper.steppwm != nil {
+		if err = stepper.steppwm.SetPWM(d, d/2); err != nil {
+			return
+		}
+	} else {
+		if err = stepper.step.SetState(true); err != nil {
+			return
+		}
+		stepper.sleepUntil(start.Add(d / 2))
+		if err = stepper.step.SetState(false); err != nil {
+			return
+		}
+	}
+	stepper.sleepUntil(start.Add(d))
+	return nil
+}
+
+func (stepper *Stepper) sleepUntil(t time.Time) {
+	if d := t.Sub(stepper.clock.Now()); d > 0 {
+		stepper.clock.Sleep(d)
+	}
+}
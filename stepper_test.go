@@ -0,0 +1,251 @@
+package bbhw
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// step output which records the time of every rising edge
+type stepRecorder struct {
+	*FakeGPIO
+	clock   Clock
+	rises   []time.Time
+	onrise  func(n int)
+	current bool
+}
+
+func newStepRecorder(clock Clock) *stepRecorder {
+	return &stepRecorder{FakeGPIO: NewFakeGPIO(1, OUT), clock: clock}
+}
+
+func (rec *stepRecorder) SetState(state bool) error {
+	if state && !rec.current {
+		rec.rises = append(rec.rises, rec.clock.Now())
+		if rec.onrise != nil {
+			rec.onrise(len(rec.rises))
+		}
+	}
+	rec.current = state
+	return rec.FakeGPIO.SetState(state)
+}
+
+func (rec *stepRecorder) intervals() (intervals []time.Duration) {
+	for i := 1; i < len(rec.rises); i++ {
+		intervals = append(intervals, rec.rises[i].Sub(rec.rises[i-1]))
+	}
+	return
+}
+
+// checks that a move speeds up, reaches mininterval and slows down symmetrically,
+// returns the highest acceleration between two steps
+func checkStepIntervals(t *testing.T, intervals []time.Duration, mininterval time.Duration) (maxaccel float64) {
+	t.Helper()
+	n := len(intervals)
+	// the interval after the last step can not be seen, so step i mirrors step n-i
+	for i := 0; i < n/2; i++ {
+		if i > 0 {
+			if d := intervals[i] - intervals[n-i]; d > time.Microsecond || d < -time.Microsecond {
+				t.Fatalf("deceleration does not mirror acceleration at step %d: %v != %v", i, intervals[i], intervals[n-i])
+			}
+		}
+		if i > 0 && intervals[i] > intervals[i-1] {
+			t.Fatalf("slowing down while accelerating at step %d", i)
+		}
+		v0, v1 := 1/intervals[i].Seconds(), 1/intervals[i+1].Seconds()
+		maxaccel = math.Max(maxaccel, (v1-v0)/intervals[i].Seconds())
+	}
+	if intervals[n/2] != mininterval {
+		t.Errorf("MaxSpeed not reached, interval %v instead of %v", intervals[n/2], mininterval)
+	}
+	return
+}
+
+func newTestStepper(t *testing.T) (*Stepper, *stepRecorder, *FakeGPIO, *FakeGPIO, *FakeClock) {
+	clock := NewFakeClock(time.Now())
+	step := newStepRecorder(clock)
+	dir, enable := NewFakeGPIO(2, OUT), NewFakeGPIO(3, OUT)
+	stepper := NewStepperOrPanic(step, dir, enable, clock)
+	if err := stepper.SetProfile(StepperProfile{Shape: STEPPER_TRAPEZOIDAL, MaxSpeed: 1000, Acceleration: 10000, StartSpeed: 100}); err != nil {
+		t.Fatal(err)
+	}
+	return stepper, step, dir, enable, clock
+}
+
+func Test_StepperTrapezoidal(t *testing.T) {
+	stepper, step, dir, enable, clock := newTestStepper(t)
+	start := clock.Now()
+	if err := stepper.Move(context.Background(), 200); err != nil {
+		t.Fatal(err)
+	}
+	if stepper.Position() != 200 || len(step.rises) != 200 {
+		t.Errorf("position %d after %d steps", stepper.Position(), len(step.rises))
+	}
+	if !GetStateOrPanic(dir) || !GetStateOrPanic(enable) || GetStateOrPanic(step) {
+		t.Error("expected dir and enable high and step low after move")
+	}
+	intervals := step.intervals()
+	if intervals[0] != 10*time.Millisecond {
+		t.Errorf("first step at StartSpeed should take 10ms, took %v", intervals[0])
+	}
+	if accel := checkStepIntervals(t, intervals, time.Millisecond); accel > 10000 {
+		t.Errorf("acceleration %f exceeds profile", accel)
+	}
+	var total time.Duration
+	for i := int64(0); i < 200; i++ {
+		total += stepper.GetProfile().StepInterval(i, 200)
+	}
+	if clock.Now().Sub(start) != total {
+		t.Errorf("move took %v instead of %v", clock.Now().Sub(start), total)
+	}
+}
+
+func Test_StepperSCurve(t *testing.T) {
+	stepper, step, _, _, _ := newTestStepper(t)
+	profile := stepper.GetProfile()
+	profile.Shape = STEPPER_SCURVE
+	stepper.SetProfile(profile)
+	stepper.Move(context.Background(), 200)
+	intervals := step.intervals()
+	if accel := checkStepIntervals(t, intervals, time.Millisecond); accel > 10000 {
+		t.Errorf("acceleration %f exceeds profile", accel)
+	}
+	// acceleration starts gently
+	v0, v1 := 1/intervals[0].Seconds(), 1/intervals[1].Seconds()
+	if accel := (v1 - v0) / intervals[0].Seconds(); accel > 1000 {
+		t.Errorf("initial acceleration %f too high for an S-curve", accel)
+	}
+}
+
+func Test_StepperMoveTo(t *testing.T) {
+	stepper, step, dir, _, _ := newTestStepper(t)
+	stepper.SetPosition(10)
+	stepper.SetSoftLimits(-20, 20)
+	if err := stepper.MoveTo(context.Background(), 21); err == nil {
+		t.Error("move beyond soft limit should fail")
+	}
+	if err := stepper.MoveTo(context.Background(), -20); err != nil {
+		t.Fatal(err)
+	}
+	if stepper.Position() != -20 || len(step.rises) != 30 || GetStateOrPanic(dir) {
+		t.Errorf("position %d after %d steps, dir %v", stepper.Position(), len(step.rises), GetStateOrPanic(dir))
+	}
+	stepper.ClearSoftLimits()
+	stepper.SetDirectionInverted(true)
+	stepper.Move(context.Background(), -1)
+	if !GetStateOrPanic(dir) {
+		t.Error("direction not inverted")
+	}
+	if err := stepper.SetProfile(StepperProfile{MaxSpeed: 100, StartSpeed: 200}); err == nil {
+		t.Error("StartSpeed > MaxSpeed should be refused")
+	}
+}
+
+func Test_StepperCancel(t *testing.T) {
+	stepper, step, _, _, _ := newTestStepper(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	step.onrise = func(n int) {
+		if n == 30 {
+			cancel()
+		}
+	}
+	if err := stepper.Move(ctx, 1000); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if stepper.Position() != int64(len(step.rises)) || stepper.Position() != 60 {
+		t.Errorf("position %d after %d steps, expected 60", stepper.Position(), len(step.rises))
+	}
+	intervals := step.intervals()
+	if intervals[len(intervals)-1] != intervals[1] {
+		t.Errorf("did not decelerate to StartSpeed, last interval %v", intervals[len(intervals)-1])
+	}
+	if err := stepper.Move(ctx, 10); err != context.Canceled || stepper.Position() != 60 {
+		t.Error("cancelled move should not step at all")
+	}
+}
+
+func Test_StepperCancelCruising(t *testing.T) {
+	stepper, step, _, _, _ := newTestStepper(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	step.onrise = func(n int) {
+		if n == 500 {
+			cancel()
+		}
+	}
+	if err := stepper.Move(ctx, 1000); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	// from 100 to 1000 steps/s at 10000 steps/s² takes 50 steps
+	if stepper.Position() != 550 || len(step.rises) != 550 {
+		t.Errorf("position %d after %d steps, expected 550", stepper.Position(), len(step.rises))
+	}
+	intervals := step.intervals()
+	if intervals[498] != time.Millisecond || intervals[len(intervals)-1] != intervals[1] {
+		t.Errorf("should decelerate from MaxSpeed to StartSpeed, intervals %v and %v", intervals[498], intervals[len(intervals)-1])
+	}
+}
+
+func Test_StepperHome(t *testing.T) {
+	stepper, step, _, _, _ := newTestStepper(t)
+	endstop := NewFakeGPIO(4, IN)
+	step.onrise = func(n int) {
+		if n == 42 {
+			endstop.FakeInput(true)
+		}
+	}
+	stepper.SetPosition(1000)
+	if err := stepper.Home(context.Background(), endstop, -1, 500, 100); err != nil {
+		t.Fatal(err)
+	}
+	if stepper.Position() != 0 || len(step.rises) != 42 {
+		t.Errorf("position %d after %d steps", stepper.Position(), len(step.rises))
+	}
+	for _, interval := range step.intervals() {
+		if interval != 2*time.Millisecond {
+			t.Fatalf("homing should run at constant speed, got interval %v", interval)
+		}
+	}
+	endstop.FakeInput(false)
+	if err := stepper.Home(context.Background(), endstop, 1, 500, 10); err == nil {
+		t.Error("expected error when end-stop is not reached")
+	}
+}
+
+// PWM which records every period it is set to
+type periodRecorder struct {
+	*FakePWMPin
+	periods []time.Duration
+}
+
+func (pwm *periodRecorder) SetPWM(period, duty time.Duration) error {
+	pwm.periods = append(pwm.periods, period)
+	return pwm.FakePWMPin.SetPWM(period, duty)
+}
+
+func Test_PWMStepper(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	pwm := &periodRecorder{FakePWMPin: NewFakePWMOrPanic("P9_14")}
+	stepper, err := NewPWMStepper(pwm, NewFakeGPIO(2, OUT), nil, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stepper.Move(context.Background(), 50)
+	if len(pwm.periods) != 50 {
+		t.Fatalf("expected 50 periods, got %d", len(pwm.periods))
+	}
+	for i, period := range pwm.periods {
+		if period != stepper.GetProfile().StepInterval(int64(i), 50) {
+			t.Errorf("step %d: period %v", i, period)
+		}
+	}
+	if _, duty, _ := pwm.GetPWM(); duty != 0 {
+		t.Error("PWM still running after move")
+	}
+	pwm.SimulateError(&PWMDeviceGoneError{Path: "pwm0"})
+	var gone *PWMDeviceGoneError
+	if err := stepper.Move(context.Background(), 5); !errors.As(err, &gone) || stepper.Position() != 50 {
+		t.Errorf("failing PWM should stop the move, got %v at position %d", err, stepper.Position())
+	}
+}
@@ -0,0 +1,27 @@
+package bbhw
+
+import (
+	"path/filepath"
+)
+
+// All drivers which use /sys, /proc or the device-tree resolve their paths below this root.
+// Change it with SetFilesystemRoot to run against a copy of a BeagleBone's sysfs, e.g. in tests.
+var filesystem_root_ = "/"
+
+// Sets the directory that /sys and /proc are looked up in, "/" by default.
+// Only affects devices opened afterwards. Also forgets the cached locations of the ocp directory and the slots file.
+// /dev is not affected, GPIO chardevs and /dev/mem can't be faked with files.
+func SetFilesystemRoot(root string) {
+	filesystem_root_ = filepath.Clean(root)
+	dtsslot_slots_file_ = ""
+	dtsslot_ocp_dir_ = ""
+}
+
+func GetFilesystemRoot() string {
+	return filesystem_root_
+}
+
+// absolute path like /sys/class/gpio below the filesystem root
+func rootedPath(path string) string {
+	return filepath.Join(filesystem_root_, path)
+}
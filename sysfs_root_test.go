@@ -0,0 +1,155 @@
+package bbhw
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// points the filesystem root at a new temporary directory with the given files and returns it
+func useFakeFilesystemRoot(t *testing.T, files map[string]string) string {
+	orig := GetFilesystemRoot()
+	t.Cleanup(func() { SetFilesystemRoot(orig) })
+	root := t.TempDir()
+	SetFilesystemRoot(root)
+	for name, content := range files {
+		writeFakeFile(t, root, name, content)
+	}
+	return root
+}
+
+func writeFakeFile(t *testing.T, root, name, content string) {
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFakeFile(t *testing.T, root, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+// what every kernel has the same way
+var fake_sysfs_common_ = map[string]string{
+	"/proc/cpuinfo":                                  "processor\t: 0\nmodel name\t: ARMv7 Processor rev 2 (v7l)\nHardware\t: Generic AM33XX (Flattened Device Tree)\n",
+	"/sys/class/gpio/export":                         "",
+	"/sys/class/gpio/gpio30/direction":               "in\n",
+	"/sys/class/gpio/gpio30/value":                   "0\n",
+	"/sys/class/gpio/gpio30/active_low":              "0\n",
+	"/proc/device-tree/ocp/gpio@44e07000/compatible": "ti,omap4-gpio\x00",
+}
+
+type fakeKernel struct {
+	name     string
+	files    map[string]string
+	slots    string // path of the capemgr slots file, empty without capemgr
+	ain      uint
+	adcvalue uint16
+}
+
+var fake_kernels_ = []fakeKernel{
+	{
+		name: "3.8",
+		files: map[string]string{
+			"/sys/devices/bone_capemgr.9/slots":       " 0: 54:PF--- \n 5: ff:P-O-L Override Board Name,00A0,Override Manuf,BB-ADC\n",
+			"/sys/devices/ocp.3/44e07000.gpio/uevent": "",
+		},
+		slots: "/sys/devices/bone_capemgr.9/slots",
+	},
+	{
+		name: "4.4",
+		files: map[string]string{
+			"/sys/devices/platform/bone_capemgr/slots":                                            " 0: PF----  -1\n 5: P-O-L-   0 Override Board Name,00A0,Override Manuf,BB-ADC\n",
+			"/sys/devices/platform/ocp/44e0d000.tscadc/TI-am335x-adc/iio:device0/in_voltage0_raw": "2048\n",
+		},
+		slots:    "/sys/devices/platform/bone_capemgr/slots",
+		ain:      0,
+		adcvalue: 900,
+	},
+	{
+		name: "5.x",
+		files: map[string]string{
+			"/sys/devices/platform/ocp/44c00000.interconnect/uevent": "",
+			"/sys/bus/iio/devices/iio:device0/name":                  "TI-am335x-adc.0.auto\n",
+			"/sys/bus/iio/devices/iio:device0/in_voltage3_raw":       "4095\n",
+		},
+		ain:      3,
+		adcvalue: 1799,
+	},
+}
+
+func Test_FakeFilesystemRoot(t *testing.T) {
+	for _, kernel := range fake_kernels_ {
+		t.Run(kernel.name, func(t *testing.T) {
+			root := useFakeFilesystemRoot(t, fake_sysfs_common_)
+			for name, content := range kernel.files {
+				writeFakeFile(t, root, name, content)
+			}
+
+			// GPIO export and direction
+			gpio, err := NewSysfsGPIO(30, OUT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if direction := readFakeFile(t, root, "/sys/class/gpio/gpio30/direction"); direction != "out\n" {
+				t.Errorf("direction is %q", direction)
+			}
+			if err = gpio.SetState(true); err != nil || readFakeFile(t, root, "/sys/class/gpio/gpio30/value") != "1\n" {
+				t.Errorf("SetState failed: %v", err)
+			}
+			if _, err = NewSysfsGPIO(60, IN); err == nil {
+				t.Error("gpio60 can't appear without a kernel")
+			}
+			if export := readFakeFile(t, root, "/sys/class/gpio/export"); export != "60\n" {
+				t.Errorf("export is %q", export)
+			}
+			if !verifyAddrIsTIOmap4(0x44e07000) {
+				t.Error("device-tree not read below the root")
+			}
+			if infos, err := GetCPUInfos(); err != nil || !strings.Contains(infos["Hardware"][0], "AM33XX") {
+				t.Errorf("GetCPUInfos returned %v, %v", infos, err)
+			}
+
+			// overlays
+			if kernel.slots == "" {
+				if err = AddDeviceTreeOverlay("BB-ADC"); err == nil {
+					t.Error("no capemgr, loading an overlay should fail")
+				}
+			} else {
+				if slot, err := FindDeviceTreeOverlaySlot("BB-ADC"); err != nil || slot != 5 {
+					t.Errorf("BB-ADC found in slot %d, %v", slot, err)
+				}
+				if err = AddDeviceTreeOverlayIfNotAlreadyLoaded("BB-ADC"); err != ERROR_DTO_ALREADY_LOADED {
+					t.Errorf("BB-ADC is already loaded, got %v", err)
+				}
+				if err = AddDeviceTreeOverlayIfNotAlreadyLoaded("BB-UART1"); err != nil {
+					t.Fatal(err)
+				}
+				if slots := readFakeFile(t, root, kernel.slots); slots != "BB-UART1" {
+					t.Errorf("slots file contains %q", slots)
+				}
+			}
+
+			// ADC
+			if kernel.adcvalue == 0 {
+				return
+			}
+			adc, err := NewSysfsADC(kernel.ain)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value, err := adc.ReadValueCheckError(); err != nil || value != kernel.adcvalue {
+				t.Errorf("ADC read %d, %v instead of %d", value, err, kernel.adcvalue)
+			}
+		})
+	}
+}